@@ -35,7 +35,7 @@ func RefreshStack() {
 	sp := riscv.Registers.GetInteger(2)
 
 	for i := 0; i < 32; i++ {
-		off := sp + uint32(i*4)
+		off := sp + uint64(i*4)
 		if int32(off) < 0 {
 			continue
 		}
@@ -59,7 +59,7 @@ func RefreshDebug() {
 	debugText.Clear()
 	debugText.Color = colornames.Black
 	fmt.Fprintf(debugText, "Registers\n\n")
-	for i := 0; i < 32; i++ {
+	for i := 0; i < riscv.NumIntegerRegisters(); i++ {
 		regName := core.GetIntRegisterName(i)
 		debugText.Color = colornames.Black
 		if strings.Contains(asm, regName) {
@@ -82,23 +82,31 @@ func RefreshDisasm() {
 	disasmText.Color = colornames.Black
 	fmt.Fprint(disasmText, "Disassembler: \n")
 
+	off := offset
 	for i := 0; i < 32; i++ {
-		off := offset + uint32(i*4)
+		insLen := uint64(4)
 		if int32(off) < 0 {
+			off += insLen
 			continue
 		}
 		v, err := riscv.Bus.ReadWord(ctx, off)
 		if err != nil {
 			disasmText.Color = colornames.Red
 			fmt.Fprintf(disasmText, "%08x: bus err\n", off)
+			off += insLen
 			continue
 		}
+		if v&3 != 3 { // Compressed instruction
+			v &= 0xFFFF
+			insLen = 2
+		}
 		asm := disasm.Disasm(off, v) + "\n"
 		disasmText.Color = colornames.Black
 		if off == opc {
 			disasmText.Color = colornames.Blue
 		}
 		fmt.Fprintf(disasmText, asm)
+		off += insLen
 	}
 }
 
@@ -144,6 +152,9 @@ func run() {
 
 	// Instanciate the RISC-V Emulator
 	riscv = core.CreateEmulator(log)
+	if err := disasm.SetISA(riscv.XLEN(), riscv.CSR.Get(core.CSRMISA)); err != nil {
+		panic(err)
+	}
 
 	vga := MakePixelVGA(320, 200)                   // Doom runs at 320x200 natively
 	serial := uart.NewUART()                        // UART
@@ -278,11 +289,11 @@ func run() {
 			riscv.Pause()
 		}
 
-		if riscv.Paused() {
+		if riscv.Paused() { // The core state can only be read while paused
 			RefreshDisasm()
 			RefreshStack()
+			RefreshDebug()
 		}
-		RefreshDebug()
 		win.Update()
 		vga.VGA.VBlank(true)
 		time.Sleep(time.Second / 60)
@@ -1,5 +1,7 @@
 package core
 
+import "math/bits"
+
 const (
 	aluINVALID                    = -1
 	aluADD                        = iota
@@ -17,6 +19,38 @@ const (
 	aluGreaterThanOrEqualSigned   = iota
 	aluEqual                      = iota
 	aluNotEqual                   = iota
+	aluMUL                        = iota
+	aluMULH                       = iota
+	aluMULHSU                     = iota
+	aluMULHU                      = iota
+	aluDIV                        = iota
+	aluDIVU                       = iota
+	aluREM                        = iota
+	aluREMU                       = iota
+	aluANDN                       = iota
+	aluORN                        = iota
+	aluXNOR                       = iota
+	aluCLZ                        = iota
+	aluCTZ                        = iota
+	aluCPOP                       = iota
+	aluSEXTB                      = iota
+	aluSEXTH                      = iota
+	aluZEXTH                      = iota
+	aluMAX                        = iota
+	aluMAXU                       = iota
+	aluMIN                        = iota
+	aluMINU                       = iota
+	aluROL                        = iota
+	aluROR                        = iota
+	aluREV8                       = iota
+	aluORCB                       = iota
+	aluSH1ADD                     = iota
+	aluSH2ADD                     = iota
+	aluSH3ADD                     = iota
+	aluBCLR                       = iota
+	aluBEXT                       = iota
+	aluBINV                       = iota
+	aluBSET                       = iota
 )
 
 // alu mimics the hardware ALU operations
@@ -70,12 +104,289 @@ func (rv32 *RISCV) alu(aluOp int, X, Y uint32) uint32 {
 			return 1
 		}
 		return 0
+	case aluMUL:
+		return X * Y
+	case aluMULH:
+		return uint32((int64(int32(X)) * int64(int32(Y))) >> 32)
+	case aluMULHSU:
+		return uint32((int64(int32(X)) * int64(Y)) >> 32)
+	case aluMULHU:
+		return uint32((uint64(X) * uint64(Y)) >> 32)
+	case aluDIV:
+		if Y == 0 { // Division by zero returns all bits set
+			return 0xFFFFFFFF
+		}
+		if X == 0x80000000 && Y == 0xFFFFFFFF { // Overflow returns the dividend
+			return X
+		}
+		return uint32(int32(X) / int32(Y))
+	case aluDIVU:
+		if Y == 0 {
+			return 0xFFFFFFFF
+		}
+		return X / Y
+	case aluREM:
+		if Y == 0 { // Remainder of division by zero is the dividend
+			return X
+		}
+		if X == 0x80000000 && Y == 0xFFFFFFFF { // Overflow has no remainder
+			return 0
+		}
+		return uint32(int32(X) % int32(Y))
+	case aluREMU:
+		if Y == 0 {
+			return X
+		}
+		return X % Y
+	case aluANDN:
+		return X &^ Y
+	case aluORN:
+		return X | ^Y
+	case aluXNOR:
+		return ^(X ^ Y)
+	case aluCLZ:
+		return uint32(bits.LeadingZeros32(X))
+	case aluCTZ:
+		return uint32(bits.TrailingZeros32(X))
+	case aluCPOP:
+		return uint32(bits.OnesCount32(X))
+	case aluSEXTB:
+		return uint32(int32(int8(X)))
+	case aluSEXTH:
+		return uint32(int32(int16(X)))
+	case aluZEXTH:
+		return X & 0xFFFF
+	case aluMAX:
+		if int32(X) > int32(Y) {
+			return X
+		}
+		return Y
+	case aluMAXU:
+		if X > Y {
+			return X
+		}
+		return Y
+	case aluMIN:
+		if int32(X) < int32(Y) {
+			return X
+		}
+		return Y
+	case aluMINU:
+		if X < Y {
+			return X
+		}
+		return Y
+	case aluROL: // Rotates and single bit operations only use the lower bits of Y
+		return bits.RotateLeft32(X, int(Y&31))
+	case aluROR:
+		return bits.RotateLeft32(X, -int(Y&31))
+	case aluREV8:
+		return bits.ReverseBytes32(X)
+	case aluORCB:
+		return uint32(orcb(uint64(X)))
+	case aluSH1ADD:
+		return X<<1 + Y
+	case aluSH2ADD:
+		return X<<2 + Y
+	case aluSH3ADD:
+		return X<<3 + Y
+	case aluBCLR:
+		return X &^ (1 << (Y & 31))
+	case aluBEXT:
+		return (X >> (Y & 31)) & 1
+	case aluBINV:
+		return X ^ (1 << (Y & 31))
+	case aluBSET:
+		return X | (1 << (Y & 31))
 	}
 
 	rv32.log.Errorf("invalid ALU operation %d", aluOp)
 	return 0
 }
 
+// alu64 mimics the hardware ALU operations for RV64
+func (rv32 *RISCV) alu64(aluOp int, X, Y uint64) uint64 {
+	switch aluOp {
+	case aluADD:
+		return X + Y
+	case aluSUB:
+		return X - Y
+	case aluOR:
+		return X | Y
+	case aluXOR:
+		return X ^ Y
+	case aluAND:
+		return X & Y
+	case aluLesserThanUnsigned:
+		if X < Y {
+			return 1
+		}
+		return 0
+	case aluLesserThanSigned:
+		if int64(X) < int64(Y) {
+			return 1
+		}
+		return 0
+	case aluShiftRightUnsigned:
+		return X >> Y
+	case aluShiftRightSigned:
+		return uint64(int64(X) >> Y)
+	case aluShiftLeftUnsigned:
+		return X << Y
+	case aluShiftLeftSigned:
+		return uint64(int64(X) << Y)
+	case aluGreaterThanOrEqualUnsigned:
+		if X >= Y {
+			return 1
+		}
+		return 0
+	case aluGreaterThanOrEqualSigned:
+		if int64(X) >= int64(Y) {
+			return 1
+		}
+		return 0
+	case aluEqual:
+		if X == Y {
+			return 1
+		}
+		return 0
+	case aluNotEqual:
+		if X != Y {
+			return 1
+		}
+		return 0
+	case aluMUL:
+		return X * Y
+	case aluMULH: // Signed high product from the unsigned one
+		hi, _ := bits.Mul64(X, Y)
+		if int64(X) < 0 {
+			hi -= Y
+		}
+		if int64(Y) < 0 {
+			hi -= X
+		}
+		return hi
+	case aluMULHSU:
+		hi, _ := bits.Mul64(X, Y)
+		if int64(X) < 0 {
+			hi -= Y
+		}
+		return hi
+	case aluMULHU:
+		hi, _ := bits.Mul64(X, Y)
+		return hi
+	case aluDIV:
+		if Y == 0 { // Division by zero returns all bits set
+			return 0xFFFFFFFF_FFFFFFFF
+		}
+		if X == 1<<63 && Y == 0xFFFFFFFF_FFFFFFFF { // Overflow returns the dividend
+			return X
+		}
+		return uint64(int64(X) / int64(Y))
+	case aluDIVU:
+		if Y == 0 {
+			return 0xFFFFFFFF_FFFFFFFF
+		}
+		return X / Y
+	case aluREM:
+		if Y == 0 { // Remainder of division by zero is the dividend
+			return X
+		}
+		if X == 1<<63 && Y == 0xFFFFFFFF_FFFFFFFF { // Overflow has no remainder
+			return 0
+		}
+		return uint64(int64(X) % int64(Y))
+	case aluREMU:
+		if Y == 0 {
+			return X
+		}
+		return X % Y
+	case aluANDN:
+		return X &^ Y
+	case aluORN:
+		return X | ^Y
+	case aluXNOR:
+		return ^(X ^ Y)
+	case aluCLZ:
+		return uint64(bits.LeadingZeros64(X))
+	case aluCTZ:
+		return uint64(bits.TrailingZeros64(X))
+	case aluCPOP:
+		return uint64(bits.OnesCount64(X))
+	case aluSEXTB:
+		return uint64(int64(int8(X)))
+	case aluSEXTH:
+		return uint64(int64(int16(X)))
+	case aluZEXTH:
+		return X & 0xFFFF
+	case aluMAX:
+		if int64(X) > int64(Y) {
+			return X
+		}
+		return Y
+	case aluMAXU:
+		if X > Y {
+			return X
+		}
+		return Y
+	case aluMIN:
+		if int64(X) < int64(Y) {
+			return X
+		}
+		return Y
+	case aluMINU:
+		if X < Y {
+			return X
+		}
+		return Y
+	case aluROL: // Rotates and single bit operations only use the lower bits of Y
+		return bits.RotateLeft64(X, int(Y&63))
+	case aluROR:
+		return bits.RotateLeft64(X, -int(Y&63))
+	case aluREV8:
+		return bits.ReverseBytes64(X)
+	case aluORCB:
+		return orcb(X)
+	case aluSH1ADD:
+		return X<<1 + Y
+	case aluSH2ADD:
+		return X<<2 + Y
+	case aluSH3ADD:
+		return X<<3 + Y
+	case aluBCLR:
+		return X &^ (1 << (Y & 63))
+	case aluBEXT:
+		return (X >> (Y & 63)) & 1
+	case aluBINV:
+		return X ^ (1 << (Y & 63))
+	case aluBSET:
+		return X | (1 << (Y & 63))
+	}
+
+	rv32.log.Errorf("invalid ALU operation %d", aluOp)
+	return 0
+}
+
+// aluX runs the ALU operation with the current XLEN
+// In RV32 mode the operands are truncated to 32 bits and the result is zero extended
+func (rv32 *RISCV) aluX(aluOp int, X, Y uint64) uint64 {
+	if rv32.isa.XLEN == 64 {
+		return rv32.alu64(aluOp, X, Y)
+	}
+	return uint64(rv32.alu(aluOp, uint32(X), uint32(Y)))
+}
+
+// orcb sets all bits of each non zero byte (orc.b)
+func orcb(value uint64) uint64 {
+	result := uint64(0)
+	for i := 0; i < 64; i += 8 {
+		if value&(0xFF<<i) != 0 {
+			result |= 0xFF << i
+		}
+	}
+	return result
+}
+
 // signExtend assumes value to be bits length and sign extends to 32 bit
 func signExtend(value, bits uint32) int32 {
 	bits -= 1
@@ -176,6 +176,111 @@ func TestALU(t *testing.T) {
 			t.Errorf("failed aluEqual for X: %d and Y: %d", X, Y)
 		}
 	}
+	//aluMUL                        = iota
+	for i := 0; i < numRounds; i++ {
+		X := rand.Uint32()
+		Y := rand.Uint32()
+
+		if rv32.alu(aluMUL, X, Y) != X*Y {
+			t.Errorf("failed aluMUL for X: %d and Y: %d", X, Y)
+		}
+	}
+	//aluMULH                       = iota
+	for i := 0; i < numRounds; i++ {
+		X := rand.Uint32()
+		Y := rand.Uint32()
+
+		r := uint32((int64(int32(X)) * int64(int32(Y))) >> 32)
+
+		if rv32.alu(aluMULH, X, Y) != r {
+			t.Errorf("failed aluMULH for X: %d and Y: %d", X, Y)
+		}
+	}
+	//aluMULHSU                     = iota
+	for i := 0; i < numRounds; i++ {
+		X := rand.Uint32()
+		Y := rand.Uint32()
+
+		r := uint32((int64(int32(X)) * int64(Y)) >> 32)
+
+		if rv32.alu(aluMULHSU, X, Y) != r {
+			t.Errorf("failed aluMULHSU for X: %d and Y: %d", X, Y)
+		}
+	}
+	//aluMULHU                      = iota
+	for i := 0; i < numRounds; i++ {
+		X := rand.Uint32()
+		Y := rand.Uint32()
+
+		r := uint32((uint64(X) * uint64(Y)) >> 32)
+
+		if rv32.alu(aluMULHU, X, Y) != r {
+			t.Errorf("failed aluMULHU for X: %d and Y: %d", X, Y)
+		}
+	}
+	//aluDIV                        = iota
+	for i := 0; i < numRounds; i++ {
+		X := rand.Uint32()
+		Y := rand.Uint32() | 1
+
+		if rv32.alu(aluDIV, X, Y) != uint32(int32(X)/int32(Y)) {
+			t.Errorf("failed aluDIV for X: %d and Y: %d", X, Y)
+		}
+	}
+	//aluDIVU                       = iota
+	for i := 0; i < numRounds; i++ {
+		X := rand.Uint32()
+		Y := rand.Uint32() | 1
+
+		if rv32.alu(aluDIVU, X, Y) != X/Y {
+			t.Errorf("failed aluDIVU for X: %d and Y: %d", X, Y)
+		}
+	}
+	//aluREM                        = iota
+	for i := 0; i < numRounds; i++ {
+		X := rand.Uint32()
+		Y := rand.Uint32() | 1
+
+		if rv32.alu(aluREM, X, Y) != uint32(int32(X)%int32(Y)) {
+			t.Errorf("failed aluREM for X: %d and Y: %d", X, Y)
+		}
+	}
+	//aluREMU                       = iota
+	for i := 0; i < numRounds; i++ {
+		X := rand.Uint32()
+		Y := rand.Uint32() | 1
+
+		if rv32.alu(aluREMU, X, Y) != X%Y {
+			t.Errorf("failed aluREMU for X: %d and Y: %d", X, Y)
+		}
+	}
+}
+
+func TestALUDivisionCornerCases(t *testing.T) {
+	rv32 := RISCV{}
+
+	tests := []struct {
+		name     string
+		aluOp    int
+		X, Y     uint32
+		expected uint32
+	}{
+		{"aluDIV by zero", aluDIV, 1234, 0, 0xFFFFFFFF},
+		{"aluDIVU by zero", aluDIVU, 1234, 0, 0xFFFFFFFF},
+		{"aluREM by zero", aluREM, 1234, 0, 1234},
+		{"aluREMU by zero", aluREMU, 0xFFFFFFFE, 0, 0xFFFFFFFE},
+		{"aluDIV overflow", aluDIV, 0x80000000, 0xFFFFFFFF, 0x80000000},
+		{"aluREM overflow", aluREM, 0x80000000, 0xFFFFFFFF, 0},
+		{"aluDIV negative", aluDIV, 0xFFFFFFF9, 2, 0xFFFFFFFD},
+		{"aluREM negative", aluREM, 0xFFFFFFF9, 2, 0xFFFFFFFF},
+	}
+
+	for _, test := range tests {
+		got := rv32.alu(test.aluOp, test.X, test.Y)
+		if got != test.expected {
+			t.Errorf("failed %s for X: %08x and Y: %08x: expected %08x got %08x", test.name, test.X, test.Y, test.expected, got)
+		}
+	}
 }
 
 func TestSignExtend(t *testing.T) {
@@ -194,3 +299,76 @@ func TestSignExtend(t *testing.T) {
 		}
 	}
 }
+
+func TestALUBitManip(t *testing.T) {
+	rv32 := RISCV{}
+
+	tests := []struct {
+		name     string
+		aluOp    int
+		X, Y     uint32
+		expected uint32
+	}{
+		{"aluANDN", aluANDN, 0xFF00FF00, 0x0FF00FF0, 0xF000F000},
+		{"aluORN", aluORN, 0x00000001, 0xFFFF0000, 0x0000FFFF},
+		{"aluXNOR", aluXNOR, 0xFF00FF00, 0x0FF00FF0, 0x0F0F0F0F},
+		{"aluCLZ", aluCLZ, 0x00010000, 0, 15},
+		{"aluCLZ zero", aluCLZ, 0, 0, 32},
+		{"aluCTZ", aluCTZ, 0x00010000, 0, 16},
+		{"aluCTZ zero", aluCTZ, 0, 0, 32},
+		{"aluCPOP", aluCPOP, 0xF00F0001, 0, 9},
+		{"aluSEXTB", aluSEXTB, 0x000001FF, 0, 0xFFFFFFFF},
+		{"aluSEXTH", aluSEXTH, 0x00017FFF, 0, 0x00007FFF},
+		{"aluZEXTH", aluZEXTH, 0xFFFF8000, 0, 0x00008000},
+		{"aluMAX", aluMAX, 0xFFFFFFFF, 1, 1},
+		{"aluMAXU", aluMAXU, 0xFFFFFFFF, 1, 0xFFFFFFFF},
+		{"aluMIN", aluMIN, 0xFFFFFFFF, 1, 0xFFFFFFFF},
+		{"aluMINU", aluMINU, 0xFFFFFFFF, 1, 1},
+		{"aluROL", aluROL, 0x80000001, 33, 0x00000003},
+		{"aluROR", aluROR, 0x80000001, 1, 0xC0000000},
+		{"aluREV8", aluREV8, 0x12345678, 0, 0x78563412},
+		{"aluORCB", aluORCB, 0x01000080, 0, 0xFF0000FF},
+		{"aluSH1ADD", aluSH1ADD, 0x10, 1, 0x21},
+		{"aluSH2ADD", aluSH2ADD, 0x10, 1, 0x41},
+		{"aluSH3ADD", aluSH3ADD, 0x10, 1, 0x81},
+		{"aluBCLR", aluBCLR, 0xFFFFFFFF, 36, 0xFFFFFFEF},
+		{"aluBEXT", aluBEXT, 0x00000010, 4, 1},
+		{"aluBINV", aluBINV, 0x00000010, 4, 0},
+		{"aluBSET", aluBSET, 0, 31, 0x80000000},
+	}
+
+	for _, test := range tests {
+		got := rv32.alu(test.aluOp, test.X, test.Y)
+		if got != test.expected {
+			t.Errorf("failed %s for X: %08x and Y: %08x: expected %08x got %08x", test.name, test.X, test.Y, test.expected, got)
+		}
+	}
+
+	tests64 := []struct {
+		name     string
+		aluOp    int
+		X, Y     uint64
+		expected uint64
+	}{
+		{"aluCLZ", aluCLZ, 0x00000000_00010000, 0, 47},
+		{"aluCTZ zero", aluCTZ, 0, 0, 64},
+		{"aluCPOP", aluCPOP, 0xFFFFFFFF_00000001, 0, 33},
+		{"aluSEXTB", aluSEXTB, 0x80, 0, 0xFFFFFFFF_FFFFFF80},
+		{"aluMAX", aluMAX, 0x80000000_00000000, 1, 1},
+		{"aluMINU", aluMINU, 0x80000000_00000000, 1, 1},
+		{"aluROL", aluROL, 0x80000000_00000001, 65, 0x00000000_00000003},
+		{"aluROR", aluROR, 0x00000000_00000001, 32, 0x00000001_00000000},
+		{"aluREV8", aluREV8, 0x01234567_89ABCDEF, 0, 0xEFCDAB89_67452301},
+		{"aluORCB", aluORCB, 0x10000000_00200000, 0, 0xFF000000_00FF0000},
+		{"aluSH3ADD", aluSH3ADD, 0x20000000_00000000, 1, 0x00000000_00000001},
+		{"aluBSET", aluBSET, 0, 63, 0x80000000_00000000},
+		{"aluBEXT", aluBEXT, 0x00000001_00000000, 32, 1},
+	}
+
+	for _, test := range tests64 {
+		got := rv32.alu64(test.aluOp, test.X, test.Y)
+		if got != test.expected {
+			t.Errorf("failed 64 bit %s for X: %016x and Y: %016x: expected %016x got %016x", test.name, test.X, test.Y, test.expected, got)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package core
+
+import "context"
+
+// RV32A funct5 values (funct7[6:2])
+const (
+	amoADD  = 0b00000
+	amoSWAP = 0b00001
+	amoLR   = 0b00010
+	amoSC   = 0b00011
+	amoXOR  = 0b00100
+	amoOR   = 0b01000
+	amoAND  = 0b01100
+	amoMIN  = 0b10000
+	amoMAX  = 0b10100
+	amoMINU = 0b11000
+	amoMAXU = 0b11100
+)
+
+// runAtomic runs the RV32A instructions (and the RV64A doubleword variants)
+// aq and rl bits are ignored since the core executes every memory access in order
+func (rv32 *RISCV) runAtomic(ctx context.Context, ins, rd, funct3, funct7 uint32, rs1Val, rs2Val uint64) error {
+	// 00010 aq rl 00000 rs1 010 rd 0101111 R lr.w
+	// 00011 aq rl rs2   rs1 010 rd 0101111 R sc.w
+	// 00001 aq rl rs2   rs1 010 rd 0101111 R amoswap.w
+	// 00000 aq rl rs2   rs1 010 rd 0101111 R amoadd.w
+	// 00100 aq rl rs2   rs1 010 rd 0101111 R amoxor.w
+	// 01100 aq rl rs2   rs1 010 rd 0101111 R amoand.w
+	// 01000 aq rl rs2   rs1 010 rd 0101111 R amoor.w
+	// 10000 aq rl rs2   rs1 010 rd 0101111 R amomin.w
+	// 10100 aq rl rs2   rs1 010 rd 0101111 R amomax.w
+	// 11000 aq rl rs2   rs1 010 rd 0101111 R amominu.w
+	// 11100 aq rl rs2   rs1 010 rd 0101111 R amomaxu.w
+	// The .d variants (RV64 only) uses funct3 = 011
+	size := uint64(4)
+	switch {
+	case funct3 == 0b010:
+		rs2Val = signExtend64(rs2Val, 32) // Word operations works over the sign extended lower 32 bits
+	case funct3 == 0b011 && rv32.isa.XLEN == 64:
+		size = 8
+	default:
+		return rv32.illegalInstruction(ins)
+	}
+
+	funct5 := funct7 >> 2
+	addr := rs1Val
+
+	switch funct5 {
+	case amoLR:
+		if ins&insRs2Mask != 0 {
+			return rv32.illegalInstruction(ins)
+		}
+		if addr&(size-1) != 0 {
+			return rv32.exception(ExceptionLoadAddressMisaligned, addr, "misaligned lr at %08x: %08x", rv32.insPC, addr)
+		}
+		paddr, err := rv32.translate(ctx, addr, size, AccessLoad)
+		if err != nil {
+			return err
+		}
+		data, err := rv32.atomicRead(ctx, paddr, size)
+		if err != nil {
+			return rv32.busError(ExceptionLoadAccessFault, addr, err)
+		}
+		rv32.Bus.Reserve(rv32, paddr)
+		rv32.setInteger(rd, data)
+		rv32.countEvent(HPMEventLoad)
+		return nil
+	case amoSC:
+		if addr&(size-1) != 0 {
+			return rv32.exception(ExceptionStoreAddressMisaligned, addr, "misaligned sc at %08x: %08x", rv32.insPC, addr)
+		}
+		paddr, err := rv32.translate(ctx, addr, size, AccessStore)
+		if err != nil {
+			return err
+		}
+		if !rv32.Bus.ClaimReservation(rv32, paddr) {
+			rv32.setInteger(rd, 1)
+			return nil
+		}
+		err = rv32.atomicWrite(ctx, paddr, size, rs2Val)
+		if err != nil {
+			return rv32.busError(ExceptionStoreAccessFault, addr, err)
+		}
+		rv32.setInteger(rd, 0)
+		rv32.countEvent(HPMEventStore)
+		return nil
+	case amoADD, amoSWAP, amoXOR, amoOR, amoAND, amoMIN, amoMAX, amoMINU, amoMAXU:
+	default:
+		return rv32.illegalInstruction(ins)
+	}
+
+	if addr&(size-1) != 0 {
+		return rv32.exception(ExceptionStoreAddressMisaligned, addr, "misaligned amo at %08x: %08x", rv32.insPC, addr)
+	}
+
+	// AMOs report store faults even on the read
+	paddr, err := rv32.translate(ctx, addr, size, AccessStore)
+	if err != nil {
+		return err
+	}
+	data, err := rv32.atomicRead(ctx, paddr, size)
+	if err != nil {
+		return rv32.busError(ExceptionStoreAccessFault, addr, err)
+	}
+
+	// Both operands are sign extended to 64 bits, so the comparisons also work for words
+	result := rs2Val
+	switch funct5 {
+	case amoADD:
+		result = rv32.alu64(aluADD, data, rs2Val)
+	case amoXOR:
+		result = rv32.alu64(aluXOR, data, rs2Val)
+	case amoOR:
+		result = rv32.alu64(aluOR, data, rs2Val)
+	case amoAND:
+		result = rv32.alu64(aluAND, data, rs2Val)
+	case amoMIN:
+		if rv32.alu64(aluLesserThanSigned, data, rs2Val) == 1 {
+			result = data
+		}
+	case amoMAX:
+		if rv32.alu64(aluGreaterThanOrEqualSigned, data, rs2Val) == 1 {
+			result = data
+		}
+	case amoMINU:
+		if rv32.alu64(aluLesserThanUnsigned, data, rs2Val) == 1 {
+			result = data
+		}
+	case amoMAXU:
+		if rv32.alu64(aluGreaterThanOrEqualUnsigned, data, rs2Val) == 1 {
+			result = data
+		}
+	}
+
+	err = rv32.atomicWrite(ctx, paddr, size, result)
+	if err != nil {
+		return rv32.busError(ExceptionStoreAccessFault, addr, err)
+	}
+
+	rv32.setInteger(rd, data)
+	rv32.countEvent(HPMEventLoad)
+	rv32.countEvent(HPMEventStore)
+	return nil
+}
+
+// atomicRead reads a word (sign extended) or a doubleword from the bus
+func (rv32 *RISCV) atomicRead(ctx context.Context, addr, size uint64) (uint64, error) {
+	if size == 8 {
+		return rv32.Bus.ReadDoubleWord(ctx, addr)
+	}
+	data, err := rv32.Bus.ReadWord(ctx, addr)
+	return signExtend64(uint64(data), 32), err
+}
+
+// atomicWrite writes a word or a doubleword to the bus
+func (rv32 *RISCV) atomicWrite(ctx context.Context, addr, size, value uint64) error {
+	rv32.invalidateCode(addr, size)
+	if size == 8 {
+		return rv32.Bus.WriteDoubleWord(ctx, addr, value)
+	}
+	return rv32.Bus.WriteWord(ctx, addr, uint32(value))
+}
@@ -0,0 +1,180 @@
+package core
+
+// shiftImmediateOp decodes the OP-IMM instructions with funct3 001 / 101
+// Besides the base shifts, those are the Zbb and Zbs operations with an immediate or a single operand
+// Returns aluINVALID if the instruction is not implemented
+func (rv32 *RISCV) shiftImmediateOp(funct3, immTypeI uint32) int {
+	// 000000 shamt         rs1 001 rd 0010011 I slli
+	// 000000 shamt         rs1 101 rd 0010011 I srli
+	// 010000 shamt         rs1 101 rd 0010011 I srai
+	// 011000 shamt         rs1 101 rd 0010011 I rori   (Zbb)
+	// 0110000 00000        rs1 001 rd 0010011 I clz    (Zbb)
+	// 0110000 00001        rs1 001 rd 0010011 I ctz    (Zbb)
+	// 0110000 00010        rs1 001 rd 0010011 I cpop   (Zbb)
+	// 0110000 00100        rs1 001 rd 0010011 I sext.b (Zbb)
+	// 0110000 00101        rs1 001 rd 0010011 I sext.h (Zbb)
+	// 001010000111         rs1 101 rd 0010011 I orc.b  (Zbb)
+	// 011010011000         rs1 101 rd 0010011 I rev8   (Zbb, RV32)
+	// 011010111000         rs1 101 rd 0010011 I rev8   (Zbb, RV64)
+	// 001010 shamt         rs1 001 rd 0010011 I bseti  (Zbs)
+	// 010010 shamt         rs1 001 rd 0010011 I bclri  (Zbs)
+	// 011010 shamt         rs1 001 rd 0010011 I binvi  (Zbs)
+	// 010010 shamt         rs1 101 rd 0010011 I bexti  (Zbs)
+	// In RV32 shamt[5] must be zero, which is checked by the caller
+	funct6 := immTypeI >> 6
+	zbb := rv32.isa.HasZ(ExtZbb)
+	zbs := rv32.isa.HasZ(ExtZbs)
+
+	rev8 := uint32(0b011010011000)
+	if rv32.isa.XLEN == 64 {
+		rev8 = 0b011010111000
+	}
+
+	if funct3 == 1 {
+		switch {
+		case funct6 == 0b000000:
+			return aluShiftLeftUnsigned
+		case funct6 == 0b001010 && zbs:
+			return aluBSET
+		case funct6 == 0b010010 && zbs:
+			return aluBCLR
+		case funct6 == 0b011010 && zbs:
+			return aluBINV
+		case immTypeI == 0b011000000000 && zbb:
+			return aluCLZ
+		case immTypeI == 0b011000000001 && zbb:
+			return aluCTZ
+		case immTypeI == 0b011000000010 && zbb:
+			return aluCPOP
+		case immTypeI == 0b011000000100 && zbb:
+			return aluSEXTB
+		case immTypeI == 0b011000000101 && zbb:
+			return aluSEXTH
+		}
+		return aluINVALID
+	}
+
+	switch {
+	case funct6 == 0b000000:
+		return aluShiftRightUnsigned
+	case funct6 == 0b010000:
+		return aluShiftRightSigned
+	case funct6 == 0b011000 && zbb:
+		return aluROR
+	case funct6 == 0b010010 && zbs:
+		return aluBEXT
+	case immTypeI == 0b001010000111 && zbb:
+		return aluORCB
+	case immTypeI == rev8 && zbb:
+		return aluREV8
+	}
+	return aluINVALID
+}
+
+// bitManipOp decodes the Zba, Zbb and Zbs register-register operations (OP opcode)
+// Returns aluINVALID if the instruction is not implemented
+func (rv32 *RISCV) bitManipOp(funct7, funct3, rs2 uint32) int {
+	// 0010000 rs2   rs1 010 rd 0110011 R sh1add (Zba)
+	// 0010000 rs2   rs1 100 rd 0110011 R sh2add (Zba)
+	// 0010000 rs2   rs1 110 rd 0110011 R sh3add (Zba)
+	// 0100000 rs2   rs1 111 rd 0110011 R andn   (Zbb)
+	// 0100000 rs2   rs1 110 rd 0110011 R orn    (Zbb)
+	// 0100000 rs2   rs1 100 rd 0110011 R xnor   (Zbb)
+	// 0000101 rs2   rs1 110 rd 0110011 R max    (Zbb)
+	// 0000101 rs2   rs1 111 rd 0110011 R maxu   (Zbb)
+	// 0000101 rs2   rs1 100 rd 0110011 R min    (Zbb)
+	// 0000101 rs2   rs1 101 rd 0110011 R minu   (Zbb)
+	// 0110000 rs2   rs1 001 rd 0110011 R rol    (Zbb)
+	// 0110000 rs2   rs1 101 rd 0110011 R ror    (Zbb)
+	// 0000100 00000 rs1 100 rd 0110011 R zext.h (Zbb, RV32)
+	// 0100100 rs2   rs1 001 rd 0110011 R bclr   (Zbs)
+	// 0100100 rs2   rs1 101 rd 0110011 R bext   (Zbs)
+	// 0110100 rs2   rs1 001 rd 0110011 R binv   (Zbs)
+	// 0010100 rs2   rs1 001 rd 0110011 R bset   (Zbs)
+	if rv32.isa.HasZ(ExtZba) && funct7 == 0b0010000 {
+		switch funct3 {
+		case 0b010:
+			return aluSH1ADD
+		case 0b100:
+			return aluSH2ADD
+		case 0b110:
+			return aluSH3ADD
+		}
+	}
+
+	if rv32.isa.HasZ(ExtZbb) {
+		switch {
+		case funct7 == 0b0100000 && funct3 == 0b111:
+			return aluANDN
+		case funct7 == 0b0100000 && funct3 == 0b110:
+			return aluORN
+		case funct7 == 0b0100000 && funct3 == 0b100:
+			return aluXNOR
+		case funct7 == 0b0000101 && funct3 == 0b110:
+			return aluMAX
+		case funct7 == 0b0000101 && funct3 == 0b111:
+			return aluMAXU
+		case funct7 == 0b0000101 && funct3 == 0b100:
+			return aluMIN
+		case funct7 == 0b0000101 && funct3 == 0b101:
+			return aluMINU
+		case funct7 == 0b0110000 && funct3 == 0b001:
+			return aluROL
+		case funct7 == 0b0110000 && funct3 == 0b101:
+			return aluROR
+		case funct7 == 0b0000100 && funct3 == 0b100 && rs2 == 0 && rv32.isa.XLEN == 32: // In RV64 zext.h is in OP-32
+			return aluZEXTH
+		}
+	}
+
+	if rv32.isa.HasZ(ExtZbs) {
+		switch {
+		case funct7 == 0b0100100 && funct3 == 0b001:
+			return aluBCLR
+		case funct7 == 0b0100100 && funct3 == 0b101:
+			return aluBEXT
+		case funct7 == 0b0110100 && funct3 == 0b001:
+			return aluBINV
+		case funct7 == 0b0010100 && funct3 == 0b001:
+			return aluBSET
+		}
+	}
+
+	return aluINVALID
+}
+
+// runUnsignedWord runs the RV64 Zba / Zbb instructions that works over the zero extended lower word of rs1
+// Returns false if the instruction is not one of them
+func (rv32 *RISCV) runUnsignedWord(opcode, rd, funct3, funct7, rs2 uint32, rs1Val, rs2Val, imm uint64) bool {
+	// 0000100 rs2   rs1 000 rd 0111011 R add.uw    (Zba)
+	// 0010000 rs2   rs1 010 rd 0111011 R sh1add.uw (Zba)
+	// 0010000 rs2   rs1 100 rd 0111011 R sh2add.uw (Zba)
+	// 0010000 rs2   rs1 110 rd 0111011 R sh3add.uw (Zba)
+	// 000010 shamt  rs1 001 rd 0011011 I slli.uw   (Zba)
+	// 0000100 00000 rs1 100 rd 0111011 R zext.h    (Zbb, RV64)
+	rs1Word := rs1Val & 0xFFFFFFFF
+	zba := rv32.isa.HasZ(ExtZba)
+
+	aluOp := aluINVALID
+	y := rs2Val
+	switch {
+	case opcode == 0b0111011 && funct7 == 0b0000100 && funct3 == 0b000 && zba:
+		aluOp = aluADD
+	case opcode == 0b0111011 && funct7 == 0b0010000 && funct3 == 0b010 && zba:
+		aluOp = aluSH1ADD
+	case opcode == 0b0111011 && funct7 == 0b0010000 && funct3 == 0b100 && zba:
+		aluOp = aluSH2ADD
+	case opcode == 0b0111011 && funct7 == 0b0010000 && funct3 == 0b110 && zba:
+		aluOp = aluSH3ADD
+	case opcode == 0b0011011 && funct7>>1 == 0b000010 && funct3 == 0b001 && zba:
+		aluOp = aluShiftLeftUnsigned
+		y = imm & 0x3F
+	case opcode == 0b0111011 && funct7 == 0b0000100 && funct3 == 0b100 && rs2 == 0 && rv32.isa.HasZ(ExtZbb):
+		aluOp = aluZEXTH
+	default:
+		return false
+	}
+
+	rv32.setInteger(rd, rv32.alu64(aluOp, rs1Word, y))
+	return true
+}
@@ -0,0 +1,368 @@
+package core
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// Engine is the execution engine used to run the emulation (by RunUntil and Start)
+type Engine int
+
+const (
+	// EngineInterpreter runs one instruction at a time, using the decode cache when it is enabled
+	EngineInterpreter Engine = iota
+	// EngineBlocks compiles the basic blocks of the program into chains of closures, with the registers and
+	// immediates already resolved. Single steps and runs with breakpoints use the interpreter
+	EngineBlocks
+)
+
+// String returns the name of the engine
+func (e Engine) String() string {
+	switch e {
+	case EngineInterpreter:
+		return "interpreter"
+	case EngineBlocks:
+		return "blocks"
+	}
+	return "unknown"
+}
+
+const (
+	blockMaxInstructions = 64
+	blockCacheMaxBlocks  = 1 << 16    // The cache is flushed when more blocks are needed
+	noStop               = ^uint64(0) // Stop address that is never reached
+)
+
+// blockOp is a compiled instruction, pc already points to the next instruction when it runs
+type blockOp func(rv32 *RISCV, ctx context.Context) error
+
+// block is a straight-line sequence of instructions in a physical page, ending at a jump, a branch or a system
+// instruction
+type block struct {
+	start, end uint64 // Physical addresses of the block [start, end)
+	ops        []blockOp
+	sizes      []uint8 // Size of each instruction
+}
+
+// blockCache holds the compiled blocks, indexed by their physical start address
+type blockCache struct {
+	blocks      map[uint64]*block
+	pages       map[uint64][]*block // Blocks in each physical page, used by invalidate
+	filter      [64]uint64          // Bit set of the pages with blocks (modulo 4096), so stores to other pages skip the map
+	generation  uint64              // Bus generation of the compiled blocks
+	invalidated bool                // Set when a block is dropped, so the running block stops
+}
+
+func newBlockCache() *blockCache {
+	return &blockCache{
+		blocks: make(map[uint64]*block),
+		pages:  make(map[uint64][]*block),
+	}
+}
+
+// insert adds a compiled block to the cache
+func (c *blockCache) insert(b *block) {
+	if len(c.blocks) >= blockCacheMaxBlocks {
+		c.flush()
+	}
+	page := b.start >> pageShift
+	c.blocks[b.start] = b
+	c.pages[page] = append(c.pages[page], b)
+	c.filter[page>>6&63] |= 1 << (page & 63)
+}
+
+// invalidate drops the blocks that overlap the size bytes written at the physical address
+func (c *blockCache) invalidate(paddr, size uint64) {
+	end := paddr + size
+	for page := paddr >> pageShift; page <= (end-1)>>pageShift; page++ {
+		if c.filter[page>>6&63]&(1<<(page&63)) == 0 {
+			continue
+		}
+		blocks := c.pages[page]
+		kept := blocks[:0]
+		for _, b := range blocks {
+			if paddr < b.end && end > b.start {
+				delete(c.blocks, b.start)
+				c.invalidated = true
+				continue
+			}
+			kept = append(kept, b)
+		}
+		c.pages[page] = kept
+	}
+}
+
+// flush drops all blocks
+func (c *blockCache) flush() {
+	c.blocks = make(map[uint64]*block)
+	c.pages = make(map[uint64][]*block)
+	c.filter = [64]uint64{}
+	c.invalidated = true
+}
+
+// SetEngine selects the execution engine
+func (rv32 *RISCV) SetEngine(engine Engine) {
+	switch engine {
+	case EngineInterpreter:
+		rv32.blocks = nil
+	case EngineBlocks:
+		if rv32.blocks == nil {
+			rv32.blocks = newBlockCache()
+		}
+	default:
+		rv32.log.Errorf("unsupported engine %s, using %s", engine, rv32.Engine())
+	}
+}
+
+// Engine returns the selected execution engine
+func (rv32 *RISCV) Engine() Engine {
+	if rv32.blocks != nil {
+		return EngineBlocks
+	}
+	return EngineInterpreter
+}
+
+// run runs the next instructions with the selected engine, stopping before the instruction at the address stop
+// The block engine falls back to a single step while there are breakpoints or watchpoints
+func (rv32 *RISCV) run(ctx context.Context, stop uint64) error {
+	if rv32.blocks == nil || len(rv32.breakpoints) > 0 || len(rv32.watchpoints) > 0 {
+		return rv32.RunStep(ctx)
+	}
+	return rv32.runBlock(ctx, stop)
+}
+
+// runBlock runs the block at pc, each instruction takes a cycle as in RunStep
+// The block stops early on exceptions, interrupts, at the address stop and when a store changes the compiled code
+// Instructions that cannot be compiled are run by the interpreter
+func (rv32 *RISCV) runBlock(ctx context.Context, stop uint64) error {
+	if !rv32.beginCycle() {
+		return nil
+	}
+
+	pc := rv32.pc
+	rv32.insPC = pc
+	b := rv32.lookupBlock(ctx, pc)
+	if b == nil {
+		if err := rv32.execute(ctx, pc); err != nil {
+			return rv32.handleException(pc, err)
+		}
+		rv32.countInstret()
+		return nil
+	}
+
+	rv32.blocks.invalidated = false
+	ticks := len(rv32.tickHandlers) > 0
+	for i, op := range b.ops {
+		if i > 0 {
+			if ticks {
+				rv32.beginCycle() // A block never waits, as wfi ends the block
+			} else {
+				// Without devices clocked by the core a cycle only counts, and interrupts are raised by other goroutines
+				rv32.cycleNum++
+				rv32.countCycle()
+				if atomic.LoadUint32(&rv32.mip)&rv32.mie != 0 {
+					rv32.checkInterrupts()
+				}
+			}
+			if rv32.pc != pc { // Interrupt taken
+				return nil
+			}
+			rv32.insPC = pc
+		}
+		next := (pc + uint64(b.sizes[i])) & rv32.xlenMask
+		rv32.pc = next
+		if err := op(rv32, ctx); err != nil {
+			return rv32.handleException(pc, err)
+		}
+		rv32.countInstret()
+		if rv32.pc != next || next == stop || rv32.blocks.invalidated {
+			return nil
+		}
+		pc = next
+	}
+	return nil
+}
+
+// lookupBlock returns the block at the virtual address pc, compiling it on misses
+// Returns nil if the instruction at pc cannot be run from a block, like when it cannot be fetched
+func (rv32 *RISCV) lookupBlock(ctx context.Context, pc uint64) *block {
+	paddr, err := rv32.translate(ctx, pc, 2, AccessFetch)
+	if err != nil {
+		return nil
+	}
+
+	c := rv32.blocks
+	if generation := atomic.LoadUint64(&rv32.Bus.generation); c.generation != generation { // Maps or permissions changed
+		c.flush()
+		c.generation = generation
+	}
+
+	b, ok := c.blocks[paddr]
+	if !ok {
+		b = rv32.compileBlock(ctx, paddr)
+		if b == nil {
+			return nil
+		}
+		c.insert(b)
+	}
+
+	// The fetch checks of the first instruction were done by translate, the others must be allowed by PMP as well
+	if b.end-paddr > 2 && !rv32.pmpPermitted(paddr, b.end-paddr, rv32.priv, AccessFetch) {
+		return nil
+	}
+	return b
+}
+
+// compileBlock compiles the instructions at the physical address until the end of the block
+// Returns nil if the first instruction cannot be compiled
+func (rv32 *RISCV) compileBlock(ctx context.Context, paddr uint64) *block {
+	b := &block{start: paddr, end: paddr}
+	for len(b.ops) < blockMaxInstructions {
+		word, err := rv32.Bus.Fetch(ctx, b.end, 0xF)
+		if err != nil {
+			break
+		}
+		if b.end&3 != 0 {
+			word >>= 16
+			if !isCompressed(word) {
+				if (b.end+2)&pageOffsetMask == 0 { // Crosses the page boundary
+					break
+				}
+				next, err := rv32.Bus.Fetch(ctx, b.end+2, 0xF)
+				if err != nil {
+					break
+				}
+				word |= next << 16
+			}
+		}
+
+		d := rv32.decode(word)
+		if b.end&pageOffsetMask+uint64(d.size) > 1<<pageShift {
+			break
+		}
+		b.ops = append(b.ops, rv32.compile(d))
+		b.sizes = append(b.sizes, d.size)
+		b.end += uint64(d.size)
+
+		if endsBlock(d) || b.end&pageOffsetMask == 0 {
+			break
+		}
+	}
+	if len(b.ops) == 0 {
+		return nil
+	}
+	return b
+}
+
+// endsBlock returns true if the instruction can change the pc, the privilege level or the address translation
+func endsBlock(d decodedInstruction) bool {
+	switch d.opcode {
+	case 0b1100011, 0b1101111, 0b1100111: // Branches, jal, jalr
+		return true
+	case 0b1110011, 0b0001111: // System, CSR and fence instructions
+		return true
+	}
+	return false
+}
+
+// compile creates the closure that runs a decoded instruction, with its operands already bound
+func (rv32 *RISCV) compile(d decodedInstruction) blockOp {
+	rd, rs1, rs2, imm := d.rd, d.rs1, d.rs2, d.imm
+	mask := rv32.xlenMask
+	regs := &rv32.Registers.integers
+
+	switch d.kind {
+	case kindADDI:
+		if rd == 0 {
+			return nop
+		}
+		return func(rv32 *RISCV, ctx context.Context) error {
+			regs[rd] = (regs[rs1] + imm) & mask
+			return nil
+		}
+	case kindADD:
+		if rd == 0 {
+			return nop
+		}
+		return func(rv32 *RISCV, ctx context.Context) error {
+			regs[rd] = (regs[rs1] + regs[rs2]) & mask
+			return nil
+		}
+	case kindALUImmediate:
+		if rd == 0 {
+			return nop
+		}
+		aluOp := int(d.aluOp)
+		return func(rv32 *RISCV, ctx context.Context) error {
+			regs[rd] = rv32.aluX(aluOp, regs[rs1], imm) & mask
+			return nil
+		}
+	case kindALU:
+		if rd == 0 {
+			return nop
+		}
+		aluOp, shiftMask := int(d.aluOp), d.mask
+		return func(rv32 *RISCV, ctx context.Context) error {
+			regs[rd] = rv32.aluX(aluOp, regs[rs1], regs[rs2]&shiftMask) & mask
+			return nil
+		}
+	case kindLUI:
+		if rd == 0 {
+			return nop
+		}
+		value := imm & mask
+		return func(rv32 *RISCV, ctx context.Context) error {
+			regs[rd] = value
+			return nil
+		}
+	case kindLoad:
+		size := uint64(1) << (d.funct3 & 3)
+		signed := d.funct3&4 == 0 && size < 8
+		return func(rv32 *RISCV, ctx context.Context) error {
+			data, err := rv32.load(ctx, (regs[rs1]+imm)&mask, size)
+			if err != nil {
+				return err
+			}
+			if signed {
+				data = signExtend64(data, uint(8*size))
+			}
+			if rd != 0 {
+				regs[rd] = data & mask
+			}
+			rv32.countEvent(HPMEventLoad)
+			return nil
+		}
+	case kindStore:
+		size := uint64(1) << (d.funct3 & 3)
+		return func(rv32 *RISCV, ctx context.Context) error {
+			if err := rv32.store(ctx, (regs[rs1]+imm)&mask, size, regs[rs2]); err != nil {
+				return err
+			}
+			rv32.countEvent(HPMEventStore)
+			return nil
+		}
+	case kindBranch:
+		aluOp := int(d.aluOp)
+		return func(rv32 *RISCV, ctx context.Context) error {
+			if rv32.aluX(aluOp, regs[rs1], regs[rs2]) != 1 {
+				return nil
+			}
+			target := (rv32.insPC + imm) & mask
+			if target&rv32.ialignMask != 0 {
+				return rv32.misalignedJump(target)
+			}
+			rv32.pc = target
+			rv32.countEvent(HPMEventTakenBranch)
+			return nil
+		}
+	}
+
+	return func(rv32 *RISCV, ctx context.Context) error {
+		return d.exec(rv32, ctx, &d)
+	}
+}
+
+// nop is the compiled form of the instructions that only write x0
+func nop(rv32 *RISCV, ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,133 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestBlocks_Programs runs the programs of the core tests with the block engine
+func TestBlocks_Programs(t *testing.T) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, opts ...Option)
+	}{
+		{"LoadStore", testCPULoadStore},
+		{"LoadNegativeOffset", testCPULoadNegativeOffset},
+		{"JALJALR", testCPUJALJALR},
+		{"LUIAUIPC", testCPULUIAUIPC},
+		{"JMPS", testCPUJMPS},
+		{"ALU", testCPUALU},
+		{"MulDiv", testCPUMulDiv},
+		{"CSR", testCPUCSR},
+		{"Trap", testCPUTrap},
+		{"TrapPolicyStop", testCPUTrapPolicyStop},
+		{"Interrupt", testCPUInterrupt},
+		{"WFI", testCPUWFI},
+		{"Atomic", testCPUAtomic},
+		{"Float", testCPUFloat},
+		{"Compressed", testCPUCompressed},
+		{"RV64", testCPURV64},
+		{"RV32E", testCPURV32E},
+		{"Privilege", testCPUPrivilege},
+		{"BitManip", testCPUBitManip},
+		{"BitManipRV64", testCPUBitManipRV64},
+		{"SelfModifying", testDecodeCacheSelfModifying},
+		{"BusChanges", testDecodeCacheBusChanges},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			test.test(t, WithEngine(EngineBlocks))
+		})
+	}
+}
+
+func TestBlocks_SelfModifyingBlock(t *testing.T) {
+	ctx := context.Background()
+	cpu, _ := createTestCPU(t, []Option{WithEngine(EngineBlocks)},
+		0x00502623, // sw x5, 12(x0)
+		0x00108093, // addi x1, x1, 1
+		0x00108093, // addi x1, x1, 1
+		0x00108093, // addi x1, x1, 1 (replaced by addi x1, x1, 16)
+		0x00000063, // beqz x0, 0
+	)
+	cpu.Registers.SetInteger(5, 0x01008093) // addi x1, x1, 16
+
+	if err := cpu.RunUntilWithTimeout(ctx, 0x10, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if v := cpu.Registers.GetInteger(1); v != 18 {
+		t.Errorf("Expected X01 to be 18 but got %d", v)
+	}
+}
+
+func TestBlocks_Stop(t *testing.T) {
+	ctx := context.Background()
+	cpu, _ := createTestCPU(t, []Option{WithEngine(EngineBlocks)},
+		0x00108093, // addi x1, x1, 1
+		0x00108093, // addi x1, x1, 1
+		0x00108093, // addi x1, x1, 1
+		0x00108093, // addi x1, x1, 1
+		0x00000063, // beqz x0, 0
+	)
+
+	// The run stops in the middle of the block
+	if err := cpu.RunUntilWithTimeout(ctx, 0x08, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if v := cpu.Registers.GetInteger(1); v != 2 {
+		t.Errorf("Expected X01 to be 2 but got %d", v)
+	}
+	if cpu.Cycles() != 2 {
+		t.Errorf("Expected 2 cycles but got %d", cpu.Cycles())
+	}
+
+	// Single steps and breakpoints use the interpreter
+	if err := cpu.RunStep(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if v := cpu.Registers.GetInteger(1); v != 3 {
+		t.Errorf("Expected X01 to be 3 but got %d", v)
+	}
+	cpu.AddBreak(0x0C)
+	if err := cpu.run(ctx, noStop); err != nil {
+		t.Fatal(err)
+	}
+	if v := cpu.Registers.GetInteger(1); v != 4 {
+		t.Errorf("Expected X01 to be 4 but got %d", v)
+	}
+}
+
+func TestBlocks_Engine(t *testing.T) {
+	cpu := CreateEmulator(nil)
+	if cpu.Engine() != EngineInterpreter {
+		t.Errorf("Expected the default engine to be %s but got %s", EngineInterpreter, cpu.Engine())
+	}
+	cpu = CreateEmulator(nil, WithEngine(EngineBlocks))
+	if cpu.Engine() != EngineBlocks {
+		t.Errorf("Expected the engine to be %s but got %s", EngineBlocks, cpu.Engine())
+	}
+	cpu.SetEngine(Engine(-1))
+	if cpu.Engine() != EngineBlocks {
+		t.Errorf("Expected an unsupported engine to be ignored but got %s", cpu.Engine())
+	}
+}
+
+func BenchmarkBlocks(b *testing.B) {
+	for _, engine := range []Engine{EngineInterpreter, EngineBlocks} {
+		b.Run(engine.String(), func(b *testing.B) {
+			cpu, _ := createTestCPU(b, []Option{WithEngine(engine)}, columnProgram...)
+
+			// Runs blocks until b.N instructions have run
+			ctx := context.Background()
+			b.ResetTimer()
+			for cpu.Cycles() < uint64(b.N) {
+				if err := cpu.run(ctx, noStop); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -2,69 +2,200 @@ package core
 
 import (
 	"context"
+	"encoding/binary"
 	"fmt"
 	"github.com/sirupsen/logrus"
+	"sort"
+	"sync"
+	"sync/atomic"
 )
 
-// Bus represents a Read/Write 32 bit address bus
+// reservationGranuleMask is the mask of a reservation set address (a double word)
+const reservationGranuleMask = 0xFFFFFFFF_FFFFFFF8
+
+// Bus represents a Read/Write 64 bit address bus with 32 bit data transactions with byte lanes
 type Bus struct {
+	generation uint64 // Incremented when the maps change, accessed atomically (first field to keep it 64 bit aligned)
+
 	handlers map[string]BusMap
+	regions  []*BusMap // The maps sorted by address, rebuilt when the maps change
+	lastHit  [3]int32  // Index in regions of the last map found for each access type, accessed atomically
 	log      *logrus.Logger
+
+	reservationLock sync.Mutex
+	reservations    map[interface{}]uint64
+	numReservations int32 // Accessed atomically
 }
 
 func CreateBus(log *logrus.Logger) *Bus {
 	return &Bus{
-		log:      log,
-		handlers: make(map[string]BusMap),
+		log:          log,
+		handlers:     make(map[string]BusMap),
+		reservations: make(map[interface{}]uint64),
 	}
 }
 
-// Read performs a read in the bus
-func (b *Bus) Read(ctx context.Context, address uint32) (uint32, error) {
-	handler, err := b.getReadHandler(address)
+// Read performs a load transaction of the byte lanes in readMask of the word at address
+// The address is aligned down to a word
+func (b *Bus) Read(ctx context.Context, address uint64, readMask byte) (uint32, error) {
+	return b.read(ctx, address, readMask, AccessLoad)
+}
+
+// Fetch performs an instruction fetch transaction of the byte lanes in readMask of the word at address
+// It is the same as Read, but the map needs execute permission
+func (b *Bus) Fetch(ctx context.Context, address uint64, readMask byte) (uint32, error) {
+	return b.read(ctx, address, readMask, AccessFetch)
+}
+
+// read performs a read transaction with the access type (AccessFetch or AccessLoad)
+func (b *Bus) read(ctx context.Context, address uint64, readMask byte, access AccessType) (uint32, error) {
+	address &^= 3
+	m, err := b.getReadMap(address, access)
 	if err != nil {
 		return 0, err
 	}
 
-	return handler(ctx, address)
+	if m.Memory != nil {
+		return binary.LittleEndian.Uint32(m.Memory[address-m.Start:]), nil
+	}
+	return m.RHandler(ctx, address, readMask)
 }
 
-// Write performs a write in the bus
-func (b *Bus) Write(ctx context.Context, address, value uint32, writeMask byte) error {
-	handler, err := b.getWriteHandler(address)
+// Write performs a write transaction of the byte lanes in writeMask of the word at address
+// The address is aligned down to a word
+// Any write invalidates the load reservations on the written address
+func (b *Bus) Write(ctx context.Context, address uint64, value uint32, writeMask byte) error {
+	address &^= 3
+	m, err := b.getWriteMap(address)
 	if err != nil {
 		return err
 	}
 
-	return handler(ctx, address, value, writeMask)
+	if atomic.LoadInt32(&b.numReservations) > 0 {
+		b.invalidateReservations(address)
+	}
+
+	if m.Memory != nil {
+		data := m.Memory[address-m.Start:]
+		binary.LittleEndian.PutUint32(data, MergeLanes(binary.LittleEndian.Uint32(data), value, writeMask))
+		return nil
+	}
+	return m.WHandler(ctx, address, value, writeMask)
+}
+
+// Reserve registers a load reservation (LR) for the owner in the specified address
+// Each owner (usually a hart) can hold a single reservation, so this replaces any previous one
+func (b *Bus) Reserve(owner interface{}, address uint64) {
+	b.reservationLock.Lock()
+	defer b.reservationLock.Unlock()
+
+	b.reservations[owner] = address & reservationGranuleMask
+	atomic.StoreInt32(&b.numReservations, int32(len(b.reservations)))
+}
+
+// ClaimReservation returns true if the owner still holds a reservation for the specified address
+// The reservation of the owner is always released, so it should be called only by store conditional (SC)
+func (b *Bus) ClaimReservation(owner interface{}, address uint64) bool {
+	b.reservationLock.Lock()
+	defer b.reservationLock.Unlock()
+
+	reserved, ok := b.reservations[owner]
+	delete(b.reservations, owner)
+	atomic.StoreInt32(&b.numReservations, int32(len(b.reservations)))
+
+	return ok && reserved == address&reservationGranuleMask
 }
 
-// getReadHandler finds a bus read handler for the specified address and returns it
-func (b *Bus) getReadHandler(address uint32) (handle BusReadHandle, err error) {
-	for _, v := range b.handlers {
-		if v.In(address) {
-			handle = v.RHandler
-			if handle == nil {
-				err = fmt.Errorf("no read handler for 0x%08x", address)
-			}
-			return
+// invalidateReservations invalidates all reservations that contains the address
+func (b *Bus) invalidateReservations(address uint64) {
+	b.reservationLock.Lock()
+	defer b.reservationLock.Unlock()
+
+	for owner, reserved := range b.reservations {
+		if reserved == address&reservationGranuleMask {
+			delete(b.reservations, owner)
 		}
 	}
+	atomic.StoreInt32(&b.numReservations, int32(len(b.reservations)))
+}
 
-	return handle, fmt.Errorf("unmmaped space at 0x%08x", address)
+// lookup returns the map that contains the address, or nil if the address is not mapped
+// The last map found for the access type is checked first, as most accesses hit the same map as the previous one
+func (b *Bus) lookup(address uint64, access AccessType) *BusMap {
+	regions := b.regions
+	last := int(atomic.LoadInt32(&b.lastHit[access]))
+	if last < len(regions) && regions[last].In(address) {
+		return regions[last]
+	}
+
+	i := sort.Search(len(regions), func(i int) bool {
+		return regions[i].End > address
+	})
+	if i == len(regions) || !regions[i].In(address) {
+		return nil
+	}
+	atomic.StoreInt32(&b.lastHit[access], int32(i))
+	return regions[i]
 }
 
-// getWriteHandler finds a bus read handler for the specified address and returns it
-func (b *Bus) getWriteHandler(address uint32) (handle BusWriteHandle, err error) {
-	for _, v := range b.handlers {
-		if v.In(address) {
-			handle = v.WHandler
-			if handle == nil {
-				err = fmt.Errorf("no write handler for 0x%08x", address)
-			}
-			return
-		}
+// updateRegions rebuilds the sorted maps used by lookup, it must be called when the maps change
+func (b *Bus) updateRegions() {
+	regions := make([]*BusMap, 0, len(b.handlers))
+	for name := range b.handlers {
+		m := b.handlers[name]
+		regions = append(regions, &m)
+	}
+	sort.Slice(regions, func(i, j int) bool {
+		return regions[i].Start < regions[j].Start
+	})
+
+	b.regions = regions
+	for i := range b.lastHit {
+		atomic.StoreInt32(&b.lastHit[i], 0)
 	}
+	atomic.AddUint64(&b.generation, 1)
+}
 
-	return handle, fmt.Errorf("unmmaped space at 0x%08x", address)
+// getReadMap finds the bus map that can be read at the specified address and returns it
+// The map must allow the access type (AccessFetch or AccessLoad)
+func (b *Bus) getReadMap(address uint64, access AccessType) (*BusMap, error) {
+	m := b.lookup(address, access)
+	if m == nil {
+		return nil, fmt.Errorf("unmmaped space at 0x%08x", address)
+	}
+	if m.RHandler == nil {
+		return nil, fmt.Errorf("no read handler for 0x%08x", address)
+	}
+	if !m.Permissions.allows(access) {
+		return nil, fmt.Errorf("no %s permission for 0x%08x in %q", access, address, m.Name)
+	}
+	return m, nil
+}
+
+// getWriteMap finds the bus map that can be written at the specified address and returns it
+func (b *Bus) getWriteMap(address uint64) (*BusMap, error) {
+	m := b.lookup(address, AccessStore)
+	if m == nil {
+		return nil, fmt.Errorf("unmmaped space at 0x%08x", address)
+	}
+	if m.WHandler == nil {
+		return nil, fmt.Errorf("no write handler for 0x%08x", address)
+	}
+	if !m.Permissions.allows(AccessStore) {
+		return nil, fmt.Errorf("no %s permission for 0x%08x in %q", AccessStore, address, m.Name)
+	}
+	return m, nil
+}
+
+// getMemoryMap returns the memory map (see MapMemory) that holds the size bytes at address and allows the access
+// Returns nil if the access must go through the bus transactions
+func (b *Bus) getMemoryMap(address, size uint64, access AccessType) *BusMap {
+	m := b.lookup(address, access)
+	if m == nil || m.Memory == nil || address+size > m.End || !m.Permissions.allows(access) {
+		return nil
+	}
+	if access == AccessStore && m.WHandler == nil {
+		return nil
+	}
+	return m
 }
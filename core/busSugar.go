@@ -2,48 +2,138 @@ package core
 
 import (
 	"context"
+	"encoding/binary"
 	"fmt"
 	"sort"
+	"sync/atomic"
 )
 
 // ReadByte performs a byte read in the bus
-func (b *Bus) ReadByte(ctx context.Context, address uint32) (byte, error) {
-	v, err := b.Read(ctx, address)
-	return uint8(v & 0xFF), err
+func (b *Bus) ReadByte(ctx context.Context, address uint64) (byte, error) {
+	v, err := b.ReadSized(ctx, address, 1)
+	return uint8(v), err
 }
 
 // ReadShort performs a uint16 read in the bus
-func (b *Bus) ReadShort(ctx context.Context, address uint32) (uint16, error) {
-	v, err := b.Read(ctx, address)
-	return uint16(v & 0xFFFF), err
+func (b *Bus) ReadShort(ctx context.Context, address uint64) (uint16, error) {
+	v, err := b.ReadSized(ctx, address, 2)
+	return uint16(v), err
 }
 
 // ReadWord performs a uint32 read in the bus
-func (b *Bus) ReadWord(ctx context.Context, address uint32) (uint32, error) {
-	return b.Read(ctx, address)
+func (b *Bus) ReadWord(ctx context.Context, address uint64) (uint32, error) {
+	v, err := b.ReadSized(ctx, address, 4)
+	return uint32(v), err
+}
+
+// ReadDoubleWord performs a uint64 read in the bus as two uint32 reads
+func (b *Bus) ReadDoubleWord(ctx context.Context, address uint64) (uint64, error) {
+	return b.ReadSized(ctx, address, 8)
 }
 
 // WriteByte performs a byte write in the bus
-func (b *Bus) WriteByte(ctx context.Context, address uint32, value byte) error {
-	return b.Write(ctx, address, uint32(value), 1)
+func (b *Bus) WriteByte(ctx context.Context, address uint64, value byte) error {
+	return b.WriteSized(ctx, address, uint64(value), 1)
 }
 
-// WriteShort performs a byte write in the bus
-func (b *Bus) WriteShort(ctx context.Context, address uint32, value uint16) error {
-	return b.Write(ctx, address, uint32(value), 3)
+// WriteShort performs a uint16 write in the bus
+func (b *Bus) WriteShort(ctx context.Context, address uint64, value uint16) error {
+	return b.WriteSized(ctx, address, uint64(value), 2)
 }
 
 // WriteWord performs a uint32 write in the bus
-func (b *Bus) WriteWord(ctx context.Context, address uint32, value uint32) error {
-	return b.Write(ctx, address, value, 15)
+func (b *Bus) WriteWord(ctx context.Context, address uint64, value uint32) error {
+	return b.WriteSized(ctx, address, uint64(value), 4)
+}
+
+// WriteDoubleWord performs a uint64 write in the bus as two uint32 writes
+func (b *Bus) WriteDoubleWord(ctx context.Context, address uint64, value uint64) error {
+	return b.WriteSized(ctx, address, value, 8)
+}
+
+// laneSpan returns the number of bytes of the access at address with size bytes remaining that fits in the word,
+// with the byte lanes they use
+func laneSpan(address, size uint64) (uint64, byte) {
+	offset := address & 3
+	n := 4 - offset
+	if size < n {
+		n = size
+	}
+	return n, byte((1<<n - 1) << offset)
+}
+
+// ReadSized reads size (up to 8) bytes at address using a transaction for each word touched by the access
+// Each transaction only enables the byte lanes being read
+// Memory maps are read directly with a single access
+func (b *Bus) ReadSized(ctx context.Context, address, size uint64) (uint64, error) {
+	if m := b.getMemoryMap(address, size, AccessLoad); m != nil {
+		data := m.Memory[address-m.Start:]
+		switch size {
+		case 1:
+			return uint64(data[0]), nil
+		case 2:
+			return uint64(binary.LittleEndian.Uint16(data)), nil
+		case 4:
+			return uint64(binary.LittleEndian.Uint32(data)), nil
+		case 8:
+			return binary.LittleEndian.Uint64(data), nil
+		}
+	}
+
+	value := uint64(0)
+	for done := uint64(0); done < size; {
+		addr := address + done
+		n, lanes := laneSpan(addr, size-done)
+		word, err := b.Read(ctx, addr, lanes)
+		if err != nil {
+			return 0, err
+		}
+		value |= (uint64(word>>(8*(addr&3))) & (1<<(8*n) - 1)) << (8 * done)
+		done += n
+	}
+	return value, nil
+}
+
+// WriteSized writes the lower size (up to 8) bytes of value at address using a transaction for each word touched by the access
+// Each transaction only enables the byte lanes being written
+// Memory maps are written directly with a single access
+func (b *Bus) WriteSized(ctx context.Context, address, value, size uint64) error {
+	if m := b.getMemoryMap(address, size, AccessStore); m != nil && size&(size-1) == 0 && size <= 8 {
+		if atomic.LoadInt32(&b.numReservations) > 0 {
+			b.invalidateReservations(address)
+			b.invalidateReservations(address + size - 1)
+		}
+		data := m.Memory[address-m.Start:]
+		switch size {
+		case 1:
+			data[0] = byte(value)
+		case 2:
+			binary.LittleEndian.PutUint16(data, uint16(value))
+		case 4:
+			binary.LittleEndian.PutUint32(data, uint32(value))
+		case 8:
+			binary.LittleEndian.PutUint64(data, value)
+		}
+		return nil
+	}
+
+	for done := uint64(0); done < size; {
+		addr := address + done
+		n, lanes := laneSpan(addr, size-done)
+		if err := b.Write(ctx, addr, uint32(value>>(8*done))<<(8*(addr&3)), lanes); err != nil {
+			return err
+		}
+		done += n
+	}
+	return nil
 }
 
-const busMapHeadFormat = "%20s %8s %8s %2s\n"
+const busMapHeadFormat = "%20s %8s %8s %3s\n"
 
 // String returns all current maps in human readable format
 func (b *Bus) String() string {
 	var mapNames []string
-	result := fmt.Sprintf(busMapHeadFormat, "Name", "Start", "End", "RW")
+	result := fmt.Sprintf(busMapHeadFormat, "Name", "Start", "End", "RWX")
 	for n := range b.handlers {
 		mapNames = append(mapNames, n)
 	}
@@ -0,0 +1,270 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+type busTransaction struct {
+	address uint64
+	value   uint32
+	mask    byte
+}
+
+func TestBus_Lanes(t *testing.T) {
+	bus := CreateBus(nil)
+	memory := make([]byte, 16)
+	var transactions []busTransaction
+
+	readData := func(ctx context.Context, address uint64, readMask byte) (uint32, error) {
+		transactions = append(transactions, busTransaction{address, 0, readMask})
+		return binary.LittleEndian.Uint32(memory[address:]), nil
+	}
+	writeData := func(ctx context.Context, address uint64, value uint32, writeMask byte) error {
+		transactions = append(transactions, busTransaction{address, value, writeMask})
+		current := binary.LittleEndian.Uint32(memory[address:])
+		binary.LittleEndian.PutUint32(memory[address:], MergeLanes(current, value, writeMask))
+		return nil
+	}
+	if err := bus.Map("memory", 0, uint64(len(memory)), readData, writeData); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	tests := []struct {
+		name         string
+		address      uint64
+		size         uint64
+		value        uint64
+		transactions []busTransaction
+	}{
+		{"byte lane 1", 1, 1, 0xAB, []busTransaction{{0, 0x0000AB00, 0b0010}}},
+		{"short lane 2", 2, 2, 0xBEEF, []busTransaction{{0, 0xBEEF0000, 0b1100}}},
+		{"word", 4, 4, 0xCAFEBABE, []busTransaction{{4, 0xCAFEBABE, 0b1111}}},
+		{"unaligned short", 7, 2, 0x1234, []busTransaction{{4, 0x34000000, 0b1000}, {8, 0x00000012, 0b0001}}},
+		{"unaligned word", 5, 4, 0x11223344, []busTransaction{{4, 0x22334400, 0b1110}, {8, 0x00000011, 0b0001}}},
+		{"double word", 8, 8, 0x01020304_05060708, []busTransaction{{8, 0x05060708, 0b1111}, {12, 0x01020304, 0b1111}}},
+	}
+
+	for _, test := range tests {
+		transactions = nil
+		if err := bus.WriteSized(ctx, test.address, test.value, test.size); err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if len(transactions) != len(test.transactions) {
+			t.Errorf("%s: Expected %d write transactions but got %d", test.name, len(test.transactions), len(transactions))
+			continue
+		}
+		for i, expected := range test.transactions {
+			if transactions[i] != expected {
+				t.Errorf("%s: Expected write %+v but got %+v", test.name, expected, transactions[i])
+			}
+		}
+
+		transactions = nil
+		v, err := bus.ReadSized(ctx, test.address, test.size)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if v != test.value {
+			t.Errorf("%s: Expected to read %x but got %x", test.name, test.value, v)
+		}
+		for i, expected := range test.transactions {
+			if i >= len(transactions) || transactions[i].address != expected.address || transactions[i].mask != expected.mask {
+				t.Errorf("%s: Expected read of lanes %04b at %x", test.name, expected.mask, expected.address)
+			}
+		}
+	}
+}
+
+func TestBus_Permissions(t *testing.T) {
+	bus := CreateBus(nil)
+	readData := func(ctx context.Context, address uint64, readMask byte) (uint32, error) {
+		return 0x00000013, nil
+	}
+	writeData := func(ctx context.Context, address uint64, value uint32, writeMask byte) error {
+		return nil
+	}
+	if err := bus.Map("memory", 0, 0x100, readData, writeData); err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.MapIO("io", 0x100, 0x200, readData, writeData); err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.Map("rom", 0x200, 0x300, readData, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.Map("xom", 0x300, 0x400, readData, writeData); err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.SetPermissions("xom", BusExecute); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		address uint64
+		access  AccessType
+		allowed bool
+	}{
+		{"memory fetch", 0x000, AccessFetch, true},
+		{"memory store", 0x000, AccessStore, true},
+		{"io fetch", 0x100, AccessFetch, false},
+		{"io load", 0x100, AccessLoad, true},
+		{"rom fetch", 0x200, AccessFetch, true},
+		{"rom store", 0x200, AccessStore, false},
+		{"execute only fetch", 0x300, AccessFetch, true},
+		{"execute only load", 0x300, AccessLoad, false},
+		{"execute only store", 0x300, AccessStore, false},
+	}
+
+	for _, test := range tests {
+		var err error
+		switch test.access {
+		case AccessFetch:
+			_, err = bus.Fetch(ctx, test.address, 0xF)
+		case AccessLoad:
+			_, err = bus.Read(ctx, test.address, 0xF)
+		case AccessStore:
+			err = bus.Write(ctx, test.address, 0, 0xF)
+		}
+		if (err == nil) != test.allowed {
+			t.Errorf("%s: Expected allowed to be %t but got %v", test.name, test.allowed, err)
+		}
+	}
+
+	if err := bus.SetPermissions("none", BusRead); err == nil {
+		t.Errorf("Expected an error setting the permissions of a map that does not exist")
+	}
+}
+
+func TestBus_Lookup(t *testing.T) {
+	bus := CreateBus(nil)
+	for i := uint64(0); i < 12; i++ {
+		value := uint32(i)
+		readData := func(ctx context.Context, address uint64, readMask byte) (uint32, error) {
+			return value, nil
+		}
+		// Devices of 0x100 bytes every 0x1000 bytes, mapped out of order
+		start := ((i * 7) % 12) * 0x1000
+		if err := bus.Map(fmt.Sprintf("device%d", i), start, start+0x100, readData, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bus.Unmap("device3")
+
+	ctx := context.Background()
+	for _, address := range []uint64{0x0000, 0x70fc, 0x7100, 0x2000, 0x5080, 0xb0fc, 0xc000, 0x0ffc, 0x9000, 0x9000} {
+		expected := -1
+		for i := uint64(0); i < 12; i++ {
+			if start := ((i * 7) % 12) * 0x1000; i != 3 && address >= start && address < start+0x100 {
+				expected = int(i)
+			}
+		}
+
+		v, err := bus.ReadWord(ctx, address)
+		if expected < 0 {
+			if err == nil {
+				t.Errorf("%08x: Expected an unmapped error but got %d", address, v)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%08x: Expected no error but got %s", address, err)
+		} else if v != uint32(expected) {
+			t.Errorf("%08x: Expected to read device %d but got %d", address, expected, v)
+		}
+	}
+}
+
+func TestBus_MapMemory(t *testing.T) {
+	bus := CreateBus(nil)
+	ram := make([]byte, 16)
+	rom := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	if err := bus.MapMemory("ram", 0x100, ram, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.MapMemory("rom", 0x200, rom, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.MapMemory("unaligned", 0x302, make([]byte, 4), true); err == nil {
+		t.Errorf("Expected an error mapping an unaligned memory")
+	}
+
+	ctx := context.Background()
+	if err := bus.WriteSized(ctx, 0x103, 0x11223344, 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.WriteSized(ctx, 0x108, 0x0102030405060708, 8); err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.Write(ctx, 0x10C, 0xAABBCCDD, 0b0110); err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{0, 0, 0, 0x44, 0x33, 0x22, 0x11, 0, 8, 7, 6, 5, 4, 0xCC, 0xBB, 1}
+	if !bytes.Equal(ram, expected) {
+		t.Errorf("Expected the memory to be % x but got % x", expected, ram)
+	}
+	if v, err := bus.ReadSized(ctx, 0x105, 2); err != nil || v != 0x1122 {
+		t.Errorf("Expected to read 1122 but got %x (%v)", v, err)
+	}
+	if v, err := bus.Fetch(ctx, 0x204, 0xF); err != nil || v != 0x08070605 {
+		t.Errorf("Expected to fetch 08070605 but got %x (%v)", v, err)
+	}
+	if err := bus.WriteSized(ctx, 0x200, 0, 1); err == nil {
+		t.Errorf("Expected an error writing the rom")
+	}
+	if _, err := bus.ReadSized(ctx, 0x10E, 4); err == nil {
+		t.Errorf("Expected an error reading past the end of the memory")
+	}
+
+	// Direct writes invalidate the reservations as well
+	bus.Reserve(1, 0x108)
+	if err := bus.WriteSized(ctx, 0x10F, 0, 1); err != nil {
+		t.Fatal(err)
+	}
+	if bus.ClaimReservation(1, 0x108) {
+		t.Errorf("Expected the reservation to be invalidated by the write")
+	}
+
+	// The map can still be restricted
+	if err := bus.SetPermissions("ram", BusRead); err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.WriteSized(ctx, 0x100, 0, 4); err == nil {
+		t.Errorf("Expected an error writing a read only memory")
+	}
+}
+
+func BenchmarkBus_Read(b *testing.B) {
+	bus := CreateBus(nil)
+	readData := func(ctx context.Context, address uint64, readMask byte) (uint32, error) {
+		return 0, nil
+	}
+	for i := uint64(0); i < 12; i++ {
+		if err := bus.MapIO(fmt.Sprintf("device%d", i), 0x8000_0000+i*0x1000, 0x8000_0100+i*0x1000, readData, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := bus.MapMemory("ram", 0, make([]byte, 0x10000), true); err != nil {
+		b.Fatal(err)
+	}
+
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		address := uint64(i*4) & 0xFFFF
+		if i&15 == 0 {
+			address = 0x8000_0000 + uint64(i&0xF000)%0xC000
+		}
+		if _, err := bus.ReadSized(ctx, address, 4); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -2,83 +2,207 @@ package core
 
 import (
 	"context"
+	"encoding/binary"
 	"fmt"
 )
 
 // BusWriteHandle is a handler for bus writes
-// writeMask lower nibble specifies which bytes will be changed
+// address is always word aligned and writeMask lower nibble specifies which byte lanes of the word will be changed
+// The value is already shifted to the lanes, so a byte write at address+1 has its byte in value bits 8-15
 // writeMask == 1 (0x000000FF)
 // writeMask == 2 (0x0000FF00)
 // writeMask == 3 (0x0000FFFF)
 // writeMask == 4 (0x00FF0000)
 // writeMask == 5 (0x00FF00FF)
 // (...)
-type BusWriteHandle func(ctx context.Context, address, value uint32, writeMask byte) error
+type BusWriteHandle func(ctx context.Context, address uint64, value uint32, writeMask byte) error
 
 // BusReadHandle is a handler for bus reads
-type BusReadHandle func(ctx context.Context, address uint32) (uint32, error)
+// address is always word aligned and readMask specifies which byte lanes are being read, in the same format as writeMask
+// Only the lanes in readMask are used from the returned value, so memories can ignore it
+// Devices with side effects on read should only apply them when the lanes of the register are read
+type BusReadHandle func(ctx context.Context, address uint64, readMask byte) (uint32, error)
+
+// AccessType is the kind of a memory access (bus transaction or address translation)
+type AccessType int
+
+const (
+	AccessFetch AccessType = iota // Instruction fetch
+	AccessLoad
+	AccessStore
+)
+
+// String returns the name of the access type
+func (a AccessType) String() string {
+	switch a {
+	case AccessFetch:
+		return "fetch"
+	case AccessLoad:
+		return "load"
+	case AccessStore:
+		return "store"
+	}
+	return "unknown"
+}
+
+// BusPermission is a set of the access types allowed in a bus map
+type BusPermission byte
+
+const (
+	BusRead    BusPermission = 1 << 0
+	BusWrite   BusPermission = 1 << 1
+	BusExecute BusPermission = 1 << 2
+)
+
+// allows returns true if the permission allows the access type
+func (p BusPermission) allows(access AccessType) bool {
+	switch access {
+	case AccessFetch:
+		return p&BusExecute > 0
+	case AccessLoad:
+		return p&BusRead > 0
+	}
+	return p&BusWrite > 0
+}
+
+// LaneMask expands a byte lane mask (like writeMask) to the bits of the word in the lanes
+func LaneMask(mask byte) uint32 {
+	m := uint32(0)
+	for i := 0; i < 4; i++ {
+		if mask&(1<<i) > 0 {
+			m |= 0xFF << (8 * i)
+		}
+	}
+	return m
+}
+
+// MergeLanes returns current with the byte lanes in mask replaced by the ones in value
+func MergeLanes(current, value uint32, mask byte) uint32 {
+	m := LaneMask(mask)
+	return current&^m | value&m
+}
 
 // BusMap represents a mapping range of the bus
 type BusMap struct {
 	// Name is the name of the mapping
 	Name string
 	// Start of the bus map (inclusive)
-	Start uint32
+	Start uint64
 	// End of the bus map (exclusive)
-	End uint32
+	End uint64
 	// RHandler is the read handler (can be nil if no read permission)
 	RHandler BusReadHandle
 	// WHandler is the write handler (can be nil if no write permission)
 	WHandler BusWriteHandle
+	// Permissions are the access types allowed in the map
+	// Loads and fetches also need RHandler and stores need WHandler
+	Permissions BusPermission
+	// Memory is the storage of a memory map (see MapMemory), which the bus reads and writes directly
+	// It is nil for the maps of devices
+	Memory []byte
 }
 
-const busMapLineFormat = "%20s %08x %08x %2s"
+const busMapLineFormat = "%20s %08x %08x %3s"
 
 // String returns the map specification
 func (b BusMap) String() string {
-	rw := ""
-	if b.RHandler != nil {
-		rw += "R"
-	} else {
-		rw += "-"
+	rwx := []byte("---")
+	if b.RHandler != nil && b.Permissions&BusRead > 0 {
+		rwx[0] = 'R'
 	}
-	if b.WHandler != nil {
-		rw += "W"
-	} else {
-		rw += "-"
+	if b.WHandler != nil && b.Permissions&BusWrite > 0 {
+		rwx[1] = 'W'
 	}
-	return fmt.Sprintf(busMapLineFormat, b.Name, b.Start, b.End-1, rw)
+	if b.RHandler != nil && b.Permissions&BusExecute > 0 {
+		rwx[2] = 'X'
+	}
+	return fmt.Sprintf(busMapLineFormat, b.Name, b.Start, b.End-1, rwx)
 }
 
 // In returns true in case of the specified address to be inside that map
-func (b BusMap) In(address uint32) bool {
+func (b BusMap) In(address uint64) bool {
 	return address >= b.Start && address < b.End
 }
 
 // OverlapsWith returns true in case the specified range overlaps with the current map
-func (b BusMap) OverlapsWith(startAddress, endAddress uint32) bool {
+func (b BusMap) OverlapsWith(startAddress, endAddress uint64) bool {
 	return startAddress < b.End && endAddress > b.Start
 }
 
 // Map tries to map a space handler
-func (b *Bus) Map(name string, startAddress, endAddress uint32, rhandler BusReadHandle, whandler BusWriteHandle) error {
+// The map can be read and executed if there is a read handler, and written if there is a write handler
+// Use SetPermissions to restrict it, like making a MMIO region not executable
+func (b *Bus) Map(name string, startAddress, endAddress uint64, rhandler BusReadHandle, whandler BusWriteHandle) error {
 	for _, m := range b.handlers {
 		if m.OverlapsWith(startAddress, endAddress) {
 			return fmt.Errorf("read range %08x-%08x is already mapped to %q", startAddress, endAddress, m.Name)
 		}
 	}
 	b.handlers[name] = BusMap{
-		Name:     name,
-		Start:    startAddress,
-		End:      endAddress,
-		RHandler: rhandler,
-		WHandler: whandler,
+		Name:        name,
+		Start:       startAddress,
+		End:         endAddress,
+		RHandler:    rhandler,
+		WHandler:    whandler,
+		Permissions: BusRead | BusWrite | BusExecute,
+	}
+	b.updateRegions()
+
+	return nil
+}
+
+// MapMemory maps a plain memory, like a RAM (writable) or a ROM, at the start address
+// Loads, stores and fetches read and write data directly, without going through handlers
+// The start address and the size of data must be multiples of 4
+func (b *Bus) MapMemory(name string, startAddress uint64, data []byte, writable bool) error {
+	if startAddress&3 != 0 || len(data)&3 != 0 {
+		return fmt.Errorf("memory %q at %08x with %d bytes is not word aligned", name, startAddress, len(data))
+	}
+
+	rhandler := func(ctx context.Context, address uint64, readMask byte) (uint32, error) {
+		return binary.LittleEndian.Uint32(data[address-startAddress:]), nil
+	}
+	var whandler BusWriteHandle
+	if writable {
+		whandler = func(ctx context.Context, address uint64, value uint32, writeMask byte) error {
+			current := binary.LittleEndian.Uint32(data[address-startAddress:])
+			binary.LittleEndian.PutUint32(data[address-startAddress:], MergeLanes(current, value, writeMask))
+			return nil
+		}
+	}
+
+	if err := b.Map(name, startAddress, startAddress+uint64(len(data)), rhandler, whandler); err != nil {
+		return err
 	}
+	m := b.handlers[name]
+	m.Memory = data
+	b.handlers[name] = m
+	b.updateRegions()
+	return nil
+}
 
+// MapIO maps the handlers of a memory mapped IO region, which can be read and written but not executed
+func (b *Bus) MapIO(name string, startAddress, endAddress uint64, rhandler BusReadHandle, whandler BusWriteHandle) error {
+	if err := b.Map(name, startAddress, endAddress, rhandler, whandler); err != nil {
+		return err
+	}
+	return b.SetPermissions(name, BusRead|BusWrite)
+}
+
+// SetPermissions sets the access types allowed in the map with the specified name
+func (b *Bus) SetPermissions(name string, permissions BusPermission) error {
+	m, ok := b.handlers[name]
+	if !ok {
+		return fmt.Errorf("%q is not mapped", name)
+	}
+	m.Permissions = permissions
+	b.handlers[name] = m
+	b.updateRegions()
 	return nil
 }
 
 // UnmapRead removes a bus read mapping with the specified name
 func (b *Bus) Unmap(name string) {
 	delete(b.handlers, name)
+	b.updateRegions()
 }
@@ -0,0 +1,233 @@
+package core
+
+import (
+	"context"
+	"errors"
+)
+
+// isCompressed returns true if the instruction is a 16 bit compressed instruction (bits [1:0] != 11)
+func isCompressed(ins uint32) bool {
+	return ins&3 != 3
+}
+
+// Encoders for the 32 bit instruction formats, used to expand compressed instructions
+
+func encodeR(opcode, rd, funct3, rs1, rs2, funct7 uint32) uint32 {
+	return funct7<<25 | rs2<<20 | rs1<<15 | funct3<<12 | rd<<7 | opcode
+}
+
+func encodeI(opcode, rd, funct3, rs1, imm uint32) uint32 {
+	return (imm&0xFFF)<<20 | rs1<<15 | funct3<<12 | rd<<7 | opcode
+}
+
+func encodeS(opcode, funct3, rs1, rs2, imm uint32) uint32 {
+	return (imm>>5&0x7F)<<25 | rs2<<20 | rs1<<15 | funct3<<12 | (imm&0x1F)<<7 | opcode
+}
+
+func encodeB(funct3, rs1, rs2, imm uint32) uint32 {
+	return (imm>>12&1)<<31 | (imm>>5&0x3F)<<25 | rs2<<20 | rs1<<15 | funct3<<12 | (imm>>1&0xF)<<8 | (imm>>11&1)<<7 | 0b1100011
+}
+
+func encodeJ(rd, imm uint32) uint32 {
+	return (imm>>20&1)<<31 | (imm>>1&0x3FF)<<21 | (imm>>11&1)<<20 | (imm>>12&0xFF)<<12 | rd<<7 | 0b1101111
+}
+
+func encodeU(opcode, rd, imm uint32) uint32 {
+	return imm&0xFFFFF000 | rd<<7 | opcode
+}
+
+// cbits returns the bits [hi:lo] of the compressed instruction shifted to the position pos
+func cbits(ins uint32, hi, lo, pos uint32) uint32 {
+	return (ins >> lo) & (1<<(hi-lo+1) - 1) << pos
+}
+
+// expandCompressed expands a RVC instruction into the equivalent 32 bit instruction
+// xlen selects between the RV32C and RV64C encodings. Returns false if the instruction is illegal or reserved
+func expandCompressed(ins uint32, xlen int) (uint32, bool) {
+	rv64 := xlen == 64
+	op := ins & 3
+	funct3 := ins >> 13 & 7
+	rd := ins >> 7 & 0x1F // rd / rs1
+	rs2 := ins >> 2 & 0x1F
+	rdp := ins>>2&7 + 8  // rd' / rs2'
+	rs1p := ins>>7&7 + 8 // rs1' / rd'
+
+	// Common immediates
+	immCI := uint32(signExtend(cbits(ins, 12, 12, 5)|cbits(ins, 6, 2, 0), 6))
+	uimmW := cbits(ins, 12, 10, 3) | cbits(ins, 6, 6, 2) | cbits(ins, 5, 5, 6) // c.lw / c.sw / c.flw / c.fsw
+	uimmD := cbits(ins, 12, 10, 3) | cbits(ins, 6, 5, 6)                       // c.fld / c.fsd / c.ld / c.sd
+
+	switch op<<3 | funct3 {
+	// Quadrant 0
+	case 0b00_000: // c.addi4spn
+		// 000 nzuimm[5:4|9:6|2|3] rd' 00
+		imm := cbits(ins, 12, 11, 4) | cbits(ins, 10, 7, 6) | cbits(ins, 6, 6, 2) | cbits(ins, 5, 5, 3)
+		if imm == 0 {
+			return 0, false
+		}
+		return encodeI(0b0010011, rdp, 0, 2, imm), true
+	case 0b00_001: // c.fld
+		return encodeI(0b0000111, rdp, 0b011, rs1p, uimmD), true
+	case 0b00_010: // c.lw
+		return encodeI(0b0000011, rdp, 0b010, rs1p, uimmW), true
+	case 0b00_011:
+		if rv64 { // c.ld
+			return encodeI(0b0000011, rdp, 0b011, rs1p, uimmD), true
+		}
+		// c.flw
+		return encodeI(0b0000111, rdp, 0b010, rs1p, uimmW), true
+	case 0b00_101: // c.fsd
+		return encodeS(0b0100111, 0b011, rs1p, rdp, uimmD), true
+	case 0b00_110: // c.sw
+		return encodeS(0b0100011, 0b010, rs1p, rdp, uimmW), true
+	case 0b00_111:
+		if rv64 { // c.sd
+			return encodeS(0b0100011, 0b011, rs1p, rdp, uimmD), true
+		}
+		// c.fsw
+		return encodeS(0b0100111, 0b010, rs1p, rdp, uimmW), true
+
+	// Quadrant 1
+	case 0b01_000: // c.addi / c.nop
+		return encodeI(0b0010011, rd, 0, rd, immCI), true
+	case 0b01_001, 0b01_101: // c.jal / c.j (c.addiw in RV64)
+		if rv64 && funct3 == 0b001 {
+			if rd == 0 {
+				return 0, false
+			}
+			return encodeI(0b0011011, rd, 0, rd, immCI), true
+		}
+		// 001 imm[11|4|9:8|10|6|7|3:1|5] 01
+		imm := cbits(ins, 12, 12, 11) | cbits(ins, 11, 11, 4) | cbits(ins, 10, 9, 8) | cbits(ins, 8, 8, 10) |
+			cbits(ins, 7, 7, 6) | cbits(ins, 6, 6, 7) | cbits(ins, 5, 3, 1) | cbits(ins, 2, 2, 5)
+		link := uint32(1)
+		if funct3 == 0b101 {
+			link = 0
+		}
+		return encodeJ(link, uint32(signExtend(imm, 12))), true
+	case 0b01_010: // c.li
+		return encodeI(0b0010011, rd, 0, 0, immCI), true
+	case 0b01_011:
+		if rd == 2 { // c.addi16sp
+			// 011 nzimm[9] 00010 nzimm[4|6|8:7|5] 01
+			imm := cbits(ins, 12, 12, 9) | cbits(ins, 6, 6, 4) | cbits(ins, 5, 5, 6) | cbits(ins, 4, 3, 7) | cbits(ins, 2, 2, 5)
+			if imm == 0 {
+				return 0, false
+			}
+			return encodeI(0b0010011, 2, 0, 2, uint32(signExtend(imm, 10))), true
+		}
+		// c.lui
+		if immCI == 0 {
+			return 0, false
+		}
+		return encodeU(0b0110111, rd, immCI<<12), true
+	case 0b01_100:
+		switch ins >> 10 & 3 {
+		case 0b00, 0b01: // c.srli / c.srai
+			if !rv64 && ins&(1<<12) != 0 { // shamt[5] must be zero on RV32
+				return 0, false
+			}
+			return encodeR(0b0010011, rs1p, 0b101, rs1p, rs2|cbits(ins, 12, 12, 5), (ins>>10&1)<<5), true
+		case 0b10: // c.andi
+			return encodeI(0b0010011, rs1p, 0b111, rs1p, immCI), true
+		}
+		if ins&(1<<12) != 0 { // c.subw / c.addw are RV64 only
+			switch {
+			case !rv64:
+				return 0, false
+			case ins>>5&3 == 0b00: // c.subw
+				return encodeR(0b0111011, rs1p, 0b000, rs1p, rdp, 0b0100000), true
+			case ins>>5&3 == 0b01: // c.addw
+				return encodeR(0b0111011, rs1p, 0b000, rs1p, rdp, 0), true
+			}
+			return 0, false
+		}
+		switch ins >> 5 & 3 {
+		case 0b00: // c.sub
+			return encodeR(0b0110011, rs1p, 0b000, rs1p, rdp, 0b0100000), true
+		case 0b01: // c.xor
+			return encodeR(0b0110011, rs1p, 0b100, rs1p, rdp, 0), true
+		case 0b10: // c.or
+			return encodeR(0b0110011, rs1p, 0b110, rs1p, rdp, 0), true
+		}
+		// c.and
+		return encodeR(0b0110011, rs1p, 0b111, rs1p, rdp, 0), true
+	case 0b01_110, 0b01_111: // c.beqz / c.bnez
+		// 110 offset[8|4:3] rs1' offset[7:6|2:1|5] 01
+		imm := cbits(ins, 12, 12, 8) | cbits(ins, 11, 10, 3) | cbits(ins, 6, 5, 6) | cbits(ins, 4, 3, 1) | cbits(ins, 2, 2, 5)
+		return encodeB(funct3&1, rs1p, 0, uint32(signExtend(imm, 9))), true
+
+	// Quadrant 2
+	case 0b10_000: // c.slli
+		if !rv64 && ins&(1<<12) != 0 {
+			return 0, false
+		}
+		return encodeI(0b0010011, rd, 0b001, rd, rs2|cbits(ins, 12, 12, 5)), true
+	case 0b10_001: // c.fldsp
+		imm := cbits(ins, 12, 12, 5) | cbits(ins, 6, 5, 3) | cbits(ins, 4, 2, 6)
+		return encodeI(0b0000111, rd, 0b011, 2, imm), true
+	case 0b10_010, 0b10_011: // c.lwsp / c.flwsp
+		imm := cbits(ins, 12, 12, 5) | cbits(ins, 6, 4, 2) | cbits(ins, 3, 2, 6)
+		if funct3 == 0b011 && rv64 { // c.ldsp
+			if rd == 0 {
+				return 0, false
+			}
+			return encodeI(0b0000011, rd, 0b011, 2, cbits(ins, 12, 12, 5)|cbits(ins, 6, 5, 3)|cbits(ins, 4, 2, 6)), true
+		}
+		if funct3 == 0b011 {
+			return encodeI(0b0000111, rd, 0b010, 2, imm), true
+		}
+		if rd == 0 {
+			return 0, false
+		}
+		return encodeI(0b0000011, rd, 0b010, 2, imm), true
+	case 0b10_100:
+		if ins&(1<<12) == 0 {
+			if rs2 != 0 { // c.mv
+				return encodeR(0b0110011, rd, 0, 0, rs2, 0), true
+			}
+			if rd == 0 {
+				return 0, false
+			}
+			return encodeI(0b1100111, 0, 0, rd, 0), true // c.jr
+		}
+		if rs2 != 0 { // c.add
+			return encodeR(0b0110011, rd, 0, rd, rs2, 0), true
+		}
+		if rd == 0 { // c.ebreak
+			return 0x00100073, true
+		}
+		return encodeI(0b1100111, 1, 0, rd, 0), true // c.jalr
+	case 0b10_101: // c.fsdsp
+		imm := cbits(ins, 12, 10, 3) | cbits(ins, 9, 7, 6)
+		return encodeS(0b0100111, 0b011, 2, rs2, imm), true
+	case 0b10_110, 0b10_111: // c.swsp / c.fswsp
+		imm := cbits(ins, 12, 9, 2) | cbits(ins, 8, 7, 6)
+		if funct3 == 0b111 && rv64 { // c.sdsp
+			return encodeS(0b0100011, 0b011, 2, rs2, cbits(ins, 12, 10, 3)|cbits(ins, 9, 7, 6)), true
+		}
+		if funct3 == 0b111 {
+			return encodeS(0b0100111, 0b010, 2, rs2, imm), true
+		}
+		return encodeS(0b0100011, 0b010, 2, rs2, imm), true
+	}
+
+	return 0, false
+}
+
+// runCompressed runs a RVC instruction
+// The instruction is expanded to the equivalent 32 bit instruction, pc should already point to the next instruction
+func (rv32 *RISCV) runCompressed(ctx context.Context, ins uint16) error {
+	expanded, ok := expandCompressed(uint32(ins), rv32.isa.XLEN)
+	if !ok {
+		return rv32.illegalInstruction(uint32(ins))
+	}
+	err := rv32.runInstruction(ctx, expanded)
+
+	var ex Exception
+	if errors.As(err, &ex) && ex.Cause == ExceptionIllegalInstruction { // mtval should hold the original instruction
+		ex.Value = uint64(ins)
+		return ex
+	}
+	return err
+}
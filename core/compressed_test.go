@@ -0,0 +1,118 @@
+package core
+
+import "testing"
+
+func TestExpandCompressed(t *testing.T) {
+	tests := []struct {
+		compressed uint32
+		expanded   uint32
+	}{
+		{0x1fe0, 0x3fc10413}, // c.addi4spn x8, x2, 1020
+		{0x005c, 0x00410793}, // c.addi4spn x15, x2, 4
+		{0x3fe0, 0x0f87b407}, // c.fld f8, 248(x15)
+		{0x5d64, 0x07c52483}, // c.lw x9, 124(x10)
+		{0x405c, 0x00442783}, // c.lw x15, 4(x8)
+		{0x61a4, 0x0405a487}, // c.flw f9, 64(x11)
+		{0xa608, 0x00a63427}, // c.fsd f10, 8(x12)
+		{0xc374, 0x04d72223}, // c.sw x13, 68(x14)
+		{0xfc7c, 0x06f42e27}, // c.fsw f15, 124(x8)
+		{0x0001, 0x00000013}, // c.nop
+		{0x1281, 0xfe028293}, // c.addi x5, -32
+		{0x00fd, 0x01f08093}, // c.addi x1, 31
+		{0x2ffd, 0x7fe000ef}, // c.jal 2046
+		{0x3001, 0x801ff0ef}, // c.jal -2048
+		{0x53fd, 0xfff00393}, // c.li x7, -1
+		{0x7101, 0xe0010113}, // c.addi16sp x2, -512
+		{0x617d, 0x1f010113}, // c.addi16sp x2, 496
+		{0x7181, 0xfffe01b7}, // c.lui x3, 0xfffe0
+		{0x627d, 0x0001f237}, // c.lui x4, 31
+		{0x807d, 0x01f45413}, // c.srli x8, 31
+		{0x8485, 0x4014d493}, // c.srai x9, 1
+		{0x9965, 0xff957513}, // c.andi x10, -7
+		{0x8d91, 0x40c585b3}, // c.sub x11, x12
+		{0x8eb9, 0x00e6c6b3}, // c.xor x13, x14
+		{0x8fc1, 0x0087e7b3}, // c.or x15, x8
+		{0x8c65, 0x00947433}, // c.and x8, x9
+		{0xbffd, 0xfffff06f}, // c.j -2
+		{0xa6e5, 0x3e80006f}, // c.j 1000
+		{0xd001, 0xf00400e3}, // c.beqz x8, -256
+		{0xeffd, 0x0e079f63}, // c.bnez x15, 254
+		{0x037e, 0x01f31313}, // c.slli x6, 31
+		{0x30fe, 0x1f813087}, // c.fldsp f1, 504(x2)
+		{0x51fe, 0x0fc12183}, // c.lwsp x3, 252(x2)
+		{0x6f92, 0x00412f87}, // c.flwsp f31, 4(x2)
+		{0x8082, 0x00008067}, // c.jr x1
+		{0x829a, 0x006002b3}, // c.mv x5, x6
+		{0x9002, 0x00100073}, // c.ebreak
+		{0x9382, 0x000380e7}, // c.jalr x7
+		{0x94aa, 0x00a484b3}, // c.add x9, x10
+		{0xbf8a, 0x1e213c27}, // c.fsdsp f2, 504(x2)
+		{0xdffe, 0x0ff12e23}, // c.swsp x31, 252(x2)
+		{0xe00e, 0x00312027}, // c.fswsp f3, 0(x2)
+	}
+
+	for _, test := range tests {
+		expanded, ok := expandCompressed(test.compressed, 32)
+		if !ok {
+			t.Errorf("failed to expand %04x: expected %08x but got illegal", test.compressed, test.expanded)
+			continue
+		}
+		if expanded != test.expanded {
+			t.Errorf("failed to expand %04x: expected %08x but got %08x", test.compressed, test.expanded, expanded)
+		}
+	}
+}
+
+func TestExpandCompressedIllegal(t *testing.T) {
+	illegal := []uint32{
+		0x0000, // All zeros
+		0x0010, // c.addi4spn with nzuimm = 0
+		0x6101, // c.addi16sp with nzimm = 0
+		0x6181, // c.lui with nzimm = 0
+		0x9001, // c.srli with shamt[5] on RV32
+		0x9c21, // c.subw (RV64 only)
+		0x1002, // c.slli with shamt[5] on RV32
+		0x4002, // c.lwsp with rd = 0
+		0x8002, // c.jr with rs1 = 0
+		0x8000, // Reserved quadrant 0 opcode
+	}
+
+	for _, ins := range illegal {
+		if expanded, ok := expandCompressed(ins, 32); ok {
+			t.Errorf("expected %04x to be illegal but got %08x", ins, expanded)
+		}
+	}
+}
+
+func TestExpandCompressedRV64(t *testing.T) {
+	tests := []struct {
+		compressed uint32
+		expanded   uint32
+	}{
+		{0x7d64, 0x0f853483}, // c.ld x9, 248(x10)
+		{0xe714, 0x00d73423}, // c.sd x13, 8(x14)
+		{0x72fe, 0x1f813283}, // c.ldsp x5, 504(x2)
+		{0xe07e, 0x01f13023}, // c.sdsp x31, 0(x2)
+		{0x32fd, 0xfff2829b}, // c.addiw x5, -1
+		{0x9c05, 0x4094043b}, // c.subw x8, x9
+		{0x9f3d, 0x00f7073b}, // c.addw x14, x15
+		{0x137e, 0x03f31313}, // c.slli x6, 63
+		{0x9005, 0x02145413}, // c.srli x8, 33
+		{0x9481, 0x4204d493}, // c.srai x9, 32
+	}
+
+	for _, test := range tests {
+		expanded, ok := expandCompressed(test.compressed, 64)
+		if !ok {
+			t.Errorf("failed to expand %04x: expected %08x but got illegal", test.compressed, test.expanded)
+			continue
+		}
+		if expanded != test.expanded {
+			t.Errorf("failed to expand %04x: expected %08x but got %08x", test.compressed, test.expanded, expanded)
+		}
+	}
+
+	if expanded, ok := expandCompressed(0x2001, 64); ok { // c.addiw with rd = 0
+		t.Errorf("expected 2001 to be illegal but got %08x", expanded)
+	}
+}
@@ -0,0 +1,244 @@
+package core
+
+import "fmt"
+
+// Counter CSR addresses (Zicntr / Zihpm)
+const (
+	CSRCycle         = 0xC00
+	CSRTime          = 0xC01
+	CSRInstret       = 0xC02
+	CSRHPMCounter3   = 0xC03 // hpmcounter3 - hpmcounter31
+	CSRCycleH        = 0xC80
+	CSRTimeH         = 0xC81
+	CSRInstretH      = 0xC82
+	CSRHPMCounter3H  = 0xC83 // hpmcounter3h - hpmcounter31h
+	CSRMCycle        = 0xB00
+	CSRMInstret      = 0xB02
+	CSRMHPMCounter3  = 0xB03 // mhpmcounter3 - mhpmcounter31
+	CSRMCycleH       = 0xB80
+	CSRMInstretH     = 0xB82
+	CSRMHPMCounter3H = 0xB83 // mhpmcounter3h - mhpmcounter31h
+	CSRMHPMEvent3    = 0x323 // mhpmevent3 - mhpmevent31
+	CSRMCountInhibit = 0x320
+	CSRMCounterEn    = 0x306
+	CSRSCounterEn    = 0x106
+)
+
+// Counter bits of mcountinhibit / mcounteren / scounteren
+const (
+	CounterCY = 1 << 0 // cycle
+	CounterTM = 1 << 1 // time, it cannot be inhibited
+	CounterIR = 1 << 2 // instret
+
+	hpmCounters     = 29 // hpmcounter3 - hpmcounter31
+	hpmCounterFirst = 3
+)
+
+// HPMEvent is an event that can be counted by a hpm counter (value of mhpmevent)
+type HPMEvent uint64
+
+const (
+	HPMEventNone        HPMEvent = iota // Counter disabled
+	HPMEventTakenBranch                 // Conditional branches that were taken
+	HPMEventLoad                        // Retired loads, including float loads, lr and amos
+	HPMEventStore                       // Retired stores, including float stores, successful sc and amos
+	HPMEventBusError                    // Bus errors on fetches, loads, stores and page table walks
+)
+
+// registerCounterCSRs registers the cycle, time, instret and hpm counters with their control CSRs
+// In RV32 the upper 32 bits of the counters are accessed by the CSRs ending with h
+func (rv32 *RISCV) registerCounterCSRs() {
+	csrs := map[uint32]CSR{
+		CSRMCountInhibit: {
+			Name:      "mcountinhibit",
+			WriteMask: 0xFFFFFFFF &^ CounterTM,
+			RHandler:  func() uint64 { return uint64(rv32.mcountinhibit) },
+			WHandler:  func(value uint64) { rv32.mcountinhibit = uint32(value) },
+		},
+	}
+	if rv32.isa.Has(MISAExtU) {
+		csrs[CSRMCounterEn] = CSR{
+			Name:      "mcounteren",
+			WriteMask: 0xFFFFFFFF,
+			RHandler:  func() uint64 { return uint64(rv32.mcounteren) },
+			WHandler:  func(value uint64) { rv32.mcounteren = uint32(value) },
+		}
+	}
+	if rv32.isa.Has(MISAExtS) {
+		csrs[CSRSCounterEn] = CSR{
+			Name:      "scounteren",
+			WriteMask: 0xFFFFFFFF,
+			RHandler:  func() uint64 { return uint64(rv32.scounteren) },
+			WHandler:  func(value uint64) { rv32.scounteren = uint32(value) },
+		}
+	}
+
+	rv32.counterCSRs(csrs, "cycle", 0, &rv32.mcycle)
+	rv32.counterCSRs(csrs, "instret", 2, &rv32.instret)
+	for i := 0; i < hpmCounters; i++ {
+		i := i
+		index := uint32(i + hpmCounterFirst)
+		rv32.counterCSRs(csrs, fmt.Sprintf("hpmcounter%d", index), index, &rv32.mhpmcounter[i])
+		csrs[CSRMHPMEvent3+uint32(i)] = CSR{
+			Name:      fmt.Sprintf("mhpmevent%d", index),
+			WriteMask: ^uint64(0),
+			RHandler:  func() uint64 { return rv32.mhpmevent[i] },
+			WHandler: func(value uint64) {
+				rv32.mhpmevent[i] = value & rv32.xlenMask
+				rv32.updateHPMEvents()
+			},
+		}
+	}
+
+	// time is a read-only shadow of the platform timer (mtime)
+	csrs[CSRTime] = CSR{
+		Name:      "time",
+		RHandler:  rv32.Time,
+		Available: func(priv PrivilegeLevel) bool { return rv32.counterAvailable(1, priv) },
+	}
+	if rv32.isa.XLEN == 32 {
+		csrs[CSRTimeH] = CSR{
+			Name:      "timeh",
+			RHandler:  func() uint64 { return rv32.Time() >> 32 },
+			Available: func(priv PrivilegeLevel) bool { return rv32.counterAvailable(1, priv) },
+		}
+	}
+
+	for address, csr := range csrs {
+		if err := rv32.CSR.Register(address, csr); err != nil {
+			rv32.log.Errorf("cannot register csr %s: %s", csr.Name, err)
+		}
+	}
+}
+
+// counterCSRs adds the machine CSR (mcycle, minstret, mhpmcounterN) and the user read-only shadow
+// (cycle, instret, hpmcounterN) of the counter with the specified index
+// Writes to the machine CSR only change the lower XLEN bits of the counter
+func (rv32 *RISCV) counterCSRs(csrs map[uint32]CSR, name string, index uint32, counter *uint64) {
+	available := func(priv PrivilegeLevel) bool { return rv32.counterAvailable(index, priv) }
+
+	csrs[CSRCycle+index] = CSR{
+		Name:      name,
+		RHandler:  func() uint64 { return *counter },
+		Available: available,
+	}
+	csrs[CSRMCycle+index] = CSR{
+		Name:      "m" + name,
+		WriteMask: ^uint64(0),
+		RHandler:  func() uint64 { return *counter },
+		WHandler: func(value uint64) {
+			*counter = (*counter &^ rv32.xlenMask) | (value & rv32.xlenMask)
+			rv32.counterWritten |= 1 << index
+		},
+	}
+
+	if rv32.isa.XLEN != 32 { // Upper halves of the counters only exist in RV32
+		return
+	}
+	csrs[CSRCycleH+index] = CSR{
+		Name:      name + "h",
+		RHandler:  func() uint64 { return *counter >> 32 },
+		Available: available,
+	}
+	csrs[CSRMCycleH+index] = CSR{
+		Name:      "m" + name + "h",
+		WriteMask: 0xFFFFFFFF,
+		RHandler:  func() uint64 { return *counter >> 32 },
+		WHandler: func(value uint64) {
+			*counter = (*counter & 0xFFFFFFFF) | value<<32
+			rv32.counterWritten |= 1 << index
+		},
+	}
+}
+
+// counterAvailable returns true if the user counter with the specified index can be read at the privilege level
+// Supervisor mode needs the bit set in mcounteren, and user mode also needs it in scounteren when S is implemented
+func (rv32 *RISCV) counterAvailable(index uint32, priv PrivilegeLevel) bool {
+	bit := uint32(1) << index
+	switch priv {
+	case PrivilegeSupervisor:
+		return rv32.mcounteren&bit > 0
+	case PrivilegeUser:
+		return rv32.mcounteren&bit > 0 && (!rv32.isa.Has(MISAExtS) || rv32.scounteren&bit > 0)
+	}
+	return true
+}
+
+// resetCounters clears all counters and their configuration
+func (rv32 *RISCV) resetCounters() {
+	rv32.cycleNum = 0
+	rv32.mcycle = 0
+	rv32.instret = 0
+	rv32.mhpmcounter = [hpmCounters]uint64{}
+	rv32.mhpmevent = [hpmCounters]uint64{}
+	rv32.hpmEvents = 0
+	rv32.mcountinhibit = 0
+	rv32.mcounteren = 0
+	rv32.scounteren = 0
+	rv32.counterWritten = 0
+}
+
+// updateHPMEvents updates the set of events selected by any hpm counter
+func (rv32 *RISCV) updateHPMEvents() {
+	rv32.hpmEvents = 0
+	for _, event := range rv32.mhpmevent {
+		if event != uint64(HPMEventNone) && event < 64 {
+			rv32.hpmEvents |= 1 << event
+		}
+	}
+}
+
+// countEvent increments the hpm counters that are counting the event and are not inhibited
+func (rv32 *RISCV) countEvent(event HPMEvent) {
+	if rv32.hpmEvents&(1<<event) == 0 {
+		return
+	}
+	for i, e := range rv32.mhpmevent {
+		if HPMEvent(e) == event && rv32.mcountinhibit&(1<<(i+hpmCounterFirst)) == 0 {
+			rv32.mhpmcounter[i]++
+		}
+	}
+}
+
+// countCycle increments mcycle at the start of an instruction, unless it is inhibited
+func (rv32 *RISCV) countCycle() {
+	rv32.counterWritten = 0
+	if rv32.mcountinhibit&CounterCY == 0 {
+		rv32.mcycle++
+	}
+}
+
+// countInstret increments minstret when an instruction retires, unless it is inhibited
+// An instruction that writes minstret does not increment it
+func (rv32 *RISCV) countInstret() {
+	if rv32.mcountinhibit&CounterIR == 0 && rv32.counterWritten&CounterIR == 0 {
+		rv32.instret++
+	}
+}
+
+// SetTimeSource sets the function that returns the value of the time CSR, usually the mtime of a CLINT
+// Without a time source the time CSR returns the number of cycles executed
+func (rv32 *RISCV) SetTimeSource(source func() uint64) {
+	rv32.timeSource = source
+}
+
+// Time returns the current value of the time CSR
+func (rv32 *RISCV) Time() uint64 {
+	if rv32.timeSource != nil {
+		return rv32.timeSource()
+	}
+	return rv32.cycleNum
+}
+
+// Instret returns the number of instructions retired (minstret)
+func (rv32 *RISCV) Instret() uint64 {
+	return rv32.instret
+}
+
+// HPMCounter returns the value of the hpm counter n (3 to 31)
+func (rv32 *RISCV) HPMCounter(n int) uint64 {
+	if n < hpmCounterFirst || n >= hpmCounterFirst+hpmCounters {
+		return 0
+	}
+	return rv32.mhpmcounter[n-hpmCounterFirst]
+}
@@ -0,0 +1,116 @@
+package core
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCounters_Inhibit(t *testing.T) {
+	ctx := context.Background()
+	cpu, _ := createTestCPU(t, []Option{WithISA("rv32i_zicsr")}, 0x00000013, 0x00000013, 0xB0201073) // nop, nop, csrw minstret, x0
+
+	if err := cpu.RunStep(ctx); err != nil {
+		t.Fatal(err)
+	}
+	cpu.CSR.Set(CSRMCountInhibit, CounterCY|CounterIR)
+	if err := cpu.RunStep(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if v := cpu.CSR.Get(CSRMCycle); v != 1 {
+		t.Errorf("Expected mcycle to be 1 but got %d", v)
+	}
+	if v := cpu.CSR.Get(CSRMInstret); v != 1 {
+		t.Errorf("Expected minstret to be 1 but got %d", v)
+	}
+	if cpu.Cycles() != 2 {
+		t.Errorf("Expected the device clock to not be inhibited but got %d cycles", cpu.Cycles())
+	}
+
+	// The instruction that writes minstret does not increment it
+	cpu.CSR.Set(CSRMCountInhibit, 0)
+	if err := cpu.RunStep(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if v := cpu.CSR.Get(CSRMInstret); v != 0 {
+		t.Errorf("Expected minstret to be 0 but got %d", v)
+	}
+
+	// Upper halves in RV32
+	cpu.CSR.Set(CSRMCycleH, 0x12345678)
+	if v := cpu.CSR.Get(CSRCycle); v != 0x12345678_00000002 {
+		t.Errorf("Expected cycle to be 1234567800000002 but got %016x", v)
+	}
+	if v := cpu.CSR.Get(CSRCycleH); v != 0x12345678 {
+		t.Errorf("Expected cycleh to be 12345678 but got %08x", v)
+	}
+}
+
+func TestCounters_Events(t *testing.T) {
+	ctx := context.Background()
+	cpu, _ := createTestCPU(t, nil,
+		0x00000463, // beq x0, x0, 8
+		0x00000013, // nop
+		0x00001463, // bne x0, x0, 8
+		0x10002083, // lw x1, 0x100(x0)
+		0x10102223, // sw x1, 0x104(x0)
+		0x0001A103, // lw x2, 0(x3)
+	)
+	cpu.Registers.SetInteger(3, 0x100000)
+
+	events := []HPMEvent{HPMEventTakenBranch, HPMEventLoad, HPMEventStore, HPMEventBusError, HPMEventLoad}
+	for i, event := range events {
+		cpu.CSR.Set(CSRMHPMEvent3+uint32(i), uint64(event))
+	}
+	cpu.CSR.Set(CSRMCountInhibit, 1<<7) // hpmcounter7
+
+	for i := 0; i < 4; i++ {
+		if err := cpu.RunStep(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cpu.RunStep(ctx); err == nil {
+		t.Fatal("Expected a load access fault")
+	}
+
+	for i, expected := range []uint64{1, 1, 1, 1, 0} {
+		if v := cpu.HPMCounter(i + 3); v != expected {
+			t.Errorf("Expected hpmcounter%d to be %d but got %d", i+3, expected, v)
+		}
+	}
+	if v := cpu.CSR.Get(CSRMCycle); v != 5 {
+		t.Errorf("Expected mcycle to be 5 but got %d", v)
+	}
+	if v := cpu.Instret(); v != 4 {
+		t.Errorf("Expected minstret to be 4 but got %d", v)
+	}
+}
+
+func TestCounters_Access(t *testing.T) {
+	tests := []struct {
+		name       string
+		priv       PrivilegeLevel
+		mcounteren uint64
+		scounteren uint64
+		address    uint32
+		available  bool
+	}{
+		{"machine", PrivilegeMachine, 0, 0, CSRCycle, true},
+		{"supervisor disabled", PrivilegeSupervisor, 0, 0, CSRCycle, false},
+		{"supervisor enabled", PrivilegeSupervisor, CounterCY, 0, CSRCycle, true},
+		{"supervisor time", PrivilegeSupervisor, CounterCY, 0, CSRTime, false},
+		{"user only mcounteren", PrivilegeUser, CounterIR, 0, CSRInstretH, false},
+		{"user enabled", PrivilegeUser, CounterIR, CounterIR, CSRInstretH, true},
+		{"user hpmcounter", PrivilegeUser, 1 << 31, 1 << 31, CSRHPMCounter3 + 28, true},
+		{"user mcycle", PrivilegeUser, CounterCY, CounterCY, CSRMCycle, false},
+	}
+
+	for _, test := range tests {
+		cpu := CreateEmulator(nil, WithISA("rv32imasu"))
+		cpu.CSR.Set(CSRMCounterEn, test.mcounteren)
+		cpu.CSR.Set(CSRSCounterEn, test.scounteren)
+		_, err := cpu.CSR.Read(test.address, test.priv)
+		if (err == nil) != test.available {
+			t.Errorf("%s: Expected csr %03x to be available to be %t but got %v", test.name, test.address, test.available, err)
+		}
+	}
+}
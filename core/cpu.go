@@ -4,7 +4,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/sirupsen/logrus"
-	"runtime"
+	"sync"
 	"time"
 )
 
@@ -13,75 +13,284 @@ type RISCV struct {
 
 	Registers *RegisterBank
 	Bus       *Bus
+	CSR       *CSRFile
 
-	pc          uint32
-	cycleNum    uint32
-	running     bool
-	step        bool
-	started     bool
-	breakpoints map[uint32]struct{}
+	isa              ISA
+	xlenMask         uint64 // Mask of the valid bits of a register / address
+	pc               uint64
+	insPC            uint64 // Address of the instruction being executed
+	ialignMask       uint64 // Instruction address alignment mask (1 when compressed instructions are supported, 3 otherwise)
+	priv             PrivilegeLevel
+	mstatus          uint64
+	mtvec            uint64
+	mepc             uint64 // Read with the bits below IALIGN masked
+	stvec            uint64
+	sepc             uint64 // Read with the bits below IALIGN masked
+	medeleg          uint64
+	mie              uint32
+	mip              uint32 // Accessed atomically
+	mideleg          uint32
+	satp             uint64
+	tlb              [tlbSize]tlbEntry
+	pmpcfg           []uint8
+	pmpaddr          []uint64
+	trapPolicy       TrapPolicy
+	misalignedPolicy MisalignedPolicy
+	fcsr             uint32
+	decodeCache      *decodeCache // nil when disabled
+	blocks           *blockCache  // nil unless the block engine is selected
+
+	cycleNum       uint64 // Cycles since reset, used to clock the devices. Not affected by mcountinhibit
+	mcycle         uint64
+	instret        uint64
+	mhpmcounter    [hpmCounters]uint64
+	mhpmevent      [hpmCounters]uint64
+	hpmEvents      uint64 // Set of the events selected in mhpmevent
+	mcountinhibit  uint32
+	mcounteren     uint32
+	scounteren     uint32
+	counterWritten uint32 // Counters written by the current instruction
+	timeSource     func() uint64
+
+	runLock        sync.Mutex
+	runCond        *sync.Cond // Signaled when the run state changes
+	state          RunState
+	stepping       bool     // The emulation goroutine runs a single instruction
+	pauseRequested bool     // Pause is waiting for the emulation goroutine
+	stopRequested  bool     // Stop is waiting for the emulation goroutine
+	requests       int32    // Set when other goroutines need the emulation goroutine to stop running, accessed atomically
+	pending        []func() // Functions run by the emulation goroutine between instructions
+	subscribers    []chan StopEvent
+	breakpoints    map[uint64]struct{}
+	watchpoints    map[uint64]struct{}
+	watchHit       bool
+	watchAddress   uint64 // Address of the access that hit a watchpoint
+
+	tickHandlers    []TickHandle
+	wakeupSources   []WakeupSource
+	waiting         bool // Suspended by WFI
+	idleFastForward bool
 }
 
-func CreateEmulator(log *logrus.Logger) *RISCV {
+// CreateEmulator creates a new RISC-V core
+// By default the core implements DefaultISA, use WithISA to select other extensions
+func CreateEmulator(log *logrus.Logger, opts ...Option) *RISCV {
 	if log == nil {
 		log = logrus.New()
 	}
-	return &RISCV{
+	isa, _ := ParseISA(DefaultISA)
+	rv32 := &RISCV{
 		log:         log,
 		Registers:   CreateRegisterBank(log),
 		Bus:         CreateBus(log),
-		breakpoints: make(map[uint32]struct{}),
+		CSR:         CreateCSRFile(log),
+		isa:         isa,
+		priv:        PrivilegeMachine,
+		breakpoints: make(map[uint64]struct{}),
+		watchpoints: make(map[uint64]struct{}),
+		decodeCache: newDecodeCache(),
+
+		idleFastForward: true,
+	}
+	rv32.runCond = sync.NewCond(&rv32.runLock)
+	for _, opt := range opts {
+		opt(rv32)
 	}
+	rv32.xlenMask = ^uint64(0) >> (64 - rv32.isa.XLEN)
+	rv32.ialignMask = 3
+	if rv32.isa.Has(MISAExtC) {
+		rv32.ialignMask = 1
+	}
+	rv32.mstatus = rv32.mstatusResetValue()
+
+	rv32.registerMachineCSRs()
+	rv32.registerCounterCSRs()
+	rv32.registerTrapCSRs()
+	rv32.registerInterruptCSRs()
+	rv32.registerSupervisorCSRs()
+	rv32.registerMMUCSRs()
+	rv32.registerPMPCSRs()
+	rv32.registerFloatCSRs()
+	return rv32
 }
 
 // Reset resets all registers and set the PC to 0
+// It can be called while the emulation goroutine is running, the core is reset between two instructions
 func (rv32 *RISCV) Reset() {
+	rv32.control(func() {
+		rv32.reset()
+		if rv32.state == StateHalted {
+			rv32.state = StatePaused
+		}
+	})
+}
+
+// reset resets the core, it must only be called when the core is not running
+func (rv32 *RISCV) reset() {
 	rv32.log.Infof("CPU Reset")
 	rv32.Registers.Reset()
+	rv32.CSR.Reset()
+	rv32.priv = PrivilegeMachine
+	rv32.mstatus = rv32.mstatusResetValue()
+	rv32.mtvec = 0
+	rv32.mepc = 0
+	rv32.stvec = 0
+	rv32.sepc = 0
+	rv32.medeleg = 0
+	rv32.mie = 0
+	rv32.mideleg = 0
+	rv32.satp = 0
+	rv32.FlushTLB()
+	rv32.FlushInstructionCache()
+	rv32.resetPMP()
+	rv32.fcsr = 0
+	rv32.resetCounters()
+	rv32.waiting = false
 	rv32.SetPC(0)
 }
 
-// AddBreak adds a breakpoint in the specified address
-// A breakpoint will pause the CPU when is running by Start
-func (rv32 *RISCV) AddBreak(addr uint32) {
-	rv32.breakpoints[addr] = struct{}{}
+// XLEN returns the integer register width of the core
+func (rv32 *RISCV) XLEN() int {
+	return rv32.isa.XLEN
+}
+
+// ISA returns the ISA implemented by the core
+func (rv32 *RISCV) ISA() ISA {
+	return rv32.isa
 }
 
-// DelBreak deletes a breakpoint in the specified address
-func (rv32 *RISCV) DelBreak(addr uint32) {
-	delete(rv32.breakpoints, addr)
+// Embedded returns true if the core runs as RV32E (only x0-x15 are available)
+func (rv32 *RISCV) Embedded() bool {
+	return rv32.isa.Embedded
+}
+
+// NumIntegerRegisters returns the number of integer registers available in the core
+func (rv32 *RISCV) NumIntegerRegisters() int {
+	if rv32.isa.Embedded {
+		return 16
+	}
+	return 32
 }
 
 // SetPC sets the program counter
-func (rv32 *RISCV) SetPC(pc uint32) {
+func (rv32 *RISCV) SetPC(pc uint64) {
 	//rv32.log.Debugf("Entrypoint set to 0x%08x", pc)
-	rv32.pc = pc
+	rv32.pc = pc & rv32.xlenMask
 }
 
 // GetPC gets the program counter
-func (rv32 *RISCV) GetPC() uint32 {
+func (rv32 *RISCV) GetPC() uint64 {
 	return rv32.pc
 }
 
 // AddPC adds the value offset to PC
-func (rv32 *RISCV) AddPC(value int32) {
-	rv32.pc = uint32(int32(rv32.pc) + value)
+func (rv32 *RISCV) AddPC(value int64) {
+	rv32.pc = uint64(int64(rv32.pc)+value) & rv32.xlenMask
+}
+
+// setInteger sets a integer register truncating the value to XLEN
+func (rv32 *RISCV) setInteger(registerNum uint32, value uint64) {
+	rv32.Registers.SetInteger(registerNum, value&rv32.xlenMask)
 }
 
 // RunStep runs a single instruction
+// Exceptions raised by the instruction are handled according to the trap policy
+// While the core is waiting for an interrupt (WFI) each step is an idle cycle
+// RunStep always runs a single instruction, even when the block engine is selected
+// It must not be used while the goroutine created by Start is running
 func (rv32 *RISCV) RunStep(ctx context.Context) error {
+	if !rv32.beginCycle() {
+		return nil
+	}
+
+	pc := rv32.pc
+	rv32.insPC = pc
+	if err := rv32.execute(ctx, pc); err != nil {
+		return rv32.handleException(pc, err)
+	}
+	rv32.countInstret()
+	return nil
+}
+
+// beginCycle advances the counters and the devices by one cycle and takes any pending interrupt
+// Returns false if the core is still waiting for an interrupt, so no instruction should run in the cycle
+func (rv32 *RISCV) beginCycle() bool {
+	if rv32.waiting && rv32.idleFastForward {
+		rv32.fastForward()
+	}
 	rv32.cycleNum++
-	value, err := rv32.Bus.Read(ctx, rv32.pc)
+	rv32.countCycle()
+	for _, tick := range rv32.tickHandlers {
+		tick(rv32.cycleNum)
+	}
+	if rv32.waiting && !rv32.wakeup() {
+		return false
+	}
+	rv32.checkInterrupts()
+	return true
+}
+
+// execute runs the instruction at pc, using the decode cache when it is enabled
+func (rv32 *RISCV) execute(ctx context.Context, pc uint64) error {
+	if rv32.decodeCache != nil {
+		return rv32.runDecoded(ctx, pc)
+	}
+	return rv32.runFetched(ctx, pc)
+}
+
+// runFetched fetches and runs the instruction at pc without the decode cache
+func (rv32 *RISCV) runFetched(ctx context.Context, pc uint64) error {
+	value, err := rv32.fetch(ctx, pc)
 	if err != nil {
-		rv32.log.Errorf("error reading program at %08x: %s", rv32.pc, err)
 		return err
 	}
-	rv32.pc += 4
+
+	if isCompressed(value) && rv32.isa.Has(MISAExtC) { // Without C it is decoded (and rejected) as a 32 bit instruction
+		rv32.pc = (pc + 2) & rv32.xlenMask
+		return rv32.runCompressed(ctx, uint16(value))
+	}
+	rv32.pc = (pc + 4) & rv32.xlenMask
 	return rv32.runInstruction(ctx, value)
 }
 
+// fetch reads the instruction at pc using aligned word fetch transactions
+// Compressed instructions are returned in the lower 16 bits and 32 bit instructions can cross a word boundary
+func (rv32 *RISCV) fetch(ctx context.Context, pc uint64) (uint32, error) {
+	addr, err := rv32.translate(ctx, pc, 2, AccessFetch)
+	if err != nil {
+		return 0, err
+	}
+	return rv32.fetchPhysical(ctx, pc, addr)
+}
+
+// fetchPhysical reads the instruction at pc, which was translated to the physical address addr
+func (rv32 *RISCV) fetchPhysical(ctx context.Context, pc, addr uint64) (uint32, error) {
+	word, err := rv32.Bus.Fetch(ctx, addr, 0xF)
+	if err != nil {
+		return 0, rv32.busError(ExceptionInstructionAccessFault, pc, err)
+	}
+	if pc&3 == 0 {
+		return word, nil
+	}
+
+	lo := word >> 16
+	if isCompressed(lo) {
+		return lo, nil
+	}
+
+	addr, err = rv32.translate(ctx, (pc+2)&rv32.xlenMask, 2, AccessFetch) // The upper half can be in the next page
+	if err != nil {
+		return 0, err
+	}
+	next, err := rv32.Bus.Fetch(ctx, addr, 0xF)
+	if err != nil {
+		return 0, rv32.busError(ExceptionInstructionAccessFault, (pc+2)&rv32.xlenMask, err)
+	}
+	return lo | next<<16, nil
+}
+
 // RunUntil runs the emulation until the specified code address is reached or timeout
-func (rv32 *RISCV) RunUntilWithTimeout(ctx context.Context, address uint32, timeout time.Duration) error {
+func (rv32 *RISCV) RunUntilWithTimeout(ctx context.Context, address uint64, timeout time.Duration) error {
 	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
@@ -89,7 +298,7 @@ func (rv32 *RISCV) RunUntilWithTimeout(ctx context.Context, address uint32, time
 
 	instructions := 0
 	for rv32.GetPC() != address {
-		err := rv32.RunStep(ctx)
+		err := rv32.run(ctx, address)
 		if err != nil {
 			return err
 		}
@@ -103,84 +312,20 @@ func (rv32 *RISCV) RunUntilWithTimeout(ctx context.Context, address uint32, time
 }
 
 // RunUntil runs the emulation until the specified code address is reached
-func (rv32 *RISCV) RunUntil(ctx context.Context, address uint32) error {
+func (rv32 *RISCV) RunUntil(ctx context.Context, address uint64) error {
 	for rv32.GetPC() != address {
-		err := rv32.RunStep(ctx)
+		err := rv32.run(ctx, address)
 		if err != nil {
 			return err
 		}
 		if _, ok := rv32.breakpoints[rv32.pc]; ok {
 			return fmt.Errorf("breakpoint reached at %08x", rv32.pc)
 		}
-	}
-
-	return nil
-}
-
-// Start starts a goroutine with the RISC-V emulation
-// This starts the CPU as paused, so either Step or Continue should be run afterwards
-func (rv32 *RISCV) Start() {
-	if !rv32.started {
-		rv32.started = true
-		go rv32.Loop()
-	}
-}
-
-// Stops stops the goroutine with the RISC-V emulation
-func (rv32 *RISCV) Stop() {
-	rv32.started = false
-}
-
-// Step makes the RISC-V Goroutine to step a single instruction
-// This does nothing in standalone, and RunStep should be used when no gouroutine has been started
-func (rv32 *RISCV) Step() {
-	rv32.step = true
-	rv32.running = true
-}
-
-// Paused returns if the core is currently paused
-func (rv32 *RISCV) Paused() bool {
-	return !rv32.running
-}
-
-// Loop is the loop used by Start
-// Use manually with care
-func (rv32 *RISCV) Loop() {
-	ctx := context.Background()
-	rv32.Reset()
-
-	for rv32.started {
-		if rv32.running {
-			err := rv32.RunStep(ctx)
-			//log.Infof("%s", Addr2Line(addr))
-			if err != nil {
-				rv32.log.Debugf("(RISCV) Error: %s", err)
-				rv32.running = false
-			}
-
-			if rv32.step {
-				rv32.log.Infof("Paused at %08x", rv32.pc)
-				rv32.running = false
-				rv32.step = false
-			}
-
-			if _, ok := rv32.breakpoints[rv32.pc]; ok {
-				rv32.log.Infof("Breakpoint reached at %08x", rv32.pc)
-				rv32.running = false
-			}
-		} else {
-			time.Sleep(time.Millisecond)
+		if rv32.watchHit {
+			rv32.watchHit = false
+			return fmt.Errorf("watchpoint at %08x reached at %08x", rv32.watchAddress, rv32.pc)
 		}
-		runtime.Gosched()
 	}
-}
-
-// Pause pauses the RISC-V emulation goroutine
-func (rv32 *RISCV) Pause() {
-	rv32.running = false
-}
 
-// Continue resumes the RISC-V emulation goroutine
-func (rv32 *RISCV) Continue() {
-	rv32.running = true
+	return nil
 }
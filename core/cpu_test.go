@@ -3,6 +3,7 @@ package core
 import (
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"strconv"
@@ -11,6 +12,7 @@ import (
 	"time"
 )
 
+// loadmem loads a test program. Programs built with -march=rv32e* (see Makefile) are run with WithRV32E
 func loadmem(file string) []byte {
 	data, err := ioutil.ReadFile(file)
 	if err != nil {
@@ -43,15 +45,37 @@ func loadmem(file string) []byte {
 	return program
 }
 
+// testMemorySize is the size of the RAM mapped at address 0 by createTestCPU
+const testMemorySize = 0x20000
+
+// createTestCPU creates a core with 128 KiB of RAM at address 0 holding the specified program
+// Exceptions are returned as errors (TrapPolicyStop). The RAM is returned so tests can change it without the core
+func createTestCPU(t testing.TB, opts []Option, program ...uint32) (*RISCV, []byte) {
+	cpu := CreateEmulator(nil, opts...)
+	cpu.SetTrapPolicy(TrapPolicyStop)
+	memory := make([]byte, testMemorySize)
+	for i, ins := range program {
+		binary.LittleEndian.PutUint32(memory[i*4:], ins)
+	}
+	if err := cpu.Bus.MapMemory("memory", 0, memory, true); err != nil {
+		t.Fatal(err)
+	}
+	return cpu, memory
+}
+
 func TestCPU_LoadStore(t *testing.T) {
-	cpu := CreateEmulator(nil)
+	testCPULoadStore(t)
+}
+
+func testCPULoadStore(t *testing.T, opts ...Option) {
+	cpu := CreateEmulator(nil, append(opts, WithRV32E())...)
 
 	program := loadmem("../testdata/test_loadstore.mem")
 	padding := make([]byte, 64)
 	program = append(program, padding...) // Allow unaligned access to go beyond read
 	memory := make([]byte, 1024)
 
-	readProgram := func(ctx context.Context, address uint32) (uint32, error) {
+	readProgram := func(ctx context.Context, address uint64, readMask byte) (uint32, error) {
 		var slice []byte
 		if address >= 0x10000 {
 			slice = memory[address-0x10000:]
@@ -64,30 +88,23 @@ func TestCPU_LoadStore(t *testing.T) {
 		return binary.LittleEndian.Uint32(slice), nil
 	}
 
-	writeData := func(ctx context.Context, address, value uint32, writeMask byte) error {
+	writeData := func(ctx context.Context, address uint64, value uint32, writeMask byte) error {
 		slice := memory[address-0x10000:]
 		if len(slice) < 4 {
 			return fmt.Errorf("not enough bytes to write at %08x", address)
 		}
-		current, _ := readProgram(ctx, address)
-		switch writeMask {
-		case 1: // Single byte
-			current &= 0xFFFFFF00
-			current |= value & 0xFF
-		case 3: // Single Short
-			current &= 0xFFFF0000
-			current |= value & 0xFFFF
-		case 15: // Full Word
-			current = value
+		for i := 0; i < 4; i++ {
+			if writeMask&(1<<i) > 0 {
+				slice[i] = byte(value >> (8 * i))
+			}
 		}
-		binary.LittleEndian.PutUint32(slice, current)
 
 		return nil
 	}
 
 	ctx := context.Background()
 
-	if err := cpu.Bus.Map("program", 0, uint32(len(program)), readProgram, writeData); err != nil {
+	if err := cpu.Bus.Map("program", 0, uint64(len(program)), readProgram, writeData); err != nil {
 		t.Fatal(err)
 	}
 	if err := cpu.Bus.Map("memory", 0x10000, 0x10000+1024, readProgram, writeData); err != nil {
@@ -191,18 +208,55 @@ func TestCPU_LoadStore(t *testing.T) {
 
 }
 
+func TestCPU_LoadNegativeOffset(t *testing.T) {
+	testCPULoadNegativeOffset(t)
+}
+
+func testCPULoadNegativeOffset(t *testing.T, opts ...Option) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		ins      uint32
+		expected uint64
+	}{
+		{"lw x1, -4(x2)", 0xffc12083, 0x80C0FFEE},
+		{"lh x1, -2(x2)", 0xffe11083, 0xFFFF80C0},
+		{"lhu x1, -2(x2)", 0xffe15083, 0x80C0},
+		{"lbu x1, -1(x2)", 0xfff14083, 0x80},
+	}
+
+	for _, test := range tests {
+		cpu, _ := createTestCPU(t, opts, test.ins)
+		if err := cpu.Bus.WriteWord(ctx, 0x100, 0x80C0FFEE); err != nil {
+			t.Fatal(err)
+		}
+		cpu.Registers.SetInteger(2, 0x104)
+		if err := cpu.RunStep(ctx); err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if v := cpu.Registers.GetInteger(1); v != test.expected {
+			t.Errorf("%s: Expected X01 to be %08x but got %08x", test.name, test.expected, v)
+		}
+	}
+}
+
 func TestCPU_JALJALR(t *testing.T) {
-	cpu := CreateEmulator(nil)
+	testCPUJALJALR(t)
+}
+
+func testCPUJALJALR(t *testing.T, opts ...Option) {
+	cpu := CreateEmulator(nil, append(opts, WithRV32E())...)
 
 	program := loadmem("../testdata/test_jaljalr.mem")
 
-	readProgram := func(ctx context.Context, address uint32) (uint32, error) {
+	readProgram := func(ctx context.Context, address uint64, readMask byte) (uint32, error) {
 		return binary.LittleEndian.Uint32(program[address:]), nil
 	}
 
 	ctx := context.Background()
 
-	if err := cpu.Bus.Map("program", 0, uint32(len(program)), readProgram, nil); err != nil {
+	if err := cpu.Bus.Map("program", 0, uint64(len(program)), readProgram, nil); err != nil {
 		t.Fatal(err)
 	}
 
@@ -219,17 +273,21 @@ func TestCPU_JALJALR(t *testing.T) {
 }
 
 func TestCPU_LUIAUIPC(t *testing.T) {
-	cpu := CreateEmulator(nil)
+	testCPULUIAUIPC(t)
+}
+
+func testCPULUIAUIPC(t *testing.T, opts ...Option) {
+	cpu := CreateEmulator(nil, append(opts, WithRV32E())...)
 
 	program := loadmem("../testdata/test_luiauipc.mem")
 
-	readProgram := func(ctx context.Context, address uint32) (uint32, error) {
+	readProgram := func(ctx context.Context, address uint64, readMask byte) (uint32, error) {
 		return binary.LittleEndian.Uint32(program[address:]), nil
 	}
 
 	ctx := context.Background()
 
-	if err := cpu.Bus.Map("program", 0, uint32(len(program)), readProgram, nil); err != nil {
+	if err := cpu.Bus.Map("program", 0, uint64(len(program)), readProgram, nil); err != nil {
 		t.Fatal(err)
 	}
 
@@ -246,17 +304,21 @@ func TestCPU_LUIAUIPC(t *testing.T) {
 }
 
 func TestCPU_JMPS(t *testing.T) {
-	cpu := CreateEmulator(nil)
+	testCPUJMPS(t)
+}
+
+func testCPUJMPS(t *testing.T, opts ...Option) {
+	cpu := CreateEmulator(nil, append(opts, WithRV32E())...)
 
 	program := loadmem("../testdata/test_jmps.mem")
 
-	readProgram := func(ctx context.Context, address uint32) (uint32, error) {
+	readProgram := func(ctx context.Context, address uint64, readMask byte) (uint32, error) {
 		return binary.LittleEndian.Uint32(program[address:]), nil
 	}
 
 	ctx := context.Background()
 
-	if err := cpu.Bus.Map("program", 0, uint32(len(program)), readProgram, nil); err != nil {
+	if err := cpu.Bus.Map("program", 0, uint64(len(program)), readProgram, nil); err != nil {
 		t.Fatal(err)
 	}
 
@@ -287,17 +349,21 @@ func TestCPU_JMPS(t *testing.T) {
 }
 
 func TestCPU_ALU(t *testing.T) {
-	cpu := CreateEmulator(nil)
+	testCPUALU(t)
+}
+
+func testCPUALU(t *testing.T, opts ...Option) {
+	cpu := CreateEmulator(nil, append(opts, WithRV32E())...)
 
 	program := loadmem("../testdata/test_alu.mem")
 
-	readProgram := func(ctx context.Context, address uint32) (uint32, error) {
+	readProgram := func(ctx context.Context, address uint64, readMask byte) (uint32, error) {
 		return binary.LittleEndian.Uint32(program[address:]), nil
 	}
 
 	ctx := context.Background()
 
-	if err := cpu.Bus.Map("program", 0, uint32(len(program)), readProgram, nil); err != nil {
+	if err := cpu.Bus.Map("program", 0, uint64(len(program)), readProgram, nil); err != nil {
 		t.Fatal(err)
 	}
 
@@ -554,3 +620,978 @@ func TestCPU_ALU(t *testing.T) {
 	}
 
 }
+
+func TestCPU_MulDiv(t *testing.T) {
+	testCPUMulDiv(t)
+}
+
+func testCPUMulDiv(t *testing.T, opts ...Option) {
+	cpu := CreateEmulator(nil, append(opts, WithRV32E())...)
+
+	program := loadmem("../testdata/test_muldiv.mem")
+
+	readProgram := func(ctx context.Context, address uint64, readMask byte) (uint32, error) {
+		return binary.LittleEndian.Uint32(program[address:]), nil
+	}
+
+	ctx := context.Background()
+
+	if err := cpu.Bus.Map("program", 0, uint64(len(program)), readProgram, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	checks := []struct {
+		name     string
+		address  uint64
+		expected map[int]uint64
+	}{
+		{"MUL", 0x20, map[int]uint64{
+			15: 0x000F4240,
+			14: 0xFFFFF448,
+			13: 0x80000000,
+			12: 0x00000009,
+		}},
+		{"MULH/MULHSU/MULHU", 0x38, map[int]uint64{
+			15: 0x40000000,
+			14: 0xFFFFFFFF,
+			13: 0xFFFFFFFD,
+			12: 0x000003E7,
+			11: 0xFFFFFFFE,
+			10: 0x00000000,
+		}},
+		{"DIV/DIVU", 0x50, map[int]uint64{
+			15: 0xFFFFFEB3,
+			14: 0xFFFFFFFF,
+			13: 0x80000000,
+			12: 0x00418937,
+			11: 0xFFFFFFFF,
+			10: 0x00000000,
+		}},
+		{"REM/REMU", 0x68, map[int]uint64{
+			15: 0x00000001,
+			14: 0xFFFFFFFD,
+			13: 0x000003E8,
+			12: 0x00000000,
+			11: 0x00000127,
+			10: 0xFFFFFFFD,
+		}},
+	}
+
+	for _, c := range checks {
+		if err := cpu.RunUntilWithTimeout(ctx, c.address, time.Second*2); err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		for reg, expected := range c.expected {
+			if cpu.Registers.integers[reg] != expected {
+				t.Errorf("%s: Expected X%02d to be %08x but got %08x", c.name, reg, expected, cpu.Registers.integers[reg])
+			}
+		}
+	}
+}
+
+func TestCPU_CSR(t *testing.T) {
+	testCPUCSR(t)
+}
+
+func testCPUCSR(t *testing.T, opts ...Option) {
+	cpu := CreateEmulator(nil, opts...)
+
+	program := loadmem("../testdata/test_csr.mem")
+
+	readProgram := func(ctx context.Context, address uint64, readMask byte) (uint32, error) {
+		return binary.LittleEndian.Uint32(program[address:]), nil
+	}
+
+	ctx := context.Background()
+
+	if err := cpu.Bus.Map("program", 0, uint64(len(program)), readProgram, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cpu.RunUntilWithTimeout(ctx, 0x44, time.Second*2); err != nil {
+		t.Fatalf("CSR: %s", err)
+	}
+
+	expected := map[int]uint64{
+		1:  0x00000000,
+		2:  0x4000112D,
+		4:  0x12345678,
+		6:  0x12345678,
+		7:  0x123456F8,
+		8:  0x12345608,
+		9:  0x0000001F,
+		10: 0x0000001F,
+		11: 0x0000001C,
+		12: 0x4000112D,
+		13: 15,
+	}
+
+	for reg, value := range expected {
+		if cpu.Registers.integers[reg] != value {
+			t.Errorf("CSR: Expected X%02d to be %08x but got %08x", reg, value, cpu.Registers.integers[reg])
+		}
+	}
+
+	// Write to read-only mhartid
+	if err := cpu.RunStep(ctx); err != nil {
+		t.Fatalf("CSR: %s", err)
+	}
+	if cause := cpu.CSR.Get(CSRMCause); cause != ExceptionIllegalInstruction {
+		t.Errorf("CSR: Expected write to read-only mhartid to raise cause %d but got %d", ExceptionIllegalInstruction, cause)
+	}
+	if epc := cpu.CSR.Get(CSRMEPC); epc != 0x44 {
+		t.Errorf("CSR: Expected mepc to be %08x but got %08x", 0x44, epc)
+	}
+}
+
+func TestCPU_Trap(t *testing.T) {
+	testCPUTrap(t)
+}
+
+func testCPUTrap(t *testing.T, opts ...Option) {
+	cpu := CreateEmulator(nil, append(opts, WithRV32E())...)
+
+	program := loadmem("../testdata/test_trap.mem")
+
+	readProgram := func(ctx context.Context, address uint64, readMask byte) (uint32, error) {
+		return binary.LittleEndian.Uint32(program[address:]), nil
+	}
+
+	ctx := context.Background()
+
+	if err := cpu.Bus.Map("program", 0, uint64(len(program)), readProgram, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cpu.RunUntilWithTimeout(ctx, 0x4C, time.Second*2); err != nil {
+		t.Fatalf("Trap: %s", err)
+	}
+
+	expected := map[int]uint64{
+		4:  0x00003880,
+		5:  ExceptionEnvironmentCallFromMMode,
+		6:  ExceptionBreakpoint,
+		7:  ExceptionIllegalInstruction,
+		8:  ExceptionLoadAccessFault,
+		9:  0x00010000,
+		10: ExceptionStoreAccessFault,
+		11: 0x00010004,
+		12: 0x00003888,
+	}
+
+	for reg, value := range expected {
+		if cpu.Registers.integers[reg] != value {
+			t.Errorf("Trap: Expected X%02d to be %08x but got %08x", reg, value, cpu.Registers.integers[reg])
+		}
+	}
+}
+
+func TestCPU_TrapPolicyStop(t *testing.T) {
+	testCPUTrapPolicyStop(t)
+}
+
+func testCPUTrapPolicyStop(t *testing.T, opts ...Option) {
+	cpu := CreateEmulator(nil, append(opts, WithRV32E())...)
+	cpu.SetTrapPolicy(TrapPolicyStop)
+
+	program := loadmem("../testdata/test_trap.mem")
+
+	readProgram := func(ctx context.Context, address uint64, readMask byte) (uint32, error) {
+		return binary.LittleEndian.Uint32(program[address:]), nil
+	}
+
+	ctx := context.Background()
+
+	if err := cpu.Bus.Map("program", 0, uint64(len(program)), readProgram, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	err := cpu.RunUntilWithTimeout(ctx, 0x4C, time.Second*2)
+	if err == nil {
+		t.Fatalf("Trap Stop: Expected ecall to stop the execution")
+	}
+
+	var ex Exception
+	if !errors.As(err, &ex) || ex.Cause != ExceptionEnvironmentCallFromMMode {
+		t.Errorf("Trap Stop: Expected ecall exception but got %v", err)
+	}
+	if cpu.GetPC() != 0x10 {
+		t.Errorf("Trap Stop: Expected PC to be %08x but got %08x", 0x10, cpu.GetPC())
+	}
+}
+
+func TestCPU_EPCAlignment(t *testing.T) {
+	ctx := context.Background()
+	mret := []uint32{
+		0x10200293, // li t0, 0x102
+		0x34129073, // csrw mepc, t0
+		0x34102373, // csrr t1, mepc
+		0x30200073, // mret
+	}
+	sret := []uint32{
+		0x10200293, // li t0, 0x102
+		0x14129073, // csrw sepc, t0
+		0x14102373, // csrr t1, sepc
+		0x10200073, // sret
+	}
+	tests := []struct {
+		isa      string
+		program  []uint32
+		expected uint64
+	}{
+		{"rv32i_zicsr", mret, 0x100}, // mepc[1] reads as zero without compressed instructions
+		{"rv32ic_zicsr", mret, 0x102},
+		{"rv32isu_zicsr", sret, 0x100},
+		{"rv32icsu_zicsr", sret, 0x102},
+	}
+
+	for _, test := range tests {
+		cpu, _ := createTestCPU(t, []Option{WithISA(test.isa)}, test.program...)
+		for i := 0; i < 4; i++ {
+			if err := cpu.RunStep(ctx); err != nil {
+				t.Fatalf("%s: %s", test.isa, err)
+			}
+		}
+		if v := cpu.Registers.GetInteger(6); v != test.expected {
+			t.Errorf("%s: Expected the epc to be %08x but got %08x", test.isa, test.expected, v)
+		}
+		if cpu.GetPC() != test.expected {
+			t.Errorf("%s: Expected PC to be %08x but got %08x", test.isa, test.expected, cpu.GetPC())
+		}
+	}
+}
+
+func TestCPU_Interrupt(t *testing.T) {
+	testCPUInterrupt(t)
+}
+
+func testCPUInterrupt(t *testing.T, opts ...Option) {
+	cpu := CreateEmulator(nil, append(opts, WithRV32E())...)
+
+	program := loadmem("../testdata/test_interrupt.mem")
+
+	readProgram := func(ctx context.Context, address uint64, readMask byte) (uint32, error) {
+		return binary.LittleEndian.Uint32(program[address:]), nil
+	}
+
+	ctx := context.Background()
+
+	if err := cpu.Bus.Map("program", 0, uint64(len(program)), readProgram, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cpu.RunUntilWithTimeout(ctx, 0x14, time.Second*2); err != nil {
+		t.Fatalf("Interrupt: %s", err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := cpu.RunStep(ctx); err != nil {
+			t.Fatalf("Interrupt: %s", err)
+		}
+	}
+
+	// Machine Timer Interrupt
+	cpu.SetInterruptPending(InterruptMachineTimer, true)
+	if err := cpu.RunUntilWithTimeout(ctx, 0xB8, time.Second*2); err != nil {
+		t.Fatalf("Interrupt: %s", err)
+	}
+	cpu.SetInterruptPending(InterruptMachineTimer, false)
+
+	if cpu.Registers.integers[10] != 0x80000007 {
+		t.Errorf("Interrupt: Expected X%02d to be %08x but got %08x", 10, 0x80000007, cpu.Registers.integers[10])
+	}
+	if cpu.Registers.integers[11] != 0x14 && cpu.Registers.integers[11] != 0x18 {
+		t.Errorf("Interrupt: Expected X%02d to be inside the loop but got %08x", 11, cpu.Registers.integers[11])
+	}
+	if cpu.Registers.integers[12] != 0x3880 {
+		t.Errorf("Interrupt: Expected X%02d to be %08x but got %08x", 12, 0x3880, cpu.Registers.integers[12])
+	}
+
+	// Interrupts are disabled inside the handler
+	cpu.SetInterruptPending(InterruptMachineSoftware, true)
+	for i := 0; i < 10; i++ {
+		if err := cpu.RunStep(ctx); err != nil {
+			t.Fatalf("Interrupt: %s", err)
+		}
+	}
+	if cpu.GetPC() != 0xB8 {
+		t.Errorf("Interrupt: Expected PC to be %08x but got %08x", 0xB8, cpu.GetPC())
+	}
+
+	// Software interrupt has priority over timer interrupt
+	cpu.Reset()
+	if err := cpu.RunUntilWithTimeout(ctx, 0x14, time.Second*2); err != nil {
+		t.Fatalf("Interrupt: %s", err)
+	}
+	cpu.SetInterruptPending(InterruptMachineSoftware, true)
+	cpu.SetInterruptPending(InterruptMachineTimer, true)
+	if err := cpu.RunUntilWithTimeout(ctx, 0xA8, time.Second*2); err != nil {
+		t.Fatalf("Interrupt: %s", err)
+	}
+	if cpu.Registers.integers[13] != 0x80000003 {
+		t.Errorf("Interrupt: Expected X%02d to be %08x but got %08x", 13, 0x80000003, cpu.Registers.integers[13])
+	}
+}
+
+func TestCPU_WFI(t *testing.T) {
+	testCPUWFI(t)
+}
+
+func testCPUWFI(t *testing.T, opts ...Option) {
+	cpu := CreateEmulator(nil, opts...)
+	cpu.SetTrapPolicy(TrapPolicyStop)
+	program := make([]byte, 16)
+	binary.LittleEndian.PutUint32(program[0:], 0x10500073)  // wfi
+	binary.LittleEndian.PutUint32(program[4:], 0x00000013)  // nop
+	binary.LittleEndian.PutUint32(program[8:], 0x10500073)  // wfi
+	binary.LittleEndian.PutUint32(program[12:], 0x00000013) // nop
+
+	readProgram := func(ctx context.Context, address uint64, readMask byte) (uint32, error) {
+		return binary.LittleEndian.Uint32(program[address:]), nil
+	}
+	if err := cpu.Bus.Map("program", 0, uint64(len(program)), readProgram, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	cpu.CSR.Set(CSRMIE, 1<<InterruptMachineTimer) // Enabled in mie, but not globally in mstatus
+
+	// Without wakeup sources the core stays suspended until the interrupt is pending
+	for i := 0; i < 10; i++ {
+		if err := cpu.RunStep(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !cpu.Waiting() || cpu.GetPC() != 4 || cpu.Instret() != 1 {
+		t.Errorf("Expected to be waiting at 00000004 after 1 instruction but got %08x after %d", cpu.GetPC(), cpu.Instret())
+	}
+	cpu.SetInterruptPending(InterruptMachineTimer, true)
+	if err := cpu.RunStep(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if cpu.Waiting() || cpu.GetPC() != 8 {
+		t.Errorf("Expected to resume and run the nop but got PC = %08x", cpu.GetPC())
+	}
+	cpu.SetInterruptPending(InterruptMachineTimer, false)
+
+	// With a wakeup source the idle cycles are skipped
+	const event = 1_000_000
+	cpu.AddWakeupSource(func() (uint64, bool) { return event, true })
+	cpu.AddTickHandler(func(cycle uint64) {
+		cpu.SetInterruptPending(InterruptMachineTimer, cycle >= event)
+	})
+	for cpu.GetPC() != 16 && cpu.Cycles() <= event {
+		if err := cpu.RunStep(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cpu.GetPC() != 16 || cpu.Cycles() != event {
+		t.Errorf("Expected to resume at cycle %d but got PC = %08x at cycle %d", event, cpu.GetPC(), cpu.Cycles())
+	}
+}
+
+func TestCPU_Atomic(t *testing.T) {
+	testCPUAtomic(t)
+}
+
+func testCPUAtomic(t *testing.T, opts ...Option) {
+	cpu := CreateEmulator(nil, append(opts, WithRV32E())...)
+
+	program := loadmem("../testdata/test_atomic.mem")
+	memory := make([]byte, 1024)
+
+	readProgram := func(ctx context.Context, address uint64, readMask byte) (uint32, error) {
+		return binary.LittleEndian.Uint32(program[address:]), nil
+	}
+
+	ctx := context.Background()
+
+	if err := cpu.Bus.Map("program", 0, uint64(len(program)), readProgram, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := cpu.Bus.MapMemory("memory", 0x10000, memory, true); err != nil {
+		t.Fatal(err)
+	}
+
+	checks := []struct {
+		name     string
+		address  uint64
+		expected map[int]uint64
+	}{
+		{"AMO", 0x50, map[int]uint64{
+			4:  0x00000064,
+			5:  0x00000069,
+			6:  0x00000066,
+			7:  0x00000166,
+			8:  0x00000060,
+			9:  0xFFFFFFFF,
+			10: 0xFFFFFFFF,
+			11: 0x00000005,
+			12: 0x00000005,
+			13: 0xFFFFFFFF,
+		}},
+		{"LR/SC", 0x64, map[int]uint64{
+			13: 0x0000002A,
+			14: 0x00000001,
+			15: 0x00000000,
+		}},
+		{"LR Reserve", 0x6C, map[int]uint64{
+			14: 0x0000002A,
+		}},
+	}
+
+	for _, c := range checks {
+		if err := cpu.RunUntilWithTimeout(ctx, c.address, time.Second*2); err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		for reg, expected := range c.expected {
+			if cpu.Registers.integers[reg] != expected {
+				t.Errorf("%s: Expected X%02d to be %08x but got %08x", c.name, reg, expected, cpu.Registers.integers[reg])
+			}
+		}
+	}
+
+	// Another bus master writes to the reserved address
+	if err := cpu.Bus.WriteWord(ctx, 0x10000, 0xCAFE); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cpu.RunUntilWithTimeout(ctx, 0x74, time.Second*2); err != nil {
+		t.Fatalf("SC Invalidated: %s", err)
+	}
+	if cpu.Registers.integers[15] != 1 {
+		t.Errorf("SC Invalidated: Expected X%02d to be %08x but got %08x", 15, 1, cpu.Registers.integers[15])
+	}
+	if v := binary.LittleEndian.Uint32(memory); v != 0xCAFE {
+		t.Errorf("SC Invalidated: Expected memory to be %08x but got %08x", 0xCAFE, v)
+	}
+}
+
+func TestCPU_Float(t *testing.T) {
+	testCPUFloat(t)
+}
+
+func testCPUFloat(t *testing.T, opts ...Option) {
+	cpu := CreateEmulator(nil, opts...)
+
+	program := loadmem("../testdata/test_float.mem")
+	memory := make([]byte, 1024)
+
+	readProgram := func(ctx context.Context, address uint64, readMask byte) (uint32, error) {
+		return binary.LittleEndian.Uint32(program[address:]), nil
+	}
+
+	ctx := context.Background()
+
+	if err := cpu.Bus.Map("program", 0, uint64(len(program)), readProgram, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := cpu.Bus.MapMemory("memory", 0x10000, memory, true); err != nil {
+		t.Fatal(err)
+	}
+
+	checks := []struct {
+		name     string
+		address  uint64
+		expected map[int]uint64
+	}{
+		{"Rounding", 0x44, map[int]uint64{
+			3: 0x3EAAAAAB,
+			4: 0x3EAAAAAA,
+			5: 0x00000001,
+			6: 0x3EAAAAAB,
+			7: 0x00000061,
+		}},
+		{"FMA", 0x58, map[int]uint64{
+			8:  0x0000000A,
+			9:  0x00000001,
+			10: 0x00000000,
+			11: 0x00000040,
+		}},
+		{"Double", 0x6C, map[int]uint64{
+			12: 0x3ADA5B53,
+			13: 0x40094C58,
+		}},
+		{"NaN-boxing", 0x8C, map[int]uint64{
+			2:  0xFFC00000,
+			3:  0x404A62C2,
+			4:  0xFFFFFFFF,
+			5:  0x00000011,
+			14: 0x3ADA5B53,
+			15: 0x00000200,
+		}},
+	}
+
+	for _, c := range checks {
+		if err := cpu.RunUntilWithTimeout(ctx, c.address, time.Second*2); err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		for reg, expected := range c.expected {
+			if cpu.Registers.integers[reg] != expected {
+				t.Errorf("%s: Expected X%02d to be %08x but got %08x", c.name, reg, expected, cpu.Registers.integers[reg])
+			}
+		}
+	}
+
+	if v := cpu.Registers.GetDouble(7); v != 10 {
+		t.Errorf("Float: Expected F07 to be %f but got %f", 10.0, v)
+	}
+	if cpu.CSR.Get(CSRMStatus)&MStatusSD == 0 {
+		t.Errorf("Float: Expected mstatus.SD to be set after writing float registers")
+	}
+
+	// Floating point instructions are illegal when mstatus.FS is Off
+	cpu.CSR.Set(CSRMStatus, cpu.CSR.Get(CSRMStatus)&^MStatusFS)
+	cpu.SetPC(0x44)
+	cpu.SetTrapPolicy(TrapPolicyStop)
+	var ex Exception
+	if err := cpu.RunStep(ctx); !errors.As(err, &ex) || ex.Cause != ExceptionIllegalInstruction {
+		t.Errorf("Float: Expected illegal instruction with FS = Off but got %v", err)
+	}
+}
+
+func TestCPU_Compressed(t *testing.T) {
+	testCPUCompressed(t)
+}
+
+func testCPUCompressed(t *testing.T, opts ...Option) {
+	cpu := CreateEmulator(nil, append(opts, WithRV32E())...)
+
+	program := loadmem("../testdata/test_compressed.mem")
+
+	readProgram := func(ctx context.Context, address uint64, readMask byte) (uint32, error) {
+		return binary.LittleEndian.Uint32(program[address:]), nil
+	}
+
+	ctx := context.Background()
+
+	if err := cpu.Bus.Map("program", 0, uint64(len(program)), readProgram, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cpu.RunUntilWithTimeout(ctx, 0x24, time.Second*2); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[int]uint64{
+		1:  0x0000001E, // c.jalr link
+		2:  0x0000001F,
+		3:  0x000000F0,
+		4:  0x0000000E, // jal link from a 16 bit aligned address
+		5:  0x0000000A, // c.jal link
+		6:  0x00000026,
+		8:  0x0000000E,
+		9:  0x00000FF9,
+		10: 0x00000007,
+		11: 0x00000000,
+	}
+
+	for reg, value := range expected {
+		if cpu.Registers.integers[reg] != value {
+			t.Errorf("Compressed: Expected X%02d to be %08x but got %08x", reg, value, cpu.Registers.integers[reg])
+		}
+	}
+
+	// The padding after the program is an all zeros compressed instruction, which is illegal
+	cpu.SetTrapPolicy(TrapPolicyStop)
+	cpu.SetPC(0x32)
+	var ex Exception
+	if err := cpu.RunStep(ctx); !errors.As(err, &ex) || ex.Cause != ExceptionIllegalInstruction {
+		t.Errorf("Compressed: Expected illegal instruction at %08x but got %v", 0x32, err)
+	}
+	if cpu.GetPC() != 0x32 {
+		t.Errorf("Compressed: Expected PC to be %08x but got %08x", 0x32, cpu.GetPC())
+	}
+}
+
+func TestCPU_RV64(t *testing.T) {
+	testCPURV64(t)
+}
+
+func testCPURV64(t *testing.T, opts ...Option) {
+	cpu := CreateEmulator(nil, append(opts, WithXLEN(64))...)
+
+	program := loadmem("../testdata/test_rv64.mem")
+	memory := make([]byte, 1024)
+
+	const memoryBase = 0x1_0000_0000 // Above 4 GiB
+
+	readProgram := func(ctx context.Context, address uint64, readMask byte) (uint32, error) {
+		return binary.LittleEndian.Uint32(program[address:]), nil
+	}
+
+	ctx := context.Background()
+
+	if err := cpu.Bus.Map("program", 0, uint64(len(program)), readProgram, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := cpu.Bus.MapMemory("memory", memoryBase, memory, true); err != nil {
+		t.Fatal(err)
+	}
+
+	checks := []struct {
+		name     string
+		address  uint64
+		expected map[int]uint64
+	}{
+		{"LOAD/STORE", 0x1E, map[int]uint64{
+			1: 0x00000001_00000000,
+			2: 0xFFFFFFFF_80000000,
+			4: 0x00000000_FFFFFFFF,
+			5: 0xFFFFFFFF_FFFFFFFF,
+			6: 0x00000000_FFFFFFFF,
+		}},
+		{"*W", 0x44, map[int]uint64{
+			7:  0x00000000_00000000,
+			8:  0x00000000_00000000,
+			9:  0xFFFFFFFF_80000000,
+			10: 0xFFFFFFFF_80000000,
+			11: 0x00000000_08000000,
+			12: 0xFFFFFFFF_F8000000,
+			13: 0x7FFFFFFF_FFFFFFFF,
+			14: 0xFFFFFF00_00000000,
+			15: 0xFFFFFFFF_80000000,
+		}},
+		{"RV64M", 0x64, map[int]uint64{
+			4:  0x00000000_00000000,
+			5:  0xFFFFFFFF_FFFFFFFE,
+			6:  0x00000000_00000001,
+			7:  0xFFFFFFFF_80000000,
+			8:  0x00000000_0FFFFFFF,
+			10: 0xFFFFFFFF_F8000000,
+			11: 0xFFFFFFFF_FFFFFFFF,
+		}},
+		{"RV64A", 0x80, map[int]uint64{
+			11: 0x00000000_FFFFFFFF,
+			12: 0x00000001_00000004,
+			13: 0x00000000_00000004,
+			14: 0xFFFFFFFF_80000000,
+			15: 0x00000000_00000000,
+		}},
+		{"RV64F/D", 0x9A, map[int]uint64{
+			4: 0xFFFFFFFF_80000000,
+			5: 0xC1E00000_00000000,
+			6: 0x00000000_40A00000,
+			7: 0x00000000_00000005,
+		}},
+		{"RV64C", 0xA8, map[int]uint64{
+			8:  0x00000000_00000000,
+			9:  0xFFFFFFFF_80000000,
+			11: 0xFFFFFFFF_FFFFFFFB,
+		}},
+	}
+
+	for _, c := range checks {
+		if err := cpu.RunUntilWithTimeout(ctx, c.address, time.Second*2); err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		for reg, expected := range c.expected {
+			if cpu.Registers.integers[reg] != expected {
+				t.Errorf("%s: Expected X%02d to be %016x but got %016x", c.name, reg, expected, cpu.Registers.integers[reg])
+			}
+		}
+	}
+
+	if misa := cpu.CSR.Get(CSRMISA); misa>>62 != 2 {
+		t.Errorf("RV64: Expected misa.MXL to be 2 but got %d", misa>>62)
+	}
+	if _, err := cpu.CSR.Read(CSRCycleH, PrivilegeMachine); err == nil {
+		t.Errorf("RV64: Expected cycleh to not exist")
+	}
+}
+
+func TestCPU_RV32E(t *testing.T) {
+	testCPURV32E(t)
+}
+
+func testCPURV32E(t *testing.T, opts ...Option) {
+	cpu := CreateEmulator(nil, append(opts, WithRV32E())...)
+	cpu.SetTrapPolicy(TrapPolicyStop)
+
+	program := make([]byte, 4)
+	readProgram := func(ctx context.Context, address uint64, readMask byte) (uint32, error) {
+		return binary.LittleEndian.Uint32(program[address:]), nil
+	}
+
+	ctx := context.Background()
+
+	if err := cpu.Bus.Map("program", 0, uint64(len(program)), readProgram, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if misa := cpu.CSR.Get(CSRMISA); misa&MISAExtE == 0 || misa&MISAExtI != 0 {
+		t.Errorf("RV32E: Expected misa to have E and not I but got %08x", misa)
+	}
+
+	tests := []struct {
+		name    string
+		ins     uint32
+		illegal bool
+	}{
+		{"addi x16, x0, 1", 0x00100813, true},
+		{"add x1, x2, x17", 0x011100b3, true},
+		{"sw x31, 0(x1)", 0x01f0a023, true},
+		{"lui x20, 1", 0x00001a37, true},
+		{"csrrw x16, mscratch, x0", 0x34001873, true},
+		{"fmv.x.w x16, f0", 0xe0000853, true},
+		{"fcvt.s.w f1, x18", 0xd00970d3, true},
+		{"c.mv x16, x1", 0x8806, true},
+		{"csrrwi x1, mscratch, 31", 0x340fd0f3, false},
+		{"fadd.s f16, f17, f18", 0x0128f853, false},
+		{"flw f20, 0(x1)", 0x0000aa07, false},
+	}
+
+	for _, test := range tests {
+		binary.LittleEndian.PutUint32(program, test.ins)
+		cpu.FlushInstructionCache() // The program is changed without going through the core
+		cpu.SetPC(0)
+		err := cpu.RunStep(ctx)
+		var ex Exception
+		illegal := errors.As(err, &ex) && ex.Cause == ExceptionIllegalInstruction
+		if illegal != test.illegal {
+			t.Errorf("RV32E: Expected %s illegal to be %t but got %v", test.name, test.illegal, err)
+		}
+		if illegal && ex.Value != uint64(test.ins) {
+			t.Errorf("RV32E: Expected mtval for %s to be %08x but got %08x", test.name, test.ins, ex.Value)
+		}
+	}
+}
+
+func TestCPU_Privilege(t *testing.T) {
+	testCPUPrivilege(t)
+}
+
+func testCPUPrivilege(t *testing.T, opts ...Option) {
+	cpu := CreateEmulator(nil, append(opts, WithISA("rv32imsu"))...)
+
+	program := loadmem("../testdata/test_privilege.mem")
+
+	readProgram := func(ctx context.Context, address uint64, readMask byte) (uint32, error) {
+		return binary.LittleEndian.Uint32(program[address:]), nil
+	}
+
+	ctx := context.Background()
+
+	if err := cpu.Bus.Map("program", 0, uint64(len(program)), readProgram, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cpu.RunUntilWithTimeout(ctx, 0xE0, time.Second*2); err != nil {
+		t.Fatalf("Privilege: %s", err)
+	}
+
+	expected := map[int]uint64{
+		3:  ExceptionIllegalInstruction,
+		4:  0x00000800,
+		5:  ExceptionEnvironmentCallFromSMode,
+		8:  ExceptionIllegalInstruction,
+		9:  0x00000022,
+		10: 0x80000000 | InterruptSupervisorSoftware,
+		11: ExceptionEnvironmentCallFromUMode,
+		12: 0x00000020,
+		13: ExceptionIllegalInstruction,
+	}
+
+	for reg, value := range expected {
+		if cpu.Registers.integers[reg] != value {
+			t.Errorf("Privilege: Expected X%02d to be %08x but got %08x", reg, value, cpu.Registers.integers[reg])
+		}
+	}
+
+	if cpu.priv != PrivilegeUser {
+		t.Errorf("Privilege: Expected to end in user mode but got %d", cpu.priv)
+	}
+
+	// Writes to unsupported MPP values are ignored
+	cpu.CSR.Set(CSRMStatus, 2<<mstatusMPPShift)
+	if cpu.CSR.Get(CSRMStatus)&MStatusMPP != 0 {
+		t.Errorf("Privilege: Expected mstatus.MPP to keep U but got %08x", cpu.CSR.Get(CSRMStatus))
+	}
+}
+
+func TestCPU_BitManip(t *testing.T) {
+	testCPUBitManip(t)
+}
+
+func testCPUBitManip(t *testing.T, opts ...Option) {
+	cpu := CreateEmulator(nil, append(opts, WithISA("rv32im_zba_zbb_zbs"))...)
+
+	program := loadmem("../testdata/test_bitmanip.mem")
+
+	readProgram := func(ctx context.Context, address uint64, readMask byte) (uint32, error) {
+		return binary.LittleEndian.Uint32(program[address:]), nil
+	}
+
+	ctx := context.Background()
+
+	if err := cpu.Bus.Map("program", 0, uint64(len(program)), readProgram, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cpu.RunUntilWithTimeout(ctx, 0x88, time.Second*2); err != nil {
+		t.Fatalf("BitManip: %s", err)
+	}
+
+	expected := map[int]uint64{
+		4:  0x00F0FF06,
+		5:  0x00F0FF0C,
+		6:  0x00F0FF18,
+		7:  0x00F0FF00,
+		8:  0xFF0F00FF,
+		9:  0x7F0F00FE,
+		10: 8,
+		11: 8,
+		12: 12,
+		14: 0xFFFFFF80,
+		15: 0x00000001,
+		16: 0x00000001,
+		17: 3,
+		18: 0x80000001,
+		19: 0x80000001,
+		20: 3,
+		21: 0x0000000C,
+		22: 0xF0000F0F,
+		23: 0x00FFF000,
+		24: 0xFF0000FF,
+		25: 0x00F0FF08,
+		26: 0x00F0FE00,
+		27: 0x00000001,
+		28: 0,
+		29: 1,
+		31: 0xFFFFFFFE,
+	}
+
+	for reg, value := range expected {
+		if cpu.Registers.integers[reg] != value {
+			t.Errorf("BitManip: Expected X%02d to be %08x but got %08x", reg, value, cpu.Registers.integers[reg])
+		}
+	}
+}
+
+func TestCPU_BitManipRV64(t *testing.T) {
+	testCPUBitManipRV64(t)
+}
+
+func testCPUBitManipRV64(t *testing.T, opts ...Option) {
+	cpu := CreateEmulator(nil, append(opts, WithISA("rv64im_zba_zbb_zbs"))...)
+	cpu.SetTrapPolicy(TrapPolicyStop)
+
+	program := make([]byte, 4)
+	readProgram := func(ctx context.Context, address uint64, readMask byte) (uint32, error) {
+		return binary.LittleEndian.Uint32(program[address:]), nil
+	}
+
+	ctx := context.Background()
+
+	if err := cpu.Bus.Map("program", 0, uint64(len(program)), readProgram, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		ins      uint32
+		expected uint64
+	}{
+		{"add.uw x1, x2, x3", 0x083100bb, 0x80000014},
+		{"sh1add.uw x1, x2, x3", 0x203120bb, 0x1_00000024},
+		{"slli.uw x1, x2, 4", 0x0841109b, 0x8_00000100},
+		{"zext.h x1, x2", 0x080140bb, 0x0010},
+		{"clzw x1, x2", 0x6001109b, 0},
+		{"ctzw x1, x2", 0x6011109b, 4},
+		{"cpopw x1, x2", 0x6021109b, 2},
+		{"rolw x1, x2, x3", 0x603110bb, 0x108},
+		{"rorw x1, x2, x3", 0x603150bb, 0x08000001},
+		{"roriw x1, x2, 4", 0x6041509b, 0x08000001},
+		{"rev8 x1, x2", 0x6b815093, 0x10000080_FFFFFFFF},
+		{"cpop x1, x2", 0x60211093, 34},
+	}
+
+	for _, test := range tests {
+		cpu.SetPC(0)
+		cpu.Registers.SetInteger(2, 0xFFFFFFFF_80000010)
+		cpu.Registers.SetInteger(3, 4)
+		binary.LittleEndian.PutUint32(program, test.ins)
+		cpu.FlushInstructionCache() // The program is changed without going through the core
+		if err := cpu.RunStep(ctx); err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if v := cpu.Registers.GetInteger(1); v != test.expected {
+			t.Errorf("%s: Expected X01 to be %016x but got %016x", test.name, test.expected, v)
+		}
+	}
+}
+
+func TestCPU_ISA(t *testing.T) {
+	program := make([]byte, 4)
+	readProgram := func(ctx context.Context, address uint64, readMask byte) (uint32, error) {
+		return binary.LittleEndian.Uint32(program[address:]), nil
+	}
+
+	ctx := context.Background()
+
+	tests := []struct {
+		isa     string
+		name    string
+		ins     uint32
+		illegal bool
+	}{
+		{"rv32i", "mul x1, x2, x3", 0x023100b3, true},
+		{"rv32im", "mul x1, x2, x3", 0x023100b3, false},
+		{"rv32i", "amoadd.w x1, x2, (x3)", 0x0021a0af, true},
+		{"rv32ia", "amoadd.w x1, x2, (x3)", 0x0021a0af, false},
+		{"rv32i", "csrrs x1, mscratch, x0", 0x340020f3, true},
+		{"rv32i_zicsr", "csrrs x1, mscratch, x0", 0x340020f3, false},
+		{"rv32i_zicsr", "csrrs x1, fcsr, x0", 0x003020f3, true},
+		{"rv32i", "fadd.s f1, f2, f3", 0x003170d3, true},
+		{"rv32if", "fadd.s f1, f2, f3", 0x003170d3, false},
+		{"rv32if", "fadd.d f1, f2, f3", 0x023170d3, true},
+		{"rv32if", "fld f1, 0(x0)", 0x00003087, true},
+		{"rv32ifd", "fadd.d f1, f2, f3", 0x023170d3, false},
+		{"rv32i", "c.nop", 0x00000001, true},
+		{"rv32ic", "c.nop", 0x00000001, false},
+		{"rv64i", "mulw x1, x2, x3", 0x023100bb, true},
+		{"rv64im", "mulw x1, x2, x3", 0x023100bb, false},
+		{"rv32i_zicsr", "sret", 0x10200073, true},
+		{"rv32isu", "sret", 0x10200073, false},
+		{"rv32i_zicsr", "csrrs x1, sstatus, x0", 0x100020f3, true},
+		{"rv32isu", "csrrs x1, sstatus, x0", 0x100020f3, false},
+		{"rv32i_zicsr", "sfence.vma", 0x12000073, true},
+		{"rv32isu", "sfence.vma", 0x12000073, false},
+		{"rv32i", "sh1add x1, x2, x3", 0x203120b3, true},
+		{"rv32i_zba", "sh1add x1, x2, x3", 0x203120b3, false},
+		{"rv32i", "clz x1, x2", 0x60011093, true},
+		{"rv32i_zbb", "clz x1, x2", 0x60011093, false},
+		{"rv32i", "bset x1, x2, x3", 0x283110b3, true},
+		{"rv32i_zbs", "bset x1, x2, x3", 0x283110b3, false},
+		{"rv32i_zbb", "rev8 x1, x2 (RV64)", 0x6b815093, true},
+		{"rv64i_zbb", "rev8 x1, x2 (RV32)", 0x69815093, true},
+		{"rv64i_zbb", "zext.h x1, x2 (RV32)", 0x080140b3, true},
+		{"rv64i_zbb", "zext.h x1, x2", 0x080140bb, false},
+		{"rv32i", "slli x1, x2, 1 with funct7 = 0100000", 0x40111093, true},
+		{"rv32i", "fence", 0x0ff0000f, false},
+		{"rv32i", "fence.tso", 0x8330000f, false},
+		{"rv32i", "fence.i", 0x0000100f, true},
+		{"rv32i_zifencei", "fence.i", 0x0000100f, false},
+		{"rv32gc", "fence.i", 0x0000100f, false},
+		{"rv32i_zifencei", "cbo.clean (x1)", 0x0010a00f, true},
+	}
+
+	for _, test := range tests {
+		cpu := CreateEmulator(nil, WithISA(test.isa))
+		cpu.SetTrapPolicy(TrapPolicyStop)
+		if err := cpu.Bus.Map("program", 0, uint64(len(program)), readProgram, nil); err != nil {
+			t.Fatal(err)
+		}
+		if cpu.CSR.Get(CSRMISA) != cpu.ISA().MISA() {
+			t.Errorf("ISA %s: Expected misa to be %08x but got %08x", test.isa, cpu.ISA().MISA(), cpu.CSR.Get(CSRMISA))
+		}
+
+		binary.LittleEndian.PutUint32(program, test.ins)
+		err := cpu.RunStep(ctx)
+		var ex Exception
+		illegal := errors.As(err, &ex) && ex.Cause == ExceptionIllegalInstruction
+		if illegal != test.illegal {
+			t.Errorf("ISA %s: Expected %s illegal to be %t but got %v", test.isa, test.name, test.illegal, err)
+		}
+	}
+}
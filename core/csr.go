@@ -0,0 +1,198 @@
+package core
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"sort"
+)
+
+// PrivilegeLevel represents a RISC-V privilege mode
+type PrivilegeLevel uint32
+
+const (
+	PrivilegeUser       PrivilegeLevel = 0
+	PrivilegeSupervisor PrivilegeLevel = 1
+	PrivilegeMachine    PrivilegeLevel = 3
+)
+
+const csrReadOnlyMask = 0xC00  // csr[11:10] == 0b11 means read-only
+const csrPrivilegeMask = 0x300 // csr[9:8] is the lowest privilege level that can access the CSR
+
+// CSRReadHandle is a handler for CSR reads
+// It should return the current value of the CSR
+type CSRReadHandle func() uint64
+
+// CSRWriteHandle is a handler for CSR writes
+// value is the new CSR value with the WARL mask already applied
+type CSRWriteHandle func(value uint64)
+
+// CSR represents a Control and Status Register definition
+// Values are XLEN bits wide, so in RV32 mode only the lower 32 bits are used
+type CSR struct {
+	// Name is the name of the CSR (mstatus, mhartid, etc...)
+	Name string
+	// Value is the reset value of the CSR. Ignored if RHandler is specified
+	Value uint64
+	// WriteMask specifies which bits can be written. Bits outside the mask are kept (WARL)
+	WriteMask uint64
+	// RHandler is the read handler (can be nil to use the stored value)
+	RHandler CSRReadHandle
+	// WHandler is the write handler (can be nil to use the stored value)
+	WHandler CSRWriteHandle
+	// Available reports if the CSR can be accessed by instructions at the privilege level (can be nil for always)
+	// Used by CSRs that depend on a state, like the floating point CSRs when mstatus.FS is Off
+	Available func(priv PrivilegeLevel) bool
+}
+
+type csrEntry struct {
+	CSR
+	value uint64
+}
+
+func (c *csrEntry) read() uint64 {
+	if c.RHandler != nil {
+		return c.RHandler()
+	}
+	return c.value
+}
+
+func (c *csrEntry) write(value uint64) {
+	value = (c.read() &^ c.WriteMask) | (value & c.WriteMask)
+	if c.WHandler != nil {
+		c.WHandler(value)
+		return
+	}
+	c.value = value
+}
+
+// CSRFile holds all Control and Status Registers of a hart
+type CSRFile struct {
+	csrs map[uint32]*csrEntry
+	log  *logrus.Logger
+}
+
+func CreateCSRFile(log *logrus.Logger) *CSRFile {
+	if log == nil {
+		log = logrus.New()
+	}
+	return &CSRFile{
+		csrs: make(map[uint32]*csrEntry),
+		log:  log,
+	}
+}
+
+// Reset sets all stored CSR values to their reset value
+func (cf *CSRFile) Reset() {
+	for _, c := range cf.csrs {
+		c.value = c.Value
+	}
+}
+
+// Register adds a new CSR at the specified address
+func (cf *CSRFile) Register(address uint32, csr CSR) error {
+	if address > 0xFFF {
+		return fmt.Errorf("invalid csr address %03x", address)
+	}
+	if c, ok := cf.csrs[address]; ok {
+		return fmt.Errorf("csr %03x is already registered as %q", address, c.Name)
+	}
+	cf.csrs[address] = &csrEntry{
+		CSR:   csr,
+		value: csr.Value,
+	}
+	return nil
+}
+
+// Unregister removes the CSR at the specified address
+func (cf *CSRFile) Unregister(address uint32) {
+	delete(cf.csrs, address)
+}
+
+// Has returns true if a CSR is registered at the specified address
+func (cf *CSRFile) Has(address uint32) bool {
+	_, ok := cf.csrs[address]
+	return ok
+}
+
+// Get returns the value of a CSR bypassing any permission check
+// Returns 0 for non registered CSRs
+func (cf *CSRFile) Get(address uint32) uint64 {
+	c, ok := cf.csrs[address]
+	if !ok {
+		return 0
+	}
+	return c.read()
+}
+
+// Set sets the value of a CSR bypassing any permission check and WARL mask
+func (cf *CSRFile) Set(address uint32, value uint64) {
+	c, ok := cf.csrs[address]
+	if !ok {
+		cf.log.Errorf("csr %03x is not registered", address)
+		return
+	}
+	if c.WHandler != nil {
+		c.WHandler(value)
+		return
+	}
+	c.value = value
+}
+
+// checkAccess checks if the CSR at address exists and can be accessed with the specified privilege level
+func (cf *CSRFile) checkAccess(address uint32, priv PrivilegeLevel, write bool) (*csrEntry, error) {
+	c, ok := cf.csrs[address]
+	if !ok {
+		return nil, fmt.Errorf("csr %03x does not exist", address)
+	}
+	if PrivilegeLevel((address&csrPrivilegeMask)>>8) > priv {
+		return nil, fmt.Errorf("csr %03x (%s) is not accessible from privilege level %d", address, c.Name, priv)
+	}
+	if write && address&csrReadOnlyMask == csrReadOnlyMask {
+		return nil, fmt.Errorf("csr %03x (%s) is read-only", address, c.Name)
+	}
+	if c.Available != nil && !c.Available(priv) {
+		return nil, fmt.Errorf("csr %03x (%s) is not available", address, c.Name)
+	}
+	return c, nil
+}
+
+// Read reads a CSR checking if it is accessible from the specified privilege level
+func (cf *CSRFile) Read(address uint32, priv PrivilegeLevel) (uint64, error) {
+	c, err := cf.checkAccess(address, priv, false)
+	if err != nil {
+		return 0, err
+	}
+	return c.read(), nil
+}
+
+// Write writes a CSR checking if it is writable from the specified privilege level
+func (cf *CSRFile) Write(address uint32, value uint64, priv PrivilegeLevel) error {
+	c, err := cf.checkAccess(address, priv, true)
+	if err != nil {
+		return err
+	}
+	c.write(value)
+	return nil
+}
+
+const csrHeadFormat = "%12s %3s %8s\n"
+const csrLineFormat = "%12s %03x %08x\n"
+
+// String returns all registered CSRs in human readable format
+func (cf *CSRFile) String() string {
+	var addresses []uint32
+	result := fmt.Sprintf(csrHeadFormat, "Name", "Num", "Value")
+	for a := range cf.csrs {
+		addresses = append(addresses, a)
+	}
+
+	sort.Slice(addresses, func(i, j int) bool {
+		return addresses[i] < addresses[j]
+	})
+
+	for _, a := range addresses {
+		c := cf.csrs[a]
+		result += fmt.Sprintf(csrLineFormat, c.Name, a, c.read())
+	}
+	return result
+}
@@ -0,0 +1,46 @@
+package core
+
+// Standard CSR addresses
+const (
+	CSRMVendorID = 0xF11
+	CSRMArchID   = 0xF12
+	CSRMImpID    = 0xF13
+	CSRMHartID   = 0xF14
+
+	CSRMISA     = 0x301
+	CSRMScratch = 0x340
+)
+
+// misa extension bits
+const (
+	MISAExtA = 1 << 0
+	MISAExtC = 1 << 2
+	MISAExtD = 1 << 3
+	MISAExtE = 1 << 4
+	MISAExtF = 1 << 5
+	MISAExtI = 1 << 8
+	MISAExtM = 1 << 12
+	MISAExtS = 1 << 18
+	MISAExtU = 1 << 20
+
+	misaMXL32 = 1 << 30
+	misaMXL64 = 2 << 62
+)
+
+// registerMachineCSRs registers the CSRs implemented by the core
+func (rv32 *RISCV) registerMachineCSRs() {
+	csrs := map[uint32]CSR{
+		CSRMVendorID: {Name: "mvendorid"},
+		CSRMArchID:   {Name: "marchid"},
+		CSRMImpID:    {Name: "mimpid"},
+		CSRMHartID:   {Name: "mhartid"},
+		CSRMISA:      {Name: "misa", Value: rv32.isa.MISA()},
+		CSRMScratch:  {Name: "mscratch", WriteMask: ^uint64(0)},
+	}
+
+	for address, csr := range csrs {
+		if err := rv32.CSR.Register(address, csr); err != nil {
+			rv32.log.Errorf("cannot register csr %s: %s", csr.Name, err)
+		}
+	}
+}
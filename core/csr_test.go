@@ -0,0 +1,103 @@
+package core
+
+import "testing"
+
+func TestCSRFile_Register(t *testing.T) {
+	cf := CreateCSRFile(nil)
+
+	if err := cf.Register(0x7C0, CSR{Name: "custom0", Value: 0xCAFE}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cf.Register(0x7C0, CSR{Name: "custom1"}); err == nil {
+		t.Errorf("expected duplicated register to fail")
+	}
+	if err := cf.Register(0x1000, CSR{Name: "invalid"}); err == nil {
+		t.Errorf("expected register out of csr space to fail")
+	}
+	if !cf.Has(0x7C0) {
+		t.Errorf("expected csr 7c0 to be registered")
+	}
+
+	cf.Unregister(0x7C0)
+	if cf.Has(0x7C0) {
+		t.Errorf("expected csr 7c0 to be unregistered")
+	}
+	if _, err := cf.Read(0x7C0, PrivilegeMachine); err == nil {
+		t.Errorf("expected read of unregistered csr to fail")
+	}
+}
+
+func TestCSRFile_WARL(t *testing.T) {
+	cf := CreateCSRFile(nil)
+
+	if err := cf.Register(0x7C0, CSR{Name: "custom", Value: 0xFF00_0000, WriteMask: 0x0000_FFFF}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cf.Write(0x7C0, 0x1234_5678, PrivilegeMachine); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := cf.Read(0x7C0, PrivilegeMachine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 0xFF00_5678 {
+		t.Errorf("expected %08x got %08x", 0xFF00_5678, v)
+	}
+
+	cf.Reset()
+	if cf.Get(0x7C0) != 0xFF00_0000 {
+		t.Errorf("expected %08x after reset got %08x", 0xFF00_0000, cf.Get(0x7C0))
+	}
+}
+
+func TestCSRFile_Permissions(t *testing.T) {
+	cf := CreateCSRFile(nil)
+
+	_ = cf.Register(0xF15, CSR{Name: "mro", WriteMask: 0xFFFFFFFF}) // Machine Read-Only
+	_ = cf.Register(0x140, CSR{Name: "srw", WriteMask: 0xFFFFFFFF}) // Supervisor Read/Write
+	_ = cf.Register(0x040, CSR{Name: "urw", WriteMask: 0xFFFFFFFF}) // User Read/Write
+
+	if err := cf.Write(0xF15, 1, PrivilegeMachine); err == nil {
+		t.Errorf("expected write to read-only csr to fail")
+	}
+	if _, err := cf.Read(0xF15, PrivilegeMachine); err != nil {
+		t.Errorf("expected read of read-only csr to succeed: %s", err)
+	}
+	if _, err := cf.Read(0xF15, PrivilegeSupervisor); err == nil {
+		t.Errorf("expected read of machine csr from supervisor to fail")
+	}
+	if err := cf.Write(0x140, 1, PrivilegeUser); err == nil {
+		t.Errorf("expected write of supervisor csr from user to fail")
+	}
+	if err := cf.Write(0x140, 1, PrivilegeSupervisor); err != nil {
+		t.Errorf("expected write of supervisor csr from supervisor to succeed: %s", err)
+	}
+	if err := cf.Write(0x040, 1, PrivilegeUser); err != nil {
+		t.Errorf("expected write of user csr from user to succeed: %s", err)
+	}
+}
+
+func TestCSRFile_Handlers(t *testing.T) {
+	cf := CreateCSRFile(nil)
+	counter := uint64(10)
+
+	_ = cf.Register(0x7C0, CSR{
+		Name:      "counter",
+		WriteMask: 0xFF,
+		RHandler:  func() uint64 { return counter },
+		WHandler:  func(value uint64) { counter = value },
+	})
+
+	if err := cf.Write(0x7C0, 0xABCD, PrivilegeMachine); err != nil {
+		t.Fatal(err)
+	}
+	if counter != 0xCD {
+		t.Errorf("expected write handler to receive %08x got %08x", 0xCD, counter)
+	}
+	counter++
+	if v, _ := cf.Read(0x7C0, PrivilegeMachine); v != 0xCE {
+		t.Errorf("expected read handler to return %08x got %08x", 0xCE, v)
+	}
+}
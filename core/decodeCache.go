@@ -0,0 +1,454 @@
+package core
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+const (
+	decodeCachePageEntries = 1 << (pageShift - 1) // One entry for each halfword of a page
+	decodeCacheMaxPages    = 256                  // The cache is flushed when more pages are needed
+)
+
+// decodedHandler runs a decoded instruction, pc already points to the next instruction
+// Stores can invalidate the entry being run, so d must not be used after them
+type decodedHandler func(rv32 *RISCV, ctx context.Context, d *decodedInstruction) error
+
+// decodedKind selects the handler of a decoded instruction
+type decodedKind uint8
+
+const (
+	kindInterpreter decodedKind = iota // Run by runInstruction / runCompressed
+	kindADDI
+	kindALUImmediate
+	kindADD
+	kindALU
+	kindMulDiv
+	kindBranch
+	kindLoad
+	kindStore
+	kindLUI
+	kindAUIPC
+	kindJAL
+	kindJALR
+)
+
+var decodedHandlers = [...]decodedHandler{
+	kindInterpreter:  execInstruction,
+	kindADDI:         execADDI,
+	kindALUImmediate: execALUImmediate,
+	kindADD:          execADD,
+	kindALU:          execALU,
+	kindMulDiv:       execMulDiv,
+	kindBranch:       execBranch,
+	kindLoad:         execLoad,
+	kindStore:        execStore,
+	kindLUI:          execLUI,
+	kindAUIPC:        execAUIPC,
+	kindJAL:          execJAL,
+	kindJALR:         execJALR,
+}
+
+// decodedInstruction is an instruction with its operands already extracted
+type decodedInstruction struct {
+	exec   decodedHandler // nil for entries not decoded yet
+	imm    uint64
+	mask   uint64 // Mask of rs2 in register-register operations, used by the shifts
+	raw    uint32 // Instruction as fetched, compressed instructions are in the lower 16 bits
+	aluOp  int16
+	kind   decodedKind
+	opcode uint8 // Opcode of the 32 bit instruction, compressed instructions are expanded
+	rd     uint8
+	rs1    uint8
+	rs2    uint8
+	funct3 uint8
+	size   uint8 // 2 for compressed instructions, 4 otherwise
+}
+
+// decodeCache holds the decoded instructions of each physical page that has been executed
+type decodeCache struct {
+	pages      map[uint64][]decodedInstruction
+	lastPage   uint64
+	last       []decodedInstruction
+	filter     [64]uint64 // Bit set of the cached pages (modulo 4096), so stores to other pages skip the map
+	generation uint64     // Bus generation of the cached instructions
+}
+
+func newDecodeCache() *decodeCache {
+	return &decodeCache{pages: make(map[uint64][]decodedInstruction)}
+}
+
+// lookup returns the decoded instruction at the physical address, or nil if it is not cached
+func (c *decodeCache) lookup(paddr uint64) *decodedInstruction {
+	page := paddr >> pageShift
+	if c.last == nil || c.lastPage != page {
+		entries, ok := c.pages[page]
+		if !ok {
+			return nil
+		}
+		c.lastPage, c.last = page, entries
+	}
+	d := &c.last[(paddr&pageOffsetMask)>>1]
+	if d.exec == nil {
+		return nil
+	}
+	return d
+}
+
+// insert caches the decoded instruction at the physical address
+func (c *decodeCache) insert(paddr uint64, d decodedInstruction) *decodedInstruction {
+	page := paddr >> pageShift
+	entries, ok := c.pages[page]
+	if !ok {
+		if len(c.pages) >= decodeCacheMaxPages {
+			c.flush()
+		}
+		entries = make([]decodedInstruction, decodeCachePageEntries)
+		c.pages[page] = entries
+		c.filter[page>>6&63] |= 1 << (page & 63)
+	}
+	entry := &entries[(paddr&pageOffsetMask)>>1]
+	*entry = d
+	return entry
+}
+
+// invalidate drops the cached instructions that overlap the size bytes written at the physical address
+func (c *decodeCache) invalidate(paddr, size uint64) {
+	start := paddr &^ 1
+	if start >= 2 { // A 32 bit instruction that starts in the previous halfword also overlaps the write
+		start -= 2
+	}
+	end := paddr + size
+	for page := start >> pageShift; page <= (end-1)>>pageShift; page++ {
+		if c.filter[page>>6&63]&(1<<(page&63)) == 0 {
+			continue
+		}
+		entries, ok := c.pages[page]
+		if !ok {
+			continue
+		}
+		for addr := start; addr < end; addr += 2 {
+			if addr>>pageShift == page {
+				entries[(addr&pageOffsetMask)>>1] = decodedInstruction{}
+			}
+		}
+	}
+}
+
+// flush drops all cached instructions
+func (c *decodeCache) flush() {
+	c.pages = make(map[uint64][]decodedInstruction)
+	c.last = nil
+	c.filter = [64]uint64{}
+}
+
+// FlushInstructionCache invalidates all decoded instructions and compiled blocks, it is run by fence.i
+// Stores made by the core invalidate the instructions they overwrite, so this is only needed after changing code
+// in memory without going through the core, like loading a program from the host
+func (rv32 *RISCV) FlushInstructionCache() {
+	if rv32.decodeCache != nil {
+		rv32.decodeCache.flush()
+	}
+	if rv32.blocks != nil {
+		rv32.blocks.flush()
+	}
+}
+
+// invalidateCode drops the decoded instructions overwritten by a store of size bytes at the physical address
+func (rv32 *RISCV) invalidateCode(paddr, size uint64) {
+	if rv32.decodeCache != nil {
+		rv32.decodeCache.invalidate(paddr, size)
+	}
+	if rv32.blocks != nil {
+		rv32.blocks.invalidate(paddr, size)
+	}
+}
+
+// runDecoded runs the instruction at pc from the decode cache, decoding and caching it on misses
+// Translation and PMP checks are done on every fetch, as the cache is indexed by physical address
+func (rv32 *RISCV) runDecoded(ctx context.Context, pc uint64) error {
+	paddr, err := rv32.translate(ctx, pc, 2, AccessFetch)
+	if err != nil {
+		return err
+	}
+
+	c := rv32.decodeCache
+	if generation := atomic.LoadUint64(&rv32.Bus.generation); c.generation != generation { // Maps or permissions changed
+		c.flush()
+		c.generation = generation
+	}
+
+	d := c.lookup(paddr)
+	if d == nil {
+		value, err := rv32.fetchPhysical(ctx, pc, paddr)
+		if err != nil {
+			return err
+		}
+		decoded := rv32.decode(value)
+		if paddr&pageOffsetMask+uint64(decoded.size) <= 1<<pageShift {
+			d = c.insert(paddr, decoded)
+		} else { // Instructions that cross a page boundary can be mapped to any physical page, they are not cached
+			d = &decoded
+		}
+	} else if d.size == 4 && pc&3 != 0 { // The upper half is fetched from the next word, which needs the same checks
+		if _, err := rv32.translate(ctx, (pc+2)&rv32.xlenMask, 2, AccessFetch); err != nil {
+			return err
+		}
+	}
+
+	rv32.pc = (pc + uint64(d.size)) & rv32.xlenMask
+	return d.exec(rv32, ctx, d)
+}
+
+// decode extracts the operands of the instruction and selects its handler
+// The most used instructions have their own handlers, anything else (including illegal instructions) is run by
+// runInstruction / runCompressed
+func (rv32 *RISCV) decode(value uint32) decodedInstruction {
+	d := decodedInstruction{raw: value, size: 4}
+	d.kind = rv32.decodeOperands(&d)
+	d.exec = decodedHandlers[d.kind]
+	return d
+}
+
+// decodeOperands fills the operands of the decoded instruction and returns its kind
+func (rv32 *RISCV) decodeOperands(d *decodedInstruction) decodedKind {
+	ins := d.raw
+	if isCompressed(ins) && rv32.isa.Has(MISAExtC) {
+		d.raw &= 0xFFFF
+		d.size = 2
+		expanded, ok := expandCompressed(d.raw, rv32.isa.XLEN)
+		if !ok {
+			return kindInterpreter
+		}
+		ins = expanded
+	}
+	opcode := ins & insOpcodeMask
+	d.opcode = uint8(opcode)
+	if rv32.isa.Embedded && usesUpperRegisters(ins) { // RV32E
+		return kindInterpreter
+	}
+
+	funct3 := (ins & insFunct3Mask) >> 12
+	funct7 := (ins & insFunct7Mask) >> 25
+	immTypeI := (ins & insImmTypeI) >> 20
+	d.rd = uint8((ins & insRdMask) >> 7)
+	d.rs1 = uint8((ins & insRs1Mask) >> 15)
+	d.rs2 = uint8((ins & insRs2Mask) >> 20)
+	d.funct3 = uint8(funct3)
+	d.imm = immediate(ins)
+	d.mask = ^uint64(0)
+
+	aluOp := aluINVALID
+	kind := kindInterpreter
+
+	switch opcode {
+	case 0b0010011: // addi, slti, sltiu, xori, ori, andi, slli, srli, srai, Zbb / Zbs immediate operations
+		kind = kindALUImmediate
+		switch funct3 {
+		case 0:
+			aluOp = aluADD
+			kind = kindADDI
+		case 1, 5:
+			aluOp = rv32.shiftImmediateOp(funct3, immTypeI)
+			if rv32.isa.XLEN == 32 && d.imm&0x20 != 0 { // shamt[5] is reserved in RV32
+				aluOp = aluINVALID
+			}
+			d.imm &= uint64(rv32.isa.XLEN - 1)
+		case 2:
+			aluOp = aluLesserThanSigned
+		case 3:
+			aluOp = aluLesserThanUnsigned
+		case 4:
+			aluOp = aluXOR
+		case 6:
+			aluOp = aluOR
+		case 7:
+			aluOp = aluAND
+		}
+	case 0b0110011: // add, sub, sll, slt, sltu, xor, srl, sra, or, and, RV32M, Zba / Zbb / Zbs
+		if funct7 == 0b0000001 {
+			if rv32.isa.Has(MISAExtM) {
+				return kindMulDiv
+			}
+			return kindInterpreter
+		}
+		kind = kindALU
+		switch {
+		case funct7 == 0:
+			switch funct3 {
+			case 0:
+				aluOp = aluADD
+				kind = kindADD
+			case 1:
+				aluOp = aluShiftLeftUnsigned
+				d.mask = uint64(rv32.isa.XLEN - 1)
+			case 2:
+				aluOp = aluLesserThanSigned
+			case 3:
+				aluOp = aluLesserThanUnsigned
+			case 4:
+				aluOp = aluXOR
+			case 5:
+				aluOp = aluShiftRightUnsigned
+				d.mask = uint64(rv32.isa.XLEN - 1)
+			case 6:
+				aluOp = aluOR
+			case 7:
+				aluOp = aluAND
+			}
+		case funct7 == 0b0100000 && funct3 == 0:
+			aluOp = aluSUB
+		case funct7 == 0b0100000 && funct3 == 5:
+			aluOp = aluShiftRightSigned
+			d.mask = uint64(rv32.isa.XLEN - 1)
+		default:
+			aluOp = rv32.bitManipOp(funct7, funct3, uint32(d.rs2))
+		}
+	case 0b1100011: // beq, bne, blt, bge, bltu, bgeu
+		kind = kindBranch
+		switch funct3 {
+		case 0:
+			aluOp = aluEqual
+		case 1:
+			aluOp = aluNotEqual
+		case 4:
+			aluOp = aluLesserThanSigned
+		case 5:
+			aluOp = aluGreaterThanOrEqualSigned
+		case 6:
+			aluOp = aluLesserThanUnsigned
+		case 7:
+			aluOp = aluGreaterThanOrEqualUnsigned
+		}
+	case 0b0000011: // lb, lh, lw, lbu, lhu, (RV64) ld, lwu
+		if funct3 != 7 && (rv32.isa.XLEN == 64 || (funct3 != 3 && funct3 != 6)) {
+			return kindLoad
+		}
+		return kindInterpreter
+	case 0b0100011: // sb, sh, sw, (RV64) sd
+		if funct3 < 3 || (rv32.isa.XLEN == 64 && funct3 == 3) {
+			return kindStore
+		}
+		return kindInterpreter
+	case 0b0110111: // lui
+		return kindLUI
+	case 0b0010111: // auipc
+		return kindAUIPC
+	case 0b1101111: // jal
+		return kindJAL
+	case 0b1100111: // jalr
+		if funct3 == 0 {
+			return kindJALR
+		}
+		return kindInterpreter
+	}
+
+	if aluOp == aluINVALID {
+		return kindInterpreter
+	}
+	d.aluOp = int16(aluOp)
+	return kind
+}
+
+// execInstruction runs the instruction with the interpreter
+func execInstruction(rv32 *RISCV, ctx context.Context, d *decodedInstruction) error {
+	if d.size == 2 {
+		return rv32.runCompressed(ctx, uint16(d.raw))
+	}
+	return rv32.runInstruction(ctx, d.raw)
+}
+
+func execADDI(rv32 *RISCV, ctx context.Context, d *decodedInstruction) error {
+	rv32.setInteger(uint32(d.rd), rv32.Registers.GetInteger(uint32(d.rs1))+d.imm)
+	return nil
+}
+
+func execALUImmediate(rv32 *RISCV, ctx context.Context, d *decodedInstruction) error {
+	rv32.setInteger(uint32(d.rd), rv32.aluX(int(d.aluOp), rv32.Registers.GetInteger(uint32(d.rs1)), d.imm))
+	return nil
+}
+
+func execADD(rv32 *RISCV, ctx context.Context, d *decodedInstruction) error {
+	rv32.setInteger(uint32(d.rd), rv32.Registers.GetInteger(uint32(d.rs1))+rv32.Registers.GetInteger(uint32(d.rs2)))
+	return nil
+}
+
+func execALU(rv32 *RISCV, ctx context.Context, d *decodedInstruction) error {
+	rs1Val := rv32.Registers.GetInteger(uint32(d.rs1))
+	rs2Val := rv32.Registers.GetInteger(uint32(d.rs2)) & d.mask
+	rv32.setInteger(uint32(d.rd), rv32.aluX(int(d.aluOp), rs1Val, rs2Val))
+	return nil
+}
+
+func execMulDiv(rv32 *RISCV, ctx context.Context, d *decodedInstruction) error {
+	return rv32.runMulDiv(uint32(d.rd), uint32(d.funct3), rv32.Registers.GetInteger(uint32(d.rs1)), rv32.Registers.GetInteger(uint32(d.rs2)))
+}
+
+func execBranch(rv32 *RISCV, ctx context.Context, d *decodedInstruction) error {
+	if rv32.aluX(int(d.aluOp), rv32.Registers.GetInteger(uint32(d.rs1)), rv32.Registers.GetInteger(uint32(d.rs2))) != 1 {
+		return nil
+	}
+	target := (rv32.insPC + d.imm) & rv32.xlenMask
+	if target&rv32.ialignMask != 0 {
+		return rv32.misalignedJump(target)
+	}
+	rv32.SetPC(target)
+	rv32.countEvent(HPMEventTakenBranch)
+	return nil
+}
+
+func execLoad(rv32 *RISCV, ctx context.Context, d *decodedInstruction) error {
+	numBytes := d.funct3 & 3
+	rd := uint32(d.rd)
+	signed := d.funct3&4 == 0 && numBytes < 3
+
+	data, err := rv32.load(ctx, (rv32.Registers.GetInteger(uint32(d.rs1))+d.imm)&rv32.xlenMask, 1<<numBytes)
+	if err != nil {
+		return err
+	}
+	if signed {
+		data = signExtend64(data, uint(8<<numBytes))
+	}
+	rv32.setInteger(rd, data)
+	rv32.countEvent(HPMEventLoad)
+	return nil
+}
+
+func execStore(rv32 *RISCV, ctx context.Context, d *decodedInstruction) error {
+	addr := (rv32.Registers.GetInteger(uint32(d.rs1)) + d.imm) & rv32.xlenMask
+	if err := rv32.store(ctx, addr, 1<<(d.funct3&3), rv32.Registers.GetInteger(uint32(d.rs2))); err != nil {
+		return err
+	}
+	rv32.countEvent(HPMEventStore)
+	return nil
+}
+
+func execLUI(rv32 *RISCV, ctx context.Context, d *decodedInstruction) error {
+	rv32.setInteger(uint32(d.rd), d.imm)
+	return nil
+}
+
+func execAUIPC(rv32 *RISCV, ctx context.Context, d *decodedInstruction) error {
+	rv32.setInteger(uint32(d.rd), rv32.insPC+d.imm)
+	return nil
+}
+
+func execJAL(rv32 *RISCV, ctx context.Context, d *decodedInstruction) error {
+	target := (rv32.insPC + d.imm) & rv32.xlenMask
+	if target&rv32.ialignMask != 0 {
+		return rv32.misalignedJump(target)
+	}
+	rv32.setInteger(uint32(d.rd), rv32.pc)
+	rv32.SetPC(target)
+	return nil
+}
+
+func execJALR(rv32 *RISCV, ctx context.Context, d *decodedInstruction) error {
+	target := (rv32.Registers.GetInteger(uint32(d.rs1)) + d.imm) & rv32.xlenMask &^ 1
+	if target&rv32.ialignMask != 0 {
+		return rv32.misalignedJump(target)
+	}
+	rv32.setInteger(uint32(d.rd), rv32.pc)
+	rv32.SetPC(target)
+	return nil
+}
@@ -0,0 +1,177 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDecodeCache_Interpreter(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		ins  uint32
+	}{
+		{"lh x1, -2(x2)", 0xffe11083},
+		{"lhu x1, -2(x2)", 0xffe15083},
+		{"lb x1, -1(x2)", 0xfff10083},
+		{"sh x3, -2(x2)", 0xfe311f23},
+		{"srai x1, x2, 3", 0x40315093},
+		{"sra x1, x2, x3", 0x403150b3},
+		{"sll x1, x2, x3", 0x003110b3},
+		{"sltiu x1, x2, -1", 0xfff13093},
+		{"bge x2, x3, 8", 0x00315463},
+		{"bltu x3, x2, -4", 0xfe21eee3},
+		{"jal x1, 16", 0x010000ef},
+		{"jalr x1, -3(x2)", 0xffd100e7},
+		{"auipc x1, 0xfffff", 0xfffff097},
+		{"mulh x1, x2, x3", 0x023110b3},
+		{"sub x1, x3, x2", 0x402180b3},
+		{"andn x1, x2, x3", 0x403170b3},
+		{"clz x1, x2", 0x60011093},
+		{"c.addi x8, -3", 0x1475},
+		{"c.lw x9, 4(x8)", 0x4044},
+		{"c.j -4", 0xbff5},
+		{"c.add x8, x9", 0x9426},
+		{"fence.i", 0x0000100f},
+		{"illegal", 0x00000000},
+	}
+
+	for _, test := range tests {
+		var results []string
+		for _, cached := range []bool{false, true} {
+			cpu, _ := createTestCPU(t, []Option{WithDecodeCache(cached)}, test.ins)
+			for i := uint64(0x100); i < 0x200; i += 4 {
+				if err := cpu.Bus.WriteWord(ctx, i, uint32(i*0x01010101)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			cpu.Registers.SetInteger(2, 0x182)
+			cpu.Registers.SetInteger(3, 5)
+			cpu.Registers.SetInteger(8, 0x180)
+			cpu.Registers.SetInteger(9, 7)
+
+			err := cpu.RunStep(ctx)
+			result := fmt.Sprintf("error %v pc %08x registers %v", err, cpu.GetPC(), cpu.Registers.integers)
+			for i := uint64(0x100); i < 0x200; i += 4 {
+				v, _ := cpu.Bus.ReadWord(ctx, i)
+				result += fmt.Sprintf(" %08x", v)
+			}
+			results = append(results, result)
+		}
+		if results[0] != results[1] {
+			t.Errorf("%s: Expected the decode cache to give the same result as the interpreter\n%s\nbut got\n%s", test.name, results[0], results[1])
+		}
+	}
+}
+
+func TestDecodeCache_SelfModifying(t *testing.T) {
+	testDecodeCacheSelfModifying(t)
+}
+
+func testDecodeCacheSelfModifying(t *testing.T, opts ...Option) {
+	ctx := context.Background()
+	cpu, _ := createTestCPU(t, opts,
+		0x00000093, // addi x1, x0, 0
+		0x00108093, // addi x1, x1, 1 (replaced by addi x1, x1, 16)
+		0x00502223, // sw x5, 4(x0)
+		0x00110113, // addi x2, x2, 1
+		0xfe314ae3, // blt x2, x3, -12
+	)
+	cpu.Registers.SetInteger(3, 2)
+	cpu.Registers.SetInteger(5, 0x01008093) // addi x1, x1, 16
+
+	if err := cpu.RunUntilWithTimeout(ctx, 0x14, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if v := cpu.Registers.GetInteger(1); v != 17 {
+		t.Errorf("Expected X01 to be 17 but got %d", v)
+	}
+
+	// Changing the code without going through the core needs a flush (or fence.i)
+	cpu.SetPC(0x04)
+	if err := cpu.RunStep(ctx); err != nil {
+		t.Fatal(err)
+	}
+	cpu.Registers.SetInteger(1, 0)
+	if err := cpu.Bus.WriteWord(ctx, 0x04, 0x00208093); err != nil { // addi x1, x1, 2
+		t.Fatal(err)
+	}
+	cpu.FlushInstructionCache()
+	cpu.SetPC(0x04)
+	if err := cpu.RunStep(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if v := cpu.Registers.GetInteger(1); v != 2 {
+		t.Errorf("Expected X01 to be 2 but got %d", v)
+	}
+}
+
+func TestDecodeCache_BusChanges(t *testing.T) {
+	testDecodeCacheBusChanges(t)
+}
+
+func testDecodeCacheBusChanges(t *testing.T, opts ...Option) {
+	ctx := context.Background()
+	cpu, _ := createTestCPU(t, opts, 0x00108093) // addi x1, x1, 1
+	if err := cpu.RunStep(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Removing the execute permission is seen by the next fetch
+	if err := cpu.Bus.SetPermissions("memory", BusRead|BusWrite); err != nil {
+		t.Fatal(err)
+	}
+	cpu.SetPC(0)
+	err := cpu.RunStep(ctx)
+	var ex Exception
+	if !errors.As(err, &ex) || ex.Cause != ExceptionInstructionAccessFault {
+		t.Errorf("Expected an instruction access fault but got %v", err)
+	}
+}
+
+// columnProgram is the inner loop of a texture mapped column renderer (like R_DrawColumn in DOOM), that draws
+// a 200 pixel high column on a 320 pixel wide frame buffer through a colormap, over and over
+var columnProgram = []uint32{
+	0x00010537, // start: lui a0, 0x10 (frame buffer)
+	0x000025b7, // lui a1, 0x2 (texture)
+	0x00003637, // lui a2, 0x3 (colormap)
+	0x00000693, // li a3, 0 (frac)
+	0x00008737, // lui a4, 0x8 (fracstep)
+	0x0c800793, // li a5, 200 (count)
+	0x14000393, // li t2, 320
+	0x0106d293, // loop: srli t0, a3, 16
+	0x07f2f293, // andi t0, t0, 127
+	0x005582b3, // add t0, a1, t0
+	0x0002c283, // lbu t0, 0(t0)
+	0x005602b3, // add t0, a2, t0
+	0x0002c283, // lbu t0, 0(t0)
+	0x00550023, // sb t0, 0(a0)
+	0x00750533, // add a0, a0, t2
+	0x00e686b3, // add a3, a3, a4
+	0xfff78793, // addi a5, a5, -1
+	0xfc079ce3, // bnez a5, loop
+	0xfb9ff06f, // j start
+}
+
+func BenchmarkDecodeCache(b *testing.B) {
+	for _, cached := range []bool{false, true} {
+		name := "interpreter"
+		if cached {
+			name = "cached"
+		}
+		b.Run(name, func(b *testing.B) {
+			cpu, _ := createTestCPU(b, []Option{WithDecodeCache(cached)}, columnProgram...)
+			ctx := context.Background()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := cpu.RunStep(ctx); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
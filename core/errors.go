@@ -4,7 +4,7 @@ package core
 type RuntimeError struct {
 	Message       string
 	RegisterState RegisterBank
-	PC            uint32
+	PC            uint64
 }
 
 func (re RuntimeError) String() string {
@@ -14,3 +14,20 @@ func (re RuntimeError) String() string {
 func (re RuntimeError) Error() string {
 	return re.String()
 }
+
+// Exception represents a RISC-V synchronous exception raised by an instruction
+type Exception struct {
+	Message string
+	// Cause is the exception code written to mcause
+	Cause uint32
+	// Value is the exception specific value written to mtval (faulting address or instruction)
+	Value uint64
+}
+
+func (e Exception) String() string {
+	return e.Message
+}
+
+func (e Exception) Error() string {
+	return e.String()
+}
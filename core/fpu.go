@@ -0,0 +1,394 @@
+package core
+
+import "context"
+
+// Floating point CSR addresses
+const (
+	CSRFFlags = 0x001
+	CSRFRM    = 0x002
+	CSRFCSR   = 0x003
+)
+
+// fcsr fields
+const (
+	fflagsMask = 0x1F
+	frmShift   = 5
+	frmMask    = 7 << frmShift
+	fcsrMask   = fflagsMask | frmMask
+)
+
+// Floating point fmt field values
+const (
+	fmtSingle = 0b00
+	fmtDouble = 0b01
+)
+
+// registerFloatCSRs registers the floating point control and status CSRs
+func (rv32 *RISCV) registerFloatCSRs() {
+	available := func(priv PrivilegeLevel) bool { return rv32.fpuEnabled() }
+	csrs := map[uint32]CSR{
+		CSRFFlags: {
+			Name:      "fflags",
+			WriteMask: fflagsMask,
+			Available: available,
+			RHandler:  func() uint64 { return uint64(rv32.fcsr & fflagsMask) },
+			WHandler:  func(value uint64) { rv32.setFCSR((rv32.fcsr &^ fflagsMask) | uint32(value)) },
+		},
+		CSRFRM: {
+			Name:      "frm",
+			WriteMask: frmMask >> frmShift,
+			Available: available,
+			RHandler:  func() uint64 { return uint64(rv32.fcsr >> frmShift) },
+			WHandler:  func(value uint64) { rv32.setFCSR((rv32.fcsr &^ frmMask) | uint32(value)<<frmShift) },
+		},
+		CSRFCSR: {
+			Name:      "fcsr",
+			WriteMask: fcsrMask,
+			Available: available,
+			RHandler:  func() uint64 { return uint64(rv32.fcsr) },
+			WHandler:  func(value uint64) { rv32.setFCSR(uint32(value)) },
+		},
+	}
+
+	for address, csr := range csrs {
+		if err := rv32.CSR.Register(address, csr); err != nil {
+			rv32.log.Errorf("cannot register csr %s: %s", csr.Name, err)
+		}
+	}
+}
+
+// fpuEnabled returns true if F is implemented and the floating point unit is not turned off by mstatus.FS
+func (rv32 *RISCV) fpuEnabled() bool {
+	return rv32.isa.Has(MISAExtF) && rv32.mstatus&MStatusFS != FSOff<<mstatusFSShift
+}
+
+// setFPUDirty marks the floating point state as modified in mstatus.FS
+func (rv32 *RISCV) setFPUDirty() {
+	rv32.mstatus |= MStatusFS | rv32.mstatusSD()
+}
+
+// setFCSR sets fcsr and marks the floating point state as dirty
+func (rv32 *RISCV) setFCSR(value uint32) {
+	rv32.fcsr = value & fcsrMask
+	rv32.setFPUDirty()
+}
+
+// raiseFloatFlags accrues the exception flags into fflags
+func (rv32 *RISCV) raiseFloatFlags(flags uint32) {
+	if flags != 0 {
+		rv32.setFCSR(rv32.fcsr | flags)
+	}
+}
+
+// roundingMode returns the rounding mode from the instruction rm field
+// Returns false if the rounding mode is invalid (reserved values or dynamic with invalid frm)
+func (rv32 *RISCV) roundingMode(rm uint32) (uint32, bool) {
+	if rm == RoundDynamic {
+		rm = rv32.fcsr >> frmShift
+	}
+	return rm, rm <= RoundNearestMax
+}
+
+// floatFormatOf returns the format of the fmt field
+// Returns false for reserved formats and for double precision when D is not implemented
+func (rv32 *RISCV) floatFormatOf(fmt uint32) (floatFormat, bool) {
+	switch fmt {
+	case fmtSingle:
+		return float32Format, true
+	case fmtDouble:
+		return float64Format, rv32.isa.Has(MISAExtD)
+	}
+	return floatFormat{}, false
+}
+
+// getFloat returns the bits of the float register in the specified format
+func (rv32 *RISCV) getFloat(f floatFormat, reg uint32) uint64 {
+	if f == float32Format {
+		return rv32.Registers.GetFloatSingleBits(reg)
+	}
+	return rv32.Registers.GetFloatBits(reg)
+}
+
+// setFloat sets the float register with a value in the specified format. Single precision values are NaN-boxed
+func (rv32 *RISCV) setFloat(f floatFormat, reg uint32, value uint64) {
+	if f == float32Format {
+		value |= nanBoxMask
+	}
+	rv32.Registers.SetFloatBits(reg, value)
+	rv32.setFPUDirty()
+}
+
+// runFloat runs the RV32F and RV32D instructions
+func (rv32 *RISCV) runFloat(ctx context.Context, ins uint32) error {
+	if !rv32.fpuEnabled() {
+		return rv32.illegalInstruction(ins)
+	}
+
+	switch ins & insOpcodeMask {
+	case 0b0000111:
+		return rv32.runFloatLoad(ctx, ins)
+	case 0b0100111:
+		return rv32.runFloatStore(ctx, ins)
+	case 0b1010011:
+		return rv32.runFloatOp(ins)
+	}
+	return rv32.runFloatFused(ins)
+}
+
+// runFloatLoad runs flw and fld
+func (rv32 *RISCV) runFloatLoad(ctx context.Context, ins uint32) error {
+	// imm[11:0] rs1 010 rd 0000111 I flw
+	// imm[11:0] rs1 011 rd 0000111 I fld
+	rd := (ins & insRdMask) >> 7
+	funct3 := (ins & insFunct3Mask) >> 12
+	rs1 := (ins & insRs1Mask) >> 15
+	imm := uint64(int64(signExtend((ins&insImmTypeI)>>20, 12)))
+	addr := rv32.aluX(aluADD, rv32.Registers.GetInteger(rs1), imm)
+
+	if !rv32.floatMemoryWidthValid(funct3) {
+		return rv32.illegalInstruction(ins)
+	}
+	data, err := rv32.load(ctx, addr, 1<<funct3)
+	if err != nil {
+		return err
+	}
+	if funct3 == 0b010 {
+		rv32.setFloat(float32Format, rd, data)
+	} else {
+		rv32.setFloat(float64Format, rd, data)
+	}
+
+	rv32.countEvent(HPMEventLoad)
+	return nil
+}
+
+// floatMemoryWidthValid returns true if the width (funct3) of a float load / store is implemented
+func (rv32 *RISCV) floatMemoryWidthValid(funct3 uint32) bool {
+	return funct3 == 0b010 || (funct3 == 0b011 && rv32.isa.Has(MISAExtD))
+}
+
+// runFloatStore runs fsw and fsd
+func (rv32 *RISCV) runFloatStore(ctx context.Context, ins uint32) error {
+	// imm[11:5] rs2 rs1 010 imm[4:0] 0100111 S fsw
+	// imm[11:5] rs2 rs1 011 imm[4:0] 0100111 S fsd
+	funct3 := (ins & insFunct3Mask) >> 12
+	rs1 := (ins & insRs1Mask) >> 15
+	rs2 := (ins & insRs2Mask) >> 20
+	imm := uint64(int64(signExtend(((ins&insImmTypeS0)>>7)+((ins&insImmTypeS1)>>20), 12)))
+	addr := rv32.aluX(aluADD, rv32.Registers.GetInteger(rs1), imm)
+	value := rv32.Registers.GetFloatBits(rs2) // Stores do not check NaN-boxing
+
+	if !rv32.floatMemoryWidthValid(funct3) {
+		return rv32.illegalInstruction(ins)
+	}
+	if err := rv32.store(ctx, addr, 1<<funct3, value); err != nil {
+		return err
+	}
+	rv32.countEvent(HPMEventStore)
+	return nil
+}
+
+// runFloatFused runs the fused multiply-add instructions
+func (rv32 *RISCV) runFloatFused(ins uint32) error {
+	// rs3 fmt rs2 rs1 rm rd 1000011 R4 fmadd
+	// rs3 fmt rs2 rs1 rm rd 1000111 R4 fmsub
+	// rs3 fmt rs2 rs1 rm rd 1001011 R4 fnmsub
+	// rs3 fmt rs2 rs1 rm rd 1001111 R4 fnmadd
+	opcode := ins & insOpcodeMask
+	rd := (ins & insRdMask) >> 7
+	rs1 := (ins & insRs1Mask) >> 15
+	rs2 := (ins & insRs2Mask) >> 20
+	rs3 := ins >> 27
+
+	f, ok := rv32.floatFormatOf((ins >> 25) & 3)
+	if !ok {
+		return rv32.illegalInstruction(ins)
+	}
+	rm, ok := rv32.roundingMode((ins & insFunct3Mask) >> 12)
+	if !ok {
+		return rv32.illegalInstruction(ins)
+	}
+
+	negProduct := opcode == 0b1001011 || opcode == 0b1001111
+	negAddend := opcode == 0b1000111 || opcode == 0b1001111
+
+	result, flags := f.fma(rv32.getFloat(f, rs1), rv32.getFloat(f, rs2), rv32.getFloat(f, rs3), negProduct, negAddend, rm)
+	rv32.setFloat(f, rd, result)
+	rv32.raiseFloatFlags(flags)
+	return nil
+}
+
+// runFloatOp runs the OP-FP instructions
+func (rv32 *RISCV) runFloatOp(ins uint32) error {
+	// 00000 fmt rs2   rs1 rm  rd 1010011 R fadd
+	// 00001 fmt rs2   rs1 rm  rd 1010011 R fsub
+	// 00010 fmt rs2   rs1 rm  rd 1010011 R fmul
+	// 00011 fmt rs2   rs1 rm  rd 1010011 R fdiv
+	// 01011 fmt 00000 rs1 rm  rd 1010011 R fsqrt
+	// 00100 fmt rs2   rs1 000 rd 1010011 R fsgnj
+	// 00100 fmt rs2   rs1 001 rd 1010011 R fsgnjn
+	// 00100 fmt rs2   rs1 010 rd 1010011 R fsgnjx
+	// 00101 fmt rs2   rs1 000 rd 1010011 R fmin
+	// 00101 fmt rs2   rs1 001 rd 1010011 R fmax
+	// 01000 00  00001 rs1 rm  rd 1010011 R fcvt.s.d
+	// 01000 01  00000 rs1 rm  rd 1010011 R fcvt.d.s
+	// 10100 fmt rs2   rs1 010 rd 1010011 R feq
+	// 10100 fmt rs2   rs1 001 rd 1010011 R flt
+	// 10100 fmt rs2   rs1 000 rd 1010011 R fle
+	// 11000 fmt 00000 rs1 rm  rd 1010011 R fcvt.w.fmt
+	// 11000 fmt 00001 rs1 rm  rd 1010011 R fcvt.wu.fmt
+	// 11000 fmt 00010 rs1 rm  rd 1010011 R fcvt.l.fmt (RV64)
+	// 11000 fmt 00011 rs1 rm  rd 1010011 R fcvt.lu.fmt (RV64)
+	// 11010 fmt 00000 rs1 rm  rd 1010011 R fcvt.fmt.w
+	// 11010 fmt 00001 rs1 rm  rd 1010011 R fcvt.fmt.wu
+	// 11010 fmt 00010 rs1 rm  rd 1010011 R fcvt.fmt.l (RV64)
+	// 11010 fmt 00011 rs1 rm  rd 1010011 R fcvt.fmt.lu (RV64)
+	// 11100 00  00000 rs1 000 rd 1010011 R fmv.x.w
+	// 11100 01  00000 rs1 000 rd 1010011 R fmv.x.d (RV64)
+	// 11100 fmt 00000 rs1 001 rd 1010011 R fclass
+	// 11110 00  00000 rs1 000 rd 1010011 R fmv.w.x
+	// 11110 01  00000 rs1 000 rd 1010011 R fmv.d.x (RV64)
+	rd := (ins & insRdMask) >> 7
+	funct3 := (ins & insFunct3Mask) >> 12
+	rs1 := (ins & insRs1Mask) >> 15
+	rs2 := (ins & insRs2Mask) >> 20
+	funct5 := ins >> 27
+	fmt := (ins >> 25) & 3
+
+	f, ok := rv32.floatFormatOf(fmt)
+	if !ok {
+		return rv32.illegalInstruction(ins)
+	}
+
+	// Instructions that use the rm field
+	rm := uint32(0)
+	switch funct5 {
+	case 0b00000, 0b00001, 0b00010, 0b00011, 0b01011, 0b01000, 0b11000, 0b11010:
+		rm, ok = rv32.roundingMode(funct3)
+		if !ok {
+			return rv32.illegalInstruction(ins)
+		}
+	}
+
+	a := rv32.getFloat(f, rs1)
+	b := rv32.getFloat(f, rs2)
+	result := uint64(0)
+	flags := uint32(0)
+
+	switch funct5 {
+	case 0b00000:
+		result, flags = f.add(a, b, rm)
+	case 0b00001:
+		result, flags = f.sub(a, b, rm)
+	case 0b00010:
+		result, flags = f.mul(a, b, rm)
+	case 0b00011:
+		result, flags = f.div(a, b, rm)
+	case 0b01011:
+		if rs2 != 0 {
+			return rv32.illegalInstruction(ins)
+		}
+		result, flags = f.sqrt(a, rm)
+	case 0b00100:
+		sign := f.signBit()
+		switch funct3 {
+		case 0:
+			result = (a &^ sign) | (b & sign)
+		case 1:
+			result = (a &^ sign) | (^b & sign)
+		case 2:
+			result = a ^ (b & sign)
+		default:
+			return rv32.illegalInstruction(ins)
+		}
+	case 0b00101:
+		if funct3 > 1 {
+			return rv32.illegalInstruction(ins)
+		}
+		result, flags = f.minMax(a, b, funct3 == 1)
+	case 0b01000:
+		switch {
+		case fmt == fmtSingle && rs2 == fmtDouble && rv32.isa.Has(MISAExtD):
+			result, flags = f.convert(rv32.getFloat(float64Format, rs1), float64Format, rm)
+		case fmt == fmtDouble && rs2 == fmtSingle:
+			result, flags = f.convert(rv32.getFloat(float32Format, rs1), float32Format, rm)
+		default:
+			return rv32.illegalInstruction(ins)
+		}
+	case 0b10100:
+		eq, lt, le, cmpFlags := f.compare(a, b, funct3 != 2)
+		res := false
+		switch funct3 {
+		case 0:
+			res = le
+		case 1:
+			res = lt
+		case 2:
+			res = eq
+		default:
+			return rv32.illegalInstruction(ins)
+		}
+		rdVal := uint64(0)
+		if res {
+			rdVal = 1
+		}
+		rv32.setInteger(rd, rdVal)
+		rv32.raiseFloatFlags(cmpFlags)
+		return nil
+	case 0b11000:
+		if rs2 > 3 || (rs2 > 1 && rv32.isa.XLEN != 64) {
+			return rv32.illegalInstruction(ins)
+		}
+		width := uint(32)
+		if rs2 > 1 {
+			width = 64
+		}
+		value, cvtFlags := f.toInt(a, rs2&1 == 0, width, rm)
+		rv32.setInteger(rd, value)
+		rv32.raiseFloatFlags(cvtFlags)
+		return nil
+	case 0b11010:
+		rs1Val := rv32.Registers.GetInteger(rs1)
+		switch {
+		case rs2 == 0:
+			result, flags = f.fromInt(uint64(int32(rs1Val)), true, rm)
+		case rs2 == 1:
+			result, flags = f.fromInt(uint64(uint32(rs1Val)), false, rm)
+		case rs2 == 2 && rv32.isa.XLEN == 64:
+			result, flags = f.fromInt(rs1Val, true, rm)
+		case rs2 == 3 && rv32.isa.XLEN == 64:
+			result, flags = f.fromInt(rs1Val, false, rm)
+		default:
+			return rv32.illegalInstruction(ins)
+		}
+	case 0b11100:
+		if rs2 != 0 {
+			return rv32.illegalInstruction(ins)
+		}
+		switch {
+		case funct3 == 0 && fmt == fmtSingle: // Raw bits, no NaN-boxing check
+			rv32.setInteger(rd, signExtend64(rv32.Registers.GetFloatBits(rs1)&0xFFFFFFFF, 32))
+		case funct3 == 0 && fmt == fmtDouble && rv32.isa.XLEN == 64:
+			rv32.setInteger(rd, rv32.Registers.GetFloatBits(rs1))
+		case funct3 == 1:
+			rv32.setInteger(rd, uint64(f.class(a)))
+		default:
+			return rv32.illegalInstruction(ins)
+		}
+		return nil
+	case 0b11110:
+		if rs2 != 0 || funct3 != 0 || (fmt != fmtSingle && rv32.isa.XLEN != 64) {
+			return rv32.illegalInstruction(ins)
+		}
+		result = rv32.Registers.GetInteger(rs1)
+		if fmt == fmtSingle {
+			result &= 0xFFFFFFFF
+		}
+	default:
+		return rv32.illegalInstruction(ins)
+	}
+
+	rv32.setFloat(f, rd, result)
+	rv32.raiseFloatFlags(flags)
+	return nil
+}
@@ -29,8 +29,7 @@ const insImmTypeJ2 = 0x00_0F_F8_00
 const insImmTypeJ3 = 0x80_00_00_00
 
 func (rv32 *RISCV) runInstruction(ctx context.Context, ins uint32) error {
-	//rv32.log.Debugf("DISM: %s", disasm.Disasm(rv32.pc-4, ins))
-	var err error
+	//rv32.log.Debugf("DISM: %s", disasm.Disasm(rv32.insPC, ins))
 	// Splice the instruction
 	opcode := ins & insOpcodeMask
 	rd := (ins & insRdMask) >> 7
@@ -39,35 +38,17 @@ func (rv32 *RISCV) runInstruction(ctx context.Context, ins uint32) error {
 	rs2 := (ins & insRs2Mask) >> 20
 	funct7 := (ins & insFunct7Mask) >> 25
 
+	if rv32.isa.Embedded && usesUpperRegisters(ins) { // RV32E
+		return rv32.illegalInstruction(ins)
+	}
+
 	immTypeI := (ins & insImmTypeI) >> 20
-	immTypeS := ((ins & insImmTypeS0) >> 7) + ((ins & insImmTypeS1) >> 20)
-	immTypeB := ((ins & insImmTypeB0) >> 7) + ((ins & insImmTypeB1) >> 20) + ((ins & insImmTypeB2) << 4) + ((ins & insImmTypeB3) >> 19)
-	immTypeU := ins & insImmTypeU
-	immTypeJ := ((ins & insImmTypeJ0) >> 20) + ((ins & insImmTypeJ1) >> 9) + (ins & insImmTypeJ2) + ((ins & insImmTypeJ3) >> 11)
 
 	rs1Val := rv32.Registers.GetInteger(rs1)
 	rs2Val := rv32.Registers.GetInteger(rs2)
 	rdVal := rv32.Registers.GetInteger(rd)
 
-	imm := uint32(0)
-
-	// Normalize IMM Value to 32 bit
-	switch opcode {
-	case 0b0010011, 0b1100111, 0b0000011: // Type I
-		if funct3 == 0b001 || funct3 == 0b101 {
-			imm = immTypeI
-		} else { // Sign Extend
-			imm = uint32(signExtend(immTypeI, 12))
-		}
-	case 0b0100011: // Type S instructions
-		imm = uint32(signExtend(immTypeS, 12))
-	case 0b1100011: // Type B instructions
-		imm = uint32(signExtend(immTypeB, 13))
-	case 0b0010111, 0b0110111: // Type U instructions
-		imm = immTypeU
-	case 0b1101111: // Type J instructions
-		imm = uint32(signExtend(immTypeJ, 20))
-	}
+	imm := immediate(ins)
 
 	if opcode == 0b0010011 { // addi, slti, sltiu, xori, ori, andi, slli, srli, srai
 		// imm[11:0]     rs1 000 rd 0010011 I addi
@@ -79,33 +60,38 @@ func (rv32 *RISCV) runInstruction(ctx context.Context, ins uint32) error {
 		// 0100000 shamt rs1 101 rd 0010011 I srai
 		// imm[11:0]     rs1 110 rd 0010011 I ori
 		// imm[11:0]     rs1 111 rd 0010011 I andi
+		// In RV64 shamt is 6 bits wide
 		aluOp := aluINVALID
 
 		switch funct3 {
 		case 0: // addi
 			aluOp = aluADD
-		case 1: // Shift Left Unsigned
-			aluOp = aluShiftLeftUnsigned
+		case 1, 5: // Shifts and the Zbb / Zbs immediate operations
+			aluOp = rv32.shiftImmediateOp(funct3, immTypeI)
 		case 2: // LesserThanSigned;
 			aluOp = aluLesserThanSigned
 		case 3: // LesserThanUnsigned
 			aluOp = aluLesserThanUnsigned
 		case 4: // XOR;
 			aluOp = aluXOR
-		case 5: // funct7[5] ? ShiftRightSigned : ShiftRightUnsigned;
-			aluOp = aluShiftRightUnsigned
-			if funct7&0x20 > 0 {
-				aluOp = aluShiftRightSigned
-			}
-			imm &= 0x1F
 		case 6: // OR;
 			aluOp = aluOR
 		case 7: // AND;
 			aluOp = aluAND
 		}
 
-		rdVal = rv32.alu(aluOp, rs1Val, imm)
-		rv32.Registers.SetInteger(rd, rdVal)
+		if aluOp == aluINVALID {
+			return rv32.illegalInstruction(ins)
+		}
+		if funct3 == 1 || funct3 == 5 {
+			if rv32.isa.XLEN == 32 && imm&0x20 != 0 { // shamt[5] is reserved in RV32
+				return rv32.illegalInstruction(ins)
+			}
+			imm &= uint64(rv32.isa.XLEN - 1)
+		}
+
+		rdVal = rv32.aluX(aluOp, rs1Val, imm)
+		rv32.setInteger(rd, rdVal)
 		return nil
 	}
 
@@ -120,40 +106,57 @@ func (rv32 *RISCV) runInstruction(ctx context.Context, ins uint32) error {
 		//0100000 rs2 rs1 101 rd 0110011 R sra
 		//0000000 rs2 rs1 110 rd 0110011 R or
 		//0000000 rs2 rs1 111 rd 0110011 R and
-		if funct7&^32 != 0 {
-			return fmt.Errorf("invalid instruction %08x at pc = %08x", ins, rv32.pc-4)
+		if funct7 == 0b0000001 && rv32.isa.Has(MISAExtM) { // RV32M
+			return rv32.runMulDiv(rd, funct3, rs1Val, rs2Val)
 		}
 		aluOp := aluINVALID
-		switch funct3 {
-		case 0: // add / sub
-			aluOp = aluADD
-			if funct7&0x20 > 0 { // SUB
-				aluOp = aluSUB
+		switch {
+		case funct7 == 0:
+			switch funct3 {
+			case 0: // add
+				aluOp = aluADD
+			case 1: // Shift Left Unsigned
+				aluOp = aluShiftLeftUnsigned
+				rs2Val &= uint64(rv32.isa.XLEN - 1)
+			case 2: // LesserThanSigned;
+				aluOp = aluLesserThanSigned
+			case 3: // LesserThanUnsigned
+				aluOp = aluLesserThanUnsigned
+			case 4: // XOR;
+				aluOp = aluXOR
+			case 5: // ShiftRightUnsigned
+				aluOp = aluShiftRightUnsigned
+				rs2Val &= uint64(rv32.isa.XLEN - 1)
+			case 6: // OR;
+				aluOp = aluOR
+			case 7: // AND;
+				aluOp = aluAND
 			}
-		case 1: // Shift Left Unsigned
-			aluOp = aluShiftLeftUnsigned
-		case 2: // LesserThanSigned;
-			aluOp = aluLesserThanSigned
-		case 3: // LesserThanUnsigned
-			aluOp = aluLesserThanUnsigned
-		case 4: // XOR;
-			aluOp = aluXOR
-		case 5: // funct7[5] ? ShiftRightSigned : ShiftRightUnsigned;
-			aluOp = aluShiftRightUnsigned
-			if funct7&0x10 > 0 {
-				aluOp = aluShiftRightSigned
-			}
-		case 6: // OR;
-			aluOp = aluOR
-		case 7: // AND;
-			aluOp = aluAND
+		case funct7 == 0b0100000 && funct3 == 0: // sub
+			aluOp = aluSUB
+		case funct7 == 0b0100000 && funct3 == 5: // ShiftRightSigned
+			aluOp = aluShiftRightSigned
+			rs2Val &= uint64(rv32.isa.XLEN - 1)
+		default: // Zba / Zbb / Zbs
+			aluOp = rv32.bitManipOp(funct7, funct3, rs2)
+		}
+
+		if aluOp == aluINVALID {
+			return rv32.illegalInstruction(ins)
 		}
 
-		rdVal = rv32.alu(aluOp, rs1Val, rs2Val)
-		rv32.Registers.SetInteger(rd, rdVal)
+		rdVal = rv32.aluX(aluOp, rs1Val, rs2Val)
+		rv32.setInteger(rd, rdVal)
 		return nil
 	}
 
+	if opcode == 0b0011011 || opcode == 0b0111011 { // RV64 addiw, slliw, srliw, sraiw, addw, subw, sllw, srlw, sraw
+		if rv32.isa.XLEN != 64 {
+			return rv32.illegalInstruction(ins)
+		}
+		return rv32.runWord(ins, opcode, rd, funct3, funct7, rs1Val, rs2Val, imm)
+	}
+
 	if opcode == 0b1100011 { // beq, bne, blt, bge, bltu, bgeu
 		aluOp := aluINVALID
 		switch funct3 {
@@ -162,7 +165,7 @@ func (rv32 *RISCV) runInstruction(ctx context.Context, ins uint32) error {
 		case 1:
 			aluOp = aluNotEqual
 		case 2, 3:
-			return fmt.Errorf("invalid instruction %08x at pc = %08x", ins, rv32.pc-4)
+			return rv32.illegalInstruction(ins)
 		case 4:
 			aluOp = aluLesserThanSigned
 		case 5:
@@ -173,114 +176,395 @@ func (rv32 *RISCV) runInstruction(ctx context.Context, ins uint32) error {
 			aluOp = aluGreaterThanOrEqualUnsigned
 		}
 
-		res := rv32.alu(aluOp, rs1Val, rs2Val)
+		res := rv32.aluX(aluOp, rs1Val, rs2Val)
 		if res == 1 { // Branch
-			rv32.AddPC(int32(imm) - 4)
+			target := (rv32.insPC + imm) & rv32.xlenMask
+			if target&rv32.ialignMask != 0 {
+				return rv32.misalignedJump(target)
+			}
+			rv32.SetPC(target)
+			rv32.countEvent(HPMEventTakenBranch)
 		}
 		return nil
 	}
 
 	if opcode == 0b0010111 { // auipc
-		rdVal = rv32.alu(aluADD, rv32.GetPC()-4, imm)
-		rv32.Registers.SetInteger(rd, rdVal)
+		rdVal = rv32.aluX(aluADD, rv32.insPC, imm)
+		rv32.setInteger(rd, rdVal)
 		return nil
 	}
 
 	if opcode == 0b0110111 { // lui
-		rv32.Registers.SetInteger(rd, imm)
+		rv32.setInteger(rd, imm)
 		return nil
 	}
 
 	if opcode == 0b1101111 { // jal
 		t := rv32.GetPC()
-		rv32.Registers.SetInteger(rd, t)
-		rv32.AddPC(int32(imm) - 4)
+		target := (rv32.insPC + imm) & rv32.xlenMask
+		if target&rv32.ialignMask != 0 {
+			return rv32.misalignedJump(target)
+		}
+		rv32.setInteger(rd, t)
+		rv32.SetPC(target)
 		return nil
 	}
 
 	if opcode == 0b1100111 { // jalr
 		t := rv32.GetPC()
-		rv32.Registers.SetInteger(rd, t)
-		newPC := rv32.alu(aluADD, rs1Val, imm) &^ uint32(1)
+		newPC := rv32.aluX(aluADD, rs1Val, imm) &^ uint64(1)
+		if newPC&rv32.ialignMask != 0 {
+			return rv32.misalignedJump(newPC)
+		}
+		rv32.setInteger(rd, t)
 		rv32.SetPC(newPC)
 		return nil
 	}
 
-	if opcode == 0b0000011 { // lb, lh, lw, lbu, lhu
+	if opcode == 0b0000011 { // lb, lh, lw, lbu, lhu, (RV64) ld, lwu
 		numBytes := funct3 & 3
-		data := uint32(0)
 
-		if funct3 > 6 {
-			return fmt.Errorf("invalid instruction %08x at pc = %08x", ins, rv32.pc-4)
+		if funct3 == 7 || (rv32.isa.XLEN == 32 && (funct3 == 3 || funct3 == 6)) {
+			return rv32.illegalInstruction(ins)
 		}
 
-		addr := rv32.alu(aluADD, rs1Val, imm)
-
-		switch numBytes {
-		case 0:
-			b, err := rv32.Bus.ReadByte(ctx, addr)
-			if err != nil {
-				return fmt.Errorf("bus error at %08x: %s", rv32.pc-4, err)
-			}
-			data = uint32(b)
-			if funct3&4 == 0 { // Sign Extend
-				data = uint32(signExtend(data, 8))
-			}
-		case 1:
-			b, err := rv32.Bus.ReadShort(ctx, addr)
-			if err != nil {
-				return fmt.Errorf("bus error at %08x: %s", rv32.pc-4, err)
-			}
-			data = uint32(b)
-			if funct3&4 == 0 { // Sign Extend
-				data = uint32(signExtend(data, 16))
-			}
-		case 2:
-			b, err := rv32.Bus.ReadWord(ctx, addr)
-			if err != nil {
-				return fmt.Errorf("bus error at %08x: %s", rv32.pc-4, err)
-			}
-			data = b
+		addr := rv32.aluX(aluADD, rs1Val, imm)
+		data, err := rv32.load(ctx, addr, 1<<numBytes)
+		if err != nil {
+			return err
+		}
+		if funct3&4 == 0 && numBytes < 3 { // Sign Extend
+			data = signExtend64(data, uint(8<<numBytes))
 		}
 
-		rv32.Registers.SetInteger(rd, data)
+		rv32.setInteger(rd, data)
+		rv32.countEvent(HPMEventLoad)
 		return nil
 	}
 
-	if opcode == 0b0100011 { // sw, sh, sb
+	if opcode == 0b0100011 { // sw, sh, sb, (RV64) sd
 		numBytes := funct3 & 3
 
-		if funct3 > 3 {
-			return fmt.Errorf("invalid instruction %08x at pc = %08x", ins, rv32.pc-4)
+		if funct3 > 3 || (rv32.isa.XLEN == 32 && funct3 == 3) {
+			return rv32.illegalInstruction(ins)
+		}
+
+		addr := rv32.aluX(aluADD, rs1Val, imm)
+		if err := rv32.store(ctx, addr, 1<<numBytes, rs2Val); err != nil {
+			return err
+		}
+		rv32.countEvent(HPMEventStore)
+		return nil
+	}
+
+	if opcode == 0b0101111 && rv32.isa.Has(MISAExtA) { // RV32A
+		return rv32.runAtomic(ctx, ins, rd, funct3, funct7, rs1Val, rs2Val)
+	}
+
+	switch opcode {
+	case 0b0000111, 0b0100111, 0b1000011, 0b1000111, 0b1001011, 0b1001111, 0b1010011: // RV32F / RV32D
+		return rv32.runFloat(ctx, ins)
+	}
+
+	if opcode == 0b0001111 { // fence, fence.tso, fence.i
+		return rv32.runFence(ins, funct3)
+	}
+
+	if opcode == 0b1110011 {
+		if funct3 == 0 {
+			return rv32.runSystem(ins, rs1Val, rs2Val)
+		}
+		if !rv32.isa.HasZ(ExtZicsr) {
+			return rv32.illegalInstruction(ins)
+		}
+		return rv32.runCSR(ins, rd, funct3, rs1, rs1Val, immTypeI)
+	}
+
+	return rv32.illegalInstruction(ins)
+}
+
+// immediate returns the immediate of the instruction sign extended to 64 bits, or 0 for formats without one
+// The immediate of the OP-IMM shifts (funct3 001 / 101) holds funct6 / shamt, so it is not sign extended
+func immediate(ins uint32) uint64 {
+	funct3 := (ins & insFunct3Mask) >> 12
+	immTypeI := (ins & insImmTypeI) >> 20
+
+	switch ins & insOpcodeMask {
+	case 0b0010011, 0b0011011: // Type I with shifts
+		if funct3 == 0b001 || funct3 == 0b101 {
+			return uint64(immTypeI)
+		}
+		return uint64(int64(signExtend(immTypeI, 12)))
+	case 0b1100111, 0b0000011: // Type I
+		return uint64(int64(signExtend(immTypeI, 12)))
+	case 0b0100011: // Type S instructions
+		immTypeS := ((ins & insImmTypeS0) >> 7) + ((ins & insImmTypeS1) >> 20)
+		return uint64(int64(signExtend(immTypeS, 12)))
+	case 0b1100011: // Type B instructions
+		immTypeB := ((ins & insImmTypeB0) >> 7) + ((ins & insImmTypeB1) >> 20) + ((ins & insImmTypeB2) << 4) + ((ins & insImmTypeB3) >> 19)
+		return uint64(int64(signExtend(immTypeB, 13)))
+	case 0b0010111, 0b0110111: // Type U instructions
+		return uint64(int64(int32(ins & insImmTypeU)))
+	case 0b1101111: // Type J instructions
+		immTypeJ := ((ins & insImmTypeJ0) >> 20) + ((ins & insImmTypeJ1) >> 9) + (ins & insImmTypeJ2) + ((ins & insImmTypeJ3) >> 11)
+		return uint64(int64(signExtend(immTypeJ, 20)))
+	}
+	return 0
+}
+
+// usesUpperRegisters returns true if the instruction references any of the integer registers x16-x31
+// Only the fields that are integer registers in the instruction format are checked
+func usesUpperRegisters(ins uint32) bool {
+	rd := (ins & insRdMask) >> 7
+	funct3 := (ins & insFunct3Mask) >> 12
+	rs1 := (ins & insRs1Mask) >> 15
+	rs2 := (ins & insRs2Mask) >> 20
+
+	regs := uint32(0)
+	switch ins & insOpcodeMask {
+	case 0b0110011, 0b0111011, 0b0101111: // Type R
+		regs = rd | rs1 | rs2
+	case 0b0010011, 0b0011011, 0b0000011, 0b1100111: // Type I
+		regs = rd | rs1
+	case 0b0100011, 0b1100011: // Type S / B
+		regs = rs1 | rs2
+	case 0b0110111, 0b0010111, 0b1101111: // Type U / J
+		regs = rd
+	case 0b0000111, 0b0100111: // Float load / store base address
+		regs = rs1
+	case 0b1010011: // OP-FP moves / conversions / compares between integer and float registers
+		switch ins >> 27 {
+		case 0b10100, 0b11000, 0b11100:
+			regs = rd
+		case 0b11010, 0b11110:
+			regs = rs1
+		}
+	case 0b1110011:
+		regs = rd
+		if funct3&4 == 0 { // Immediate CSR instructions uses rs1 as an unsigned immediate
+			regs |= rs1
+		}
+		if funct3 == 0 {
+			regs |= rs2
+		}
+	}
+
+	return regs&0x10 != 0
+}
+
+// runWord runs the RV64 instructions that operate on the lower 32 bits and sign extend the result
+func (rv32 *RISCV) runWord(ins, opcode, rd, funct3, funct7 uint32, rs1Val, rs2Val, imm uint64) error {
+	// imm[11:0]     rs1 000 rd 0011011 I addiw
+	// 0000000 shamt rs1 001 rd 0011011 I slliw
+	// 0000000 shamt rs1 101 rd 0011011 I srliw
+	// 0100000 shamt rs1 101 rd 0011011 I sraiw
+	// 0000000 rs2   rs1 000 rd 0111011 R addw
+	// 0100000 rs2   rs1 000 rd 0111011 R subw
+	// 0000000 rs2   rs1 001 rd 0111011 R sllw
+	// 0000000 rs2   rs1 101 rd 0111011 R srlw
+	// 0100000 rs2   rs1 101 rd 0111011 R sraw
+	// 0000001 rs2   rs1 000 rd 0111011 R mulw
+	// 0000001 rs2   rs1 100 rd 0111011 R divw
+	// 0000001 rs2   rs1 101 rd 0111011 R divuw
+	// 0000001 rs2   rs1 110 rd 0111011 R remw
+	// 0000001 rs2   rs1 111 rd 0111011 R remuw
+	// 0110000 00000 rs1 001 rd 0011011 I clzw  (Zbb)
+	// 0110000 00001 rs1 001 rd 0011011 I ctzw  (Zbb)
+	// 0110000 00010 rs1 001 rd 0011011 I cpopw (Zbb)
+	// 0110000 shamt rs1 101 rd 0011011 I roriw (Zbb)
+	// 0110000 rs2   rs1 001 rd 0111011 R rolw  (Zbb)
+	// 0110000 rs2   rs1 101 rd 0111011 R rorw  (Zbb)
+	rs2 := (ins & insRs2Mask) >> 20
+	if rv32.runUnsignedWord(opcode, rd, funct3, funct7, rs2, rs1Val, rs2Val, imm) {
+		return nil
+	}
+
+	x := uint32(rs1Val)
+	y := uint32(rs2Val)
+	if opcode == 0b0011011 {
+		y = uint32(imm)
+		if funct3 == 0 { // addiw has no funct7
+			funct7 = 0
 		}
+	}
 
-		addr := rv32.alu(aluADD, rs1Val, imm)
-		switch numBytes {
+	aluOp := aluINVALID
+	switch {
+	case funct7 == 0b0000001 && opcode == 0b0111011 && rv32.isa.Has(MISAExtM): // RV64M
+		switch funct3 {
 		case 0:
-			err = rv32.Bus.WriteByte(ctx, addr, byte(rs2Val&0xFF))
-		case 1:
-			err = rv32.Bus.WriteShort(ctx, addr, uint16(rs2Val&0xFFFF))
-		case 2:
-			err = rv32.Bus.WriteWord(ctx, addr, rs2Val)
+			aluOp = aluMUL
+		case 4:
+			aluOp = aluDIV
+		case 5:
+			aluOp = aluDIVU
+		case 6:
+			aluOp = aluREM
+		case 7:
+			aluOp = aluREMU
 		}
-		if err != nil {
-			err = fmt.Errorf("bus error at %08x: %s", rv32.pc-4, err)
+	case funct3 == 0 && funct7 == 0:
+		aluOp = aluADD
+	case funct3 == 0 && funct7 == 0b0100000 && opcode == 0b0111011:
+		aluOp = aluSUB
+	case funct3 == 1 && funct7 == 0:
+		aluOp = aluShiftLeftUnsigned
+	case funct3 == 5 && funct7 == 0:
+		aluOp = aluShiftRightUnsigned
+	case funct3 == 5 && funct7 == 0b0100000:
+		aluOp = aluShiftRightSigned
+	case funct7 == 0b0110000 && rv32.isa.HasZ(ExtZbb):
+		switch {
+		case funct3 == 5: // rorw / roriw
+			aluOp = aluROR
+		case funct3 == 1 && opcode == 0b0111011:
+			aluOp = aluROL
+		case funct3 == 1 && rs2 == 0:
+			aluOp = aluCLZ
+		case funct3 == 1 && rs2 == 1:
+			aluOp = aluCTZ
+		case funct3 == 1 && rs2 == 2:
+			aluOp = aluCPOP
 		}
-		return err
 	}
 
-	if opcode == 0b1110011 {
-		if funct3 == 0 { // ecall / ebreak
-			rv32.log.Infof("ecall/ebreak not implemented at pc = %08x", rv32.pc-4)
-			// TODO
-			return nil
+	if aluOp == aluINVALID {
+		return rv32.illegalInstruction(ins)
+	}
+	switch aluOp {
+	case aluShiftLeftUnsigned, aluShiftRightUnsigned, aluShiftRightSigned:
+		y &= 0x1F
+	}
+
+	rv32.setInteger(rd, signExtend64(uint64(rv32.alu(aluOp, x, y)), 32))
+	return nil
+}
+
+// runMulDiv runs the RV32M instructions (mul, mulh, mulhsu, mulhu, div, divu, rem, remu)
+func (rv32 *RISCV) runMulDiv(rd, funct3 uint32, rs1Val, rs2Val uint64) error {
+	//0000001 rs2 rs1 000 rd 0110011 R mul
+	//0000001 rs2 rs1 001 rd 0110011 R mulh
+	//0000001 rs2 rs1 010 rd 0110011 R mulhsu
+	//0000001 rs2 rs1 011 rd 0110011 R mulhu
+	//0000001 rs2 rs1 100 rd 0110011 R div
+	//0000001 rs2 rs1 101 rd 0110011 R divu
+	//0000001 rs2 rs1 110 rd 0110011 R rem
+	//0000001 rs2 rs1 111 rd 0110011 R remu
+	aluOp := aluINVALID
+	switch funct3 {
+	case 0:
+		aluOp = aluMUL
+	case 1:
+		aluOp = aluMULH
+	case 2:
+		aluOp = aluMULHSU
+	case 3:
+		aluOp = aluMULHU
+	case 4:
+		aluOp = aluDIV
+	case 5:
+		aluOp = aluDIVU
+	case 6:
+		aluOp = aluREM
+	case 7:
+		aluOp = aluREMU
+	}
+
+	rv32.setInteger(rd, rv32.aluX(aluOp, rs1Val, rs2Val))
+	return nil
+}
+
+// runSystem runs the privileged instructions (ecall, ebreak, mret, sret, wfi, sfence.vma)
+func (rv32 *RISCV) runSystem(ins uint32, rs1Val, rs2Val uint64) error {
+	if ins&0xFE007FFF == 0x12000073 { // sfence.vma
+		return rv32.sfenceVMA(ins, rs1Val, rs2Val)
+	}
+
+	switch ins {
+	case 0x00000073: // ecall
+		return rv32.exception(ExceptionEnvironmentCallFromUMode+uint32(rv32.priv), 0, "ecall at pc = %08x", rv32.insPC)
+	case 0x00100073: // ebreak
+		return rv32.exception(ExceptionBreakpoint, rv32.insPC, "ebreak at pc = %08x", rv32.insPC)
+	case 0x30200073: // mret
+		return rv32.mret(ins)
+	case 0x10200073: // sret
+		return rv32.sret(ins)
+	case 0x10500073: // wfi
+		return rv32.wfi()
+	}
+	return rv32.illegalInstruction(ins)
+}
+
+// runFence runs the MISC-MEM instructions (fence, fence.tso, fence.i)
+func (rv32 *RISCV) runFence(ins, funct3 uint32) error {
+	// fm pred succ rs1 000 rd 0001111 I fence / fence.tso
+	// imm[11:0]    rs1 001 rd 0001111 I fence.i
+	// The core runs a single hart in program order, so fence has nothing to order. Reserved fm, rs1 and rd
+	// values are ignored, as required for fences. The same applies to the imm, rs1 and rd fields of fence.i
+	switch funct3 {
+	case 0b000: // fence, fence.tso
+		return nil
+	case 0b001: // fence.i
+		if !rv32.isa.HasZ(ExtZifencei) {
+			return rv32.illegalInstruction(ins)
 		}
-		// CSR
-		rv32.log.Infof("CSR not implemented at pc = %08x", rv32.pc-4)
+		rv32.FlushInstructionCache()
 		return nil
 	}
+	return rv32.illegalInstruction(ins)
+}
+
+// misalignedJump creates an instruction address misaligned exception for a jump / branch to target
+func (rv32 *RISCV) misalignedJump(target uint64) error {
+	return rv32.exception(ExceptionInstructionAddressMisaligned, target, "misaligned jump to %08x at pc = %08x", target, rv32.insPC)
+}
+
+// runCSR runs the Zicsr instructions (csrrw, csrrs, csrrc, csrrwi, csrrsi, csrrci)
+func (rv32 *RISCV) runCSR(ins, rd, funct3, rs1 uint32, rs1Val uint64, csr uint32) error {
+	// csr rs1   001 rd 1110011 I csrrw
+	// csr rs1   010 rd 1110011 I csrrs
+	// csr rs1   011 rd 1110011 I csrrc
+	// csr uimm  101 rd 1110011 I csrrwi
+	// csr uimm  110 rd 1110011 I csrrsi
+	// csr uimm  111 rd 1110011 I csrrci
+	if funct3 == 4 {
+		return rv32.illegalInstruction(ins)
+	}
+
+	value := rs1Val
+	if funct3&4 > 0 { // Immediate versions uses rs1 field as unsigned immediate
+		value = uint64(rs1)
+	}
+
+	op := funct3 & 3
+	// csrrw does not read the CSR if rd == x0, csrrs / csrrc does not write if rs1 == x0
+	doRead := op != 1 || rd != 0
+	doWrite := op == 1 || rs1 != 0
+
+	old := uint64(0)
+	if doRead {
+		v, err := rv32.CSR.Read(csr, rv32.priv)
+		if err != nil {
+			return rv32.exception(ExceptionIllegalInstruction, uint64(ins), "invalid instruction %08x at pc = %08x: %s", ins, rv32.insPC, err)
+		}
+		old = v
+	}
+
+	if doWrite {
+		switch op {
+		case 2: // Set bits
+			value = old | value
+		case 3: // Clear bits
+			value = old &^ value
+		}
+		err := rv32.CSR.Write(csr, value, rv32.priv)
+		if err != nil {
+			return rv32.exception(ExceptionIllegalInstruction, uint64(ins), "invalid instruction %08x at pc = %08x: %s", ins, rv32.insPC, err)
+		}
+	}
 
-	return fmt.Errorf("invalid instruction %08x at pc = %08x", ins, rv32.pc-4)
+	rv32.setInteger(rd, old)
+	return nil
 }
 
 func Addr2Line(addr uint32) string {
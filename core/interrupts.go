@@ -0,0 +1,233 @@
+package core
+
+import "sync/atomic"
+
+// Interrupt codes (mcause values when interrupt bit is set, and bit position in mip / mie)
+const (
+	InterruptSupervisorSoftware = 1
+	InterruptMachineSoftware    = 3
+	InterruptSupervisorTimer    = 5
+	InterruptMachineTimer       = 7
+	InterruptSupervisorExternal = 9
+	InterruptMachineExternal    = 11
+)
+
+// Interrupt CSR addresses
+const (
+	CSRMIE = 0x304
+	CSRMIP = 0x344
+)
+
+// mie / mip bits
+const (
+	MIPSSIP = 1 << InterruptSupervisorSoftware
+	MIPMSIP = 1 << InterruptMachineSoftware
+	MIPSTIP = 1 << InterruptSupervisorTimer
+	MIPMTIP = 1 << InterruptMachineTimer
+	MIPSEIP = 1 << InterruptSupervisorExternal
+	MIPMEIP = 1 << InterruptMachineExternal
+
+	mipMachineMask    = MIPMSIP | MIPMTIP | MIPMEIP
+	mipSupervisorMask = MIPSSIP | MIPSTIP | MIPSEIP
+)
+
+// interruptPriority is the order in which simultaneous interrupts are taken
+var interruptPriority = []uint32{
+	InterruptMachineExternal,
+	InterruptMachineSoftware,
+	InterruptMachineTimer,
+	InterruptSupervisorExternal,
+	InterruptSupervisorSoftware,
+	InterruptSupervisorTimer,
+}
+
+// IRQLine is a level sensitive interrupt line that a device can assert or deassert
+type IRQLine interface {
+	// Set sets the level of the line. true means the interrupt is being requested
+	Set(level bool)
+}
+
+// IRQLineFunc is an adapter to allow the use of ordinary functions as IRQLine
+type IRQLineFunc func(level bool)
+
+// Set calls f(level)
+func (f IRQLineFunc) Set(level bool) {
+	f(level)
+}
+
+// InterruptLine returns an IRQLine that drives the pending bit of the specified interrupt in mip
+func (rv32 *RISCV) InterruptLine(interrupt uint32) IRQLine {
+	return IRQLineFunc(func(level bool) {
+		rv32.SetInterruptPending(interrupt, level)
+	})
+}
+
+// TickHandle is a handler called after every cycle of the core
+// Used by devices that need to be clocked by the core (like timers)
+type TickHandle func(cycle uint64)
+
+// AddTickHandler adds a handler that will be called after every cycle of the core
+func (rv32 *RISCV) AddTickHandler(handler TickHandle) {
+	rv32.tickHandlers = append(rv32.tickHandlers, handler)
+}
+
+// WakeupSource returns the cycle number at which a device clocked by the core will request an interrupt
+// Returns false if the device has no event scheduled, or if it cannot be predicted (like host time events)
+type WakeupSource func() (cycle uint64, ok bool)
+
+// AddWakeupSource adds a source of timed events used to fast forward the cycles while the core is waiting for an interrupt
+func (rv32 *RISCV) AddWakeupSource(source WakeupSource) {
+	rv32.wakeupSources = append(rv32.wakeupSources, source)
+}
+
+// SetIdleFastForward enables or disables skipping the idle cycles while waiting for an interrupt (WFI)
+// When enabled, the cycle counter jumps to the next event of the wakeup sources and the tick handlers
+// are called only once, with the cycle of the event
+func (rv32 *RISCV) SetIdleFastForward(enabled bool) {
+	rv32.idleFastForward = enabled
+}
+
+// Waiting returns true if the core is suspended by WFI, waiting for an interrupt
+func (rv32 *RISCV) Waiting() bool {
+	return rv32.waiting
+}
+
+// wfi suspends the core until an interrupt is pending
+func (rv32 *RISCV) wfi() error {
+	rv32.waiting = true
+	return nil
+}
+
+// wakeup resumes the core from WFI when any enabled interrupt is pending, even if interrupts are globally disabled
+// Returns false if the core is still waiting
+func (rv32 *RISCV) wakeup() bool {
+	if atomic.LoadUint32(&rv32.mip)&rv32.mie == 0 {
+		return false
+	}
+	rv32.waiting = false
+	return true
+}
+
+// fastForward skips the cycles until the cycle before the next event of the wakeup sources
+// Nothing is skipped when an interrupt is already pending or no event can be predicted
+func (rv32 *RISCV) fastForward() {
+	if atomic.LoadUint32(&rv32.mip)&rv32.mie > 0 {
+		return
+	}
+
+	next := uint64(0)
+	found := false
+	for _, source := range rv32.wakeupSources {
+		cycle, ok := source()
+		if ok && (!found || cycle < next) {
+			next = cycle
+			found = true
+		}
+	}
+	if !found || next <= rv32.cycleNum+1 {
+		return
+	}
+
+	skip := next - 1 - rv32.cycleNum
+	rv32.cycleNum += skip
+	if rv32.mcountinhibit&CounterCY == 0 {
+		rv32.mcycle += skip
+	}
+}
+
+// Cycles returns the number of cycles executed since the last reset
+func (rv32 *RISCV) Cycles() uint64 {
+	return rv32.cycleNum
+}
+
+// SetInterruptPending sets or clears the pending bit of the specified interrupt in mip
+// This is safe to be called from any goroutine
+func (rv32 *RISCV) SetInterruptPending(interrupt uint32, pending bool) {
+	bit := uint32(1) << interrupt
+	for {
+		old := atomic.LoadUint32(&rv32.mip)
+		v := old &^ bit
+		if pending {
+			v |= bit
+		}
+		if old == v || atomic.CompareAndSwapUint32(&rv32.mip, old, v) {
+			return
+		}
+	}
+}
+
+// InterruptPending returns true if the specified interrupt is pending in mip
+func (rv32 *RISCV) InterruptPending(interrupt uint32) bool {
+	return atomic.LoadUint32(&rv32.mip)&(1<<interrupt) > 0
+}
+
+// registerInterruptCSRs registers the CSRs used for interrupt handling
+func (rv32 *RISCV) registerInterruptCSRs() {
+	mieWriteMask := uint64(mipMachineMask)
+	mipWriteMask := uint64(0)
+	if rv32.isa.Has(MISAExtS) { // Machine mode software can request supervisor interrupts
+		mieWriteMask |= mipSupervisorMask
+		mipWriteMask |= mipSupervisorMask
+	}
+
+	csrs := map[uint32]CSR{
+		CSRMIE: {
+			Name:      "mie",
+			WriteMask: mieWriteMask,
+			RHandler:  func() uint64 { return uint64(rv32.mie) },
+			WHandler:  func(value uint64) { rv32.mie = uint32(value) },
+		},
+		CSRMIP: {
+			Name:      "mip",
+			WriteMask: mipWriteMask,
+			RHandler:  func() uint64 { return uint64(atomic.LoadUint32(&rv32.mip)) }, // Machine level bits are driven by devices
+			WHandler: func(value uint64) {
+				for _, interrupt := range []uint32{InterruptSupervisorSoftware, InterruptSupervisorTimer, InterruptSupervisorExternal} {
+					rv32.SetInterruptPending(interrupt, value&(1<<interrupt) > 0)
+				}
+			},
+		},
+	}
+
+	for address, csr := range csrs {
+		if err := rv32.CSR.Register(address, csr); err != nil {
+			rv32.log.Errorf("cannot register csr %s: %s", csr.Name, err)
+		}
+	}
+}
+
+// checkInterrupts takes the highest priority pending and enabled interrupt, if any
+// Interrupts handled by a more privileged mode than the current one are always enabled,
+// and the ones handled by a less privileged mode are never taken
+// Returns true if an interrupt trap was taken
+func (rv32 *RISCV) checkInterrupts() bool {
+	pending := atomic.LoadUint32(&rv32.mip) & rv32.mie
+	if pending == 0 {
+		return false
+	}
+
+	mstatus := rv32.CSR.Get(CSRMStatus)
+	machine := pending &^ rv32.mideleg
+	supervisor := pending & rv32.mideleg
+	if rv32.priv == PrivilegeMachine && mstatus&MStatusMIE == 0 {
+		machine = 0
+	}
+	if rv32.priv > PrivilegeSupervisor || (rv32.priv == PrivilegeSupervisor && mstatus&MStatusSIE == 0) {
+		supervisor = 0
+	}
+
+	pending = machine // Machine mode interrupts always have precedence
+	if pending == 0 {
+		pending = supervisor
+	}
+
+	for _, interrupt := range interruptPriority {
+		if pending&(1<<interrupt) > 0 {
+			rv32.log.Debugf("(RISCV) Interrupt %d at %08x", interrupt, rv32.pc)
+			rv32.takeTrap(rv32.pc, mcauseInterrupt|interrupt, 0)
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,167 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Multi letter Z extensions, as bits of ISA.Z
+const (
+	ExtZicsr    = 1 << iota
+	ExtZba      // Address generation (sh1add, add.uw, ...)
+	ExtZbb      // Basic bit manipulation (clz, cpop, rev8, ...)
+	ExtZbs      // Single bit instructions (bset, bclr, ...)
+	ExtZifencei // Instruction fetch fence (fence.i)
+)
+
+var zExtensions = map[string]uint32{
+	"zicsr":    ExtZicsr,
+	"zba":      ExtZba,
+	"zbb":      ExtZbb,
+	"zbs":      ExtZbs,
+	"zifencei": ExtZifencei,
+}
+
+// singleLetterExtensions are the supported single letter extensions in canonical order
+var singleLetterExtensions = []struct {
+	name rune
+	bit  uint32
+}{
+	{'m', MISAExtM},
+	{'a', MISAExtA},
+	{'f', MISAExtF},
+	{'d', MISAExtD},
+	{'c', MISAExtC},
+	{'s', MISAExtS},
+	{'u', MISAExtU},
+}
+
+// DefaultISA is the ISA used by CreateEmulator when no other is specified
+const DefaultISA = "rv32imafdc_zicsr_zifencei_zba_zbb_zbs"
+
+// ISA describes the base integer ISA and the extensions implemented by the core
+type ISA struct {
+	XLEN       int
+	Embedded   bool   // RV32E base, with only the registers x0-x15
+	Extensions uint32 // Single letter extensions as misa bits (MISAExtM, MISAExtA, ...)
+	Z          uint32 // Multi letter extensions (ExtZicsr, ...)
+}
+
+// ParseISA parses a ISA string like "rv32imac_zicsr" or "rv64gcsu"
+// G is expanded to IMAFD_Zicsr_Zifencei, and F implies Zicsr. Version numbers are not supported
+// The privilege modes are selected with the S (supervisor) and U (user) letters, machine mode is always implemented
+func ParseISA(s string) (ISA, error) {
+	isa := ISA{}
+	s = strings.ToLower(strings.TrimSpace(s))
+
+	switch {
+	case strings.HasPrefix(s, "rv32"):
+		isa.XLEN = 32
+	case strings.HasPrefix(s, "rv64"):
+		isa.XLEN = 64
+	default:
+		return isa, fmt.Errorf("invalid isa %q: expected rv32 or rv64", s)
+	}
+
+	parts := strings.Split(s[4:], "_")
+	base := parts[0]
+	if base == "" {
+		return isa, fmt.Errorf("invalid isa %q: missing base integer isa", s)
+	}
+
+	switch base[0] {
+	case 'i':
+	case 'e':
+		if isa.XLEN != 32 {
+			return isa, fmt.Errorf("invalid isa %q: E base is only supported in RV32", s)
+		}
+		isa.Embedded = true
+	case 'g':
+		isa.Extensions |= MISAExtM | MISAExtA | MISAExtF | MISAExtD
+		isa.Z |= ExtZicsr | ExtZifencei
+	default:
+		return isa, fmt.Errorf("invalid isa %q: unknown base integer isa %q", s, base[0])
+	}
+
+next:
+	for _, c := range base[1:] {
+		for _, ext := range singleLetterExtensions {
+			if ext.name == c {
+				isa.Extensions |= ext.bit
+				continue next
+			}
+		}
+		return isa, fmt.Errorf("invalid isa %q: unsupported extension %q", s, c)
+	}
+
+	for _, name := range parts[1:] {
+		ext, ok := zExtensions[name]
+		if !ok {
+			return isa, fmt.Errorf("invalid isa %q: unsupported extension %q", s, name)
+		}
+		isa.Z |= ext
+	}
+
+	if isa.Has(MISAExtD) && !isa.Has(MISAExtF) {
+		return isa, fmt.Errorf("invalid isa %q: D requires F", s)
+	}
+	if isa.Has(MISAExtS) && !isa.Has(MISAExtU) {
+		return isa, fmt.Errorf("invalid isa %q: S requires U", s)
+	}
+	if isa.Has(MISAExtF) || isa.Has(MISAExtU) { // The floating point and privilege mode CSRs needs Zicsr
+		isa.Z |= ExtZicsr
+	}
+
+	return isa, nil
+}
+
+// Has returns true if the single letter extension (misa bit) is implemented
+func (isa ISA) Has(ext uint32) bool {
+	return isa.Extensions&ext != 0
+}
+
+// HasZ returns true if the multi letter extension is implemented
+func (isa ISA) HasZ(ext uint32) bool {
+	return isa.Z&ext != 0
+}
+
+// MISA returns the misa CSR value for the ISA
+func (isa ISA) MISA() uint64 {
+	misa := uint64(misaMXL32)
+	if isa.XLEN == 64 {
+		misa = misaMXL64
+	}
+	if isa.Embedded {
+		misa |= MISAExtE
+	} else {
+		misa |= MISAExtI
+	}
+	return misa | uint64(isa.Extensions)
+}
+
+// String returns the ISA in canonical form (like rv32imac_zicsr)
+func (isa ISA) String() string {
+	s := fmt.Sprintf("rv%d", isa.XLEN)
+	if isa.Embedded {
+		s += "e"
+	} else {
+		s += "i"
+	}
+	for _, ext := range singleLetterExtensions {
+		if isa.Has(ext.bit) {
+			s += string(ext.name)
+		}
+	}
+	var z []string
+	for name, ext := range zExtensions {
+		if isa.HasZ(ext) {
+			z = append(z, name)
+		}
+	}
+	sort.Strings(z)
+	for _, name := range z {
+		s += "_" + name
+	}
+	return s
+}
@@ -0,0 +1,55 @@
+package core
+
+import "testing"
+
+func TestParseISA(t *testing.T) {
+	tests := []struct {
+		isa       string
+		canonical string
+		misa      uint64
+	}{
+		{"rv32i", "rv32i", 0x40000100},
+		{"rv32e", "rv32e", 0x40000010},
+		{"rv32imac_zicsr", "rv32imac_zicsr", 0x40001105},
+		{"RV32IMAC_Zicsr", "rv32imac_zicsr", 0x40001105},
+		{"rv32gc", "rv32imafdc_zicsr_zifencei", 0x4000112D},
+		{"rv32if", "rv32if_zicsr", 0x40000120},
+		{"rv64gc", "rv64imafdc_zicsr_zifencei", 0x80000000_0000112D},
+		{"rv64im", "rv64im", 0x80000000_00001100},
+		{"rv32imsu", "rv32imsu_zicsr", 0x40141100},
+		{"rv32imc_zicsr_zifencei", "rv32imc_zicsr_zifencei", 0x40001104},
+		{"rv64gcsu", "rv64imafdcsu_zicsr_zifencei", 0x80000000_0014112D},
+	}
+
+	for _, test := range tests {
+		isa, err := ParseISA(test.isa)
+		if err != nil {
+			t.Errorf("failed to parse %s: %s", test.isa, err)
+			continue
+		}
+		if isa.String() != test.canonical {
+			t.Errorf("failed to parse %s: expected %s but got %s", test.isa, test.canonical, isa)
+		}
+		if isa.MISA() != test.misa {
+			t.Errorf("failed to parse %s: expected misa %08x but got %08x", test.isa, test.misa, isa.MISA())
+		}
+	}
+
+	invalid := []string{
+		"",
+		"rv16i",
+		"rv32",
+		"rv32x",
+		"rv64e",
+		"rv32iq",
+		"rv32id",
+		"rv32i_zfoo",
+		"rv32is",
+	}
+
+	for _, s := range invalid {
+		if isa, err := ParseISA(s); err == nil {
+			t.Errorf("expected %q to be invalid but got %s", s, isa)
+		}
+	}
+}
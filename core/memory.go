@@ -0,0 +1,136 @@
+package core
+
+import "context"
+
+// MisalignedPolicy specifies what the core does on loads and stores that are not naturally aligned
+type MisalignedPolicy int
+
+const (
+	// MisalignedAllow sends the access to the bus as is and logs it (at debug level)
+	// The bus splits it in a transaction for each word it touches, the result depends on the device
+	MisalignedAllow MisalignedPolicy = iota
+	// MisalignedTrap raises a load / store address misaligned exception
+	MisalignedTrap
+	// MisalignedEmulate splits the access into byte accesses, which are always aligned
+	MisalignedEmulate
+)
+
+// String returns the name of the policy
+func (p MisalignedPolicy) String() string {
+	switch p {
+	case MisalignedAllow:
+		return "allow"
+	case MisalignedTrap:
+		return "trap"
+	case MisalignedEmulate:
+		return "emulate"
+	}
+	return "unknown"
+}
+
+// SetMisalignedPolicy sets what the core does on misaligned loads and stores
+func (rv32 *RISCV) SetMisalignedPolicy(policy MisalignedPolicy) {
+	rv32.misalignedPolicy = policy
+}
+
+// load reads size (1, 2, 4 or 8) bytes at the virtual address addr
+func (rv32 *RISCV) load(ctx context.Context, addr, size uint64) (uint64, error) {
+	if addr&(size-1) != 0 {
+		switch rv32.misalignedPolicy {
+		case MisalignedTrap:
+			return 0, rv32.exception(ExceptionLoadAddressMisaligned, addr, "misaligned load at %08x: %08x", rv32.insPC, addr)
+		case MisalignedEmulate:
+			return rv32.loadBytes(ctx, addr, size)
+		}
+		rv32.log.Debugf("misaligned load at %08x: %08x", rv32.insPC, addr)
+		if addr&pageOffsetMask+size > 1<<pageShift { // Each page is translated and checked on its own
+			return rv32.loadBytes(ctx, addr, size)
+		}
+	}
+
+	paddr, err := rv32.translate(ctx, addr, size, AccessLoad)
+	if err != nil {
+		return 0, err
+	}
+
+	data, err := rv32.Bus.ReadSized(ctx, paddr, size)
+	if err != nil {
+		return 0, rv32.busError(ExceptionLoadAccessFault, addr, err)
+	}
+	return data, nil
+}
+
+// store writes the lower size (1, 2, 4 or 8) bytes of value at the virtual address addr
+func (rv32 *RISCV) store(ctx context.Context, addr, size, value uint64) error {
+	if addr&(size-1) != 0 {
+		switch rv32.misalignedPolicy {
+		case MisalignedTrap:
+			return rv32.exception(ExceptionStoreAddressMisaligned, addr, "misaligned store at %08x: %08x", rv32.insPC, addr)
+		case MisalignedEmulate:
+			return rv32.storeBytes(ctx, addr, size, value)
+		}
+		rv32.log.Debugf("misaligned store at %08x: %08x", rv32.insPC, addr)
+		if addr&pageOffsetMask+size > 1<<pageShift { // Each page is translated and checked on its own
+			return rv32.storeBytes(ctx, addr, size, value)
+		}
+	}
+
+	paddr, err := rv32.translate(ctx, addr, size, AccessStore)
+	if err != nil {
+		return err
+	}
+
+	rv32.invalidateCode(paddr, size)
+	if err := rv32.Bus.WriteSized(ctx, paddr, value, size); err != nil {
+		return rv32.busError(ExceptionStoreAccessFault, addr, err)
+	}
+	return nil
+}
+
+// translateBytes translates each byte of a misaligned access, as it can cross a page boundary
+// All bytes are translated before accessing the bus, so a fault does not leave a partial access behind
+func (rv32 *RISCV) translateBytes(ctx context.Context, addr, size uint64, access AccessType) ([]uint64, error) {
+	paddrs := make([]uint64, size)
+	for i := range paddrs {
+		paddr, err := rv32.translate(ctx, (addr+uint64(i))&rv32.xlenMask, 1, access)
+		if err != nil {
+			return nil, err
+		}
+		paddrs[i] = paddr
+	}
+	return paddrs, nil
+}
+
+// loadBytes emulates a misaligned load with byte loads
+func (rv32 *RISCV) loadBytes(ctx context.Context, addr, size uint64) (uint64, error) {
+	paddrs, err := rv32.translateBytes(ctx, addr, size, AccessLoad)
+	if err != nil {
+		return 0, err
+	}
+
+	data := uint64(0)
+	for i, paddr := range paddrs {
+		b, err := rv32.Bus.ReadByte(ctx, paddr)
+		if err != nil {
+			return 0, rv32.busError(ExceptionLoadAccessFault, (addr+uint64(i))&rv32.xlenMask, err)
+		}
+		data |= uint64(b) << (8 * i)
+	}
+	return data, nil
+}
+
+// storeBytes emulates a misaligned store with byte stores
+func (rv32 *RISCV) storeBytes(ctx context.Context, addr, size, value uint64) error {
+	paddrs, err := rv32.translateBytes(ctx, addr, size, AccessStore)
+	if err != nil {
+		return err
+	}
+
+	for i, paddr := range paddrs {
+		rv32.invalidateCode(paddr, 1)
+		if err := rv32.Bus.WriteByte(ctx, paddr, byte(value>>(8*i))); err != nil {
+			return rv32.busError(ExceptionStoreAccessFault, (addr+uint64(i))&rv32.xlenMask, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package core
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestMisalignedPolicy(t *testing.T) {
+	tests := []struct {
+		policy MisalignedPolicy
+		cause  uint32 // Expected exception, 0 for none
+		reads  int    // Expected number of bus reads of the load
+		writes int    // Expected number of bus writes of the store
+	}{
+		{MisalignedAllow, 0, 2, 2},
+		{MisalignedTrap, ExceptionLoadAddressMisaligned, 0, 0},
+		{MisalignedEmulate, 0, 4, 4},
+	}
+
+	ctx := context.Background()
+
+	for _, test := range tests {
+		cpu := CreateEmulator(nil, WithMisalignedPolicy(test.policy))
+		cpu.SetTrapPolicy(TrapPolicyStop)
+		memory := make([]byte, 0x200)
+		reads, writes := 0, 0
+
+		readData := func(ctx context.Context, address uint64, readMask byte) (uint32, error) {
+			if address < 0x40 {
+				reads++
+			}
+			return binary.LittleEndian.Uint32(memory[address:]), nil
+		}
+		writeData := func(ctx context.Context, address uint64, value uint32, writeMask byte) error {
+			writes++
+			for i := 0; i < 4; i++ {
+				if writeMask&(1<<i) > 0 {
+					memory[address+uint64(i)] = byte(value >> (8 * i))
+				}
+			}
+			return nil
+		}
+		if err := cpu.Bus.Map("memory", 0, uint64(len(memory)), readData, writeData); err != nil {
+			t.Fatal(err)
+		}
+
+		copy(memory[1:], []byte{0xBE, 0xBA, 0xFE, 0xCA})
+		binary.LittleEndian.PutUint32(memory[0x40:], 0x00102083) // lw x1, 1(x0)
+		binary.LittleEndian.PutUint32(memory[0x44:], 0x102020A3) // sw x2, 0x101(x0)
+		cpu.Registers.SetInteger(2, 0x12345678)
+		cpu.SetPC(0x40)
+
+		err := cpu.RunStep(ctx)
+		if test.cause != 0 {
+			var ex Exception
+			if !errors.As(err, &ex) || ex.Cause != test.cause || ex.Value != 1 {
+				t.Errorf("%s: Expected exception %d with value 1 but got %v", test.policy, test.cause, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %s", test.policy, err)
+		}
+		if v := cpu.Registers.GetInteger(1); v != 0xCAFEBABE {
+			t.Errorf("%s: Expected X01 to be cafebabe but got %08x", test.policy, v)
+		}
+
+		if err := cpu.RunStep(ctx); err != nil {
+			t.Fatalf("%s: %s", test.policy, err)
+		}
+		if v := binary.LittleEndian.Uint32(memory[0x101:]); v != 0x12345678 {
+			t.Errorf("%s: Expected 00000101 to be 12345678 but got %08x", test.policy, v)
+		}
+		if reads != test.reads || writes != test.writes {
+			t.Errorf("%s: Expected %d reads and %d writes but got %d and %d", test.policy, test.reads, test.writes, reads, writes)
+		}
+	}
+}
+
+func TestMisalignedPageCrossing(t *testing.T) {
+	cpu, memory := createMMUTestCPU(t)
+	cpu.priv = PrivilegeSupervisor
+	ctx := context.Background()
+
+	// 0xA000 and 0xB000 are mapped to pages that are not physically contiguous
+	binary.LittleEndian.PutUint32(memory[0x2000+10*4:], 0xC<<ptePPNShift|PTEValid|PTERead|PTEWrite)
+	binary.LittleEndian.PutUint32(memory[0x2000+11*4:], 0xE<<ptePPNShift|PTEValid|PTERead|PTEWrite)
+	copy(memory[0xCFFE:], []byte{0xBE, 0xBA})
+	copy(memory[0xE000:], []byte{0xFE, 0xCA})
+
+	if v, err := cpu.load(ctx, 0xAFFE, 4); err != nil || v != 0xCAFEBABE {
+		t.Errorf("Expected to load cafebabe but got %08x (%v)", v, err)
+	}
+	if err := cpu.store(ctx, 0xAFFD, 4, 0x12345678); err != nil {
+		t.Fatal(err)
+	}
+	if v := binary.LittleEndian.Uint32(memory[0xCFFC:]); v != 0x34567800 {
+		t.Errorf("Expected 0000cffc to be 34567800 but got %08x", v)
+	}
+	if v := binary.LittleEndian.Uint32(memory[0xE000:]); v != 0x0000CA12 {
+		t.Errorf("Expected 0000e000 to be 0000ca12 but got %08x", v)
+	}
+
+	// The second page is checked as well, and nothing is written on a fault
+	_, err := cpu.load(ctx, 0xBFFE, 4)
+	var ex Exception
+	if !errors.As(err, &ex) || ex.Cause != ExceptionLoadPageFault {
+		t.Errorf("Expected a load page fault but got %v", err)
+	}
+	err = cpu.store(ctx, 0xBFFF, 2, 0xFFFF)
+	if !errors.As(err, &ex) || ex.Cause != ExceptionStorePageFault {
+		t.Errorf("Expected a store page fault but got %v", err)
+	}
+	if memory[0xEFFF] != 0 {
+		t.Errorf("Expected 0000efff to be unchanged but got %02x", memory[0xEFFF])
+	}
+}
@@ -0,0 +1,303 @@
+package core
+
+import (
+	"context"
+	"fmt"
+)
+
+// Address translation CSR
+const CSRSATP = 0x180
+
+// satp fields (Sv32)
+const (
+	SATPModeSv32 = 1 << 31
+
+	satpASIDMask  = 0x1FF << 22
+	satpASIDShift = 22
+	satpPPNMask   = 0x3FFFFF
+)
+
+// Sv32 page table entry bits
+const (
+	PTEValid    = 1 << 0
+	PTERead     = 1 << 1
+	PTEWrite    = 1 << 2
+	PTEExecute  = 1 << 3
+	PTEUser     = 1 << 4
+	PTEGlobal   = 1 << 5
+	PTEAccessed = 1 << 6
+	PTEDirty    = 1 << 7
+
+	ptePPNShift = 10
+)
+
+const (
+	pageShift      = 12
+	pageOffsetMask = 1<<pageShift - 1
+	sv32VPNBits    = 10
+	sv32VPNMask    = 1<<sv32VPNBits - 1
+	sv32PTESize    = 4
+
+	tlbSize = 64 // Number of TLB entries, must be a power of two
+)
+
+// tlbEntry is a cached leaf page table entry
+// Superpages are cached as the 4 KiB page that was accessed, with megapage set so sfence.vma drops all of them
+type tlbEntry struct {
+	valid    bool
+	megapage bool
+	vpn      uint64
+	asid     uint64
+	ppn      uint64 // Physical page number of the 4 KiB page
+	pte      uint32
+	pteAddr  uint64
+}
+
+// registerMMUCSRs registers the address translation CSR
+// Only Sv32 is implemented, so in RV64 satp is read-only zero (Bare)
+func (rv32 *RISCV) registerMMUCSRs() {
+	if !rv32.isa.Has(MISAExtS) {
+		return
+	}
+
+	writeMask := uint64(0)
+	if rv32.isa.XLEN == 32 {
+		writeMask = SATPModeSv32 | satpASIDMask | satpPPNMask
+	}
+
+	err := rv32.CSR.Register(CSRSATP, CSR{
+		Name:      "satp",
+		WriteMask: writeMask,
+		RHandler:  func() uint64 { return rv32.satp },
+		WHandler:  func(value uint64) { rv32.satp = value },
+	})
+	if err != nil {
+		rv32.log.Errorf("cannot register csr satp: %s", err)
+	}
+}
+
+// FlushTLB invalidates all cached translations
+func (rv32 *RISCV) FlushTLB() {
+	rv32.tlb = [tlbSize]tlbEntry{}
+}
+
+// translate returns the physical address for the size bytes at the virtual address vaddr
+// Loads and stores use the privilege level in mstatus.MPP when mstatus.MPRV is set in machine mode
+// The physical address is checked against the PMP, and page faults, PMP violations and bus errors
+// while walking the page table are returned as exceptions
+func (rv32 *RISCV) translate(ctx context.Context, vaddr, size uint64, access AccessType) (uint64, error) {
+	priv := rv32.priv
+	if access != AccessFetch && priv == PrivilegeMachine && rv32.mstatus&MStatusMPRV > 0 {
+		priv = PrivilegeLevel((rv32.mstatus & MStatusMPP) >> mstatusMPPShift)
+	}
+
+	paddr := vaddr
+	if priv != PrivilegeMachine && rv32.satp&SATPModeSv32 > 0 && rv32.isa.XLEN == 32 {
+		var err error
+		paddr, err = rv32.translatePage(ctx, vaddr, priv, access)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if !rv32.pmpPermitted(paddr, size, priv, access) {
+		return 0, rv32.accessFault(vaddr, access, fmt.Errorf("pmp violation at %09x", paddr))
+	}
+	if access != AccessFetch && len(rv32.watchpoints) > 0 {
+		rv32.checkWatchpoints(vaddr, size)
+	}
+	return paddr, nil
+}
+
+// translatePage translates vaddr using the TLB, walking the page table on misses
+func (rv32 *RISCV) translatePage(ctx context.Context, vaddr uint64, priv PrivilegeLevel, access AccessType) (uint64, error) {
+	vpn := vaddr >> pageShift
+	asid := (rv32.satp & satpASIDMask) >> satpASIDShift
+	entry := &rv32.tlb[vpn&(tlbSize-1)]
+	if !entry.valid || entry.vpn != vpn || (entry.asid != asid && entry.pte&PTEGlobal == 0) {
+		walked, err := rv32.walkPageTable(ctx, vaddr, access)
+		if err != nil {
+			return 0, err
+		}
+		*entry = walked
+	}
+
+	if !rv32.pagePermitted(entry.pte, priv, access) {
+		return 0, rv32.pageFault(vaddr, access)
+	}
+
+	// A and D bits are updated by the hardware
+	if entry.pte&PTEAccessed == 0 || (access == AccessStore && entry.pte&PTEDirty == 0) {
+		if err := rv32.updateAccessedDirty(ctx, entry, vaddr, priv, access); err != nil {
+			entry.valid = false
+			return 0, err
+		}
+	}
+
+	return entry.ppn<<pageShift | vaddr&pageOffsetMask, nil
+}
+
+// updateAccessedDirty sets the A bit (and D for stores) of the leaf entry in memory
+// The entry is read again first, and if the supervisor changed it since it was cached the page table is walked again,
+// so the update never overwrites a newer entry
+func (rv32 *RISCV) updateAccessedDirty(ctx context.Context, entry *tlbEntry, vaddr uint64, priv PrivilegeLevel, access AccessType) error {
+	pte, err := rv32.readPTE(ctx, entry.pteAddr, vaddr, access)
+	if err != nil {
+		return err
+	}
+	if pte&^(PTEAccessed|PTEDirty) != entry.pte&^(PTEAccessed|PTEDirty) {
+		walked, err := rv32.walkPageTable(ctx, vaddr, access)
+		if err != nil {
+			return err
+		}
+		*entry = walked
+		if !rv32.pagePermitted(entry.pte, priv, access) {
+			return rv32.pageFault(vaddr, access)
+		}
+		pte = entry.pte
+	}
+
+	if !rv32.pmpPermitted(entry.pteAddr, sv32PTESize, PrivilegeSupervisor, AccessStore) {
+		return rv32.accessFault(vaddr, access, fmt.Errorf("pmp violation updating pte at %09x", entry.pteAddr))
+	}
+	pte |= PTEAccessed
+	if access == AccessStore {
+		pte |= PTEDirty
+	}
+	entry.pte = pte
+	rv32.invalidateCode(entry.pteAddr, sv32PTESize)
+	if err := rv32.Bus.WriteWord(ctx, entry.pteAddr, pte); err != nil {
+		rv32.countEvent(HPMEventBusError)
+		return rv32.accessFault(vaddr, access, err)
+	}
+	return nil
+}
+
+// readPTE reads the page table entry at the physical address pteAddr, while translating vaddr
+func (rv32 *RISCV) readPTE(ctx context.Context, pteAddr, vaddr uint64, access AccessType) (uint32, error) {
+	if !rv32.pmpPermitted(pteAddr, sv32PTESize, PrivilegeSupervisor, AccessLoad) { // Page table accesses are checked as supervisor loads
+		return 0, rv32.accessFault(vaddr, access, fmt.Errorf("pmp violation reading pte at %09x", pteAddr))
+	}
+	pte, err := rv32.Bus.ReadWord(ctx, pteAddr)
+	if err != nil {
+		rv32.countEvent(HPMEventBusError)
+		return 0, rv32.accessFault(vaddr, access, err)
+	}
+	return pte, nil
+}
+
+// walkPageTable walks the Sv32 page table pointed by satp looking for the leaf entry of vaddr
+func (rv32 *RISCV) walkPageTable(ctx context.Context, vaddr uint64, access AccessType) (tlbEntry, error) {
+	vpn := [2]uint64{(vaddr >> pageShift) & sv32VPNMask, (vaddr >> (pageShift + sv32VPNBits)) & sv32VPNMask}
+	table := (rv32.satp & satpPPNMask) << pageShift
+
+	for level := 1; level >= 0; level-- {
+		pteAddr := table + vpn[level]*sv32PTESize
+		pte, err := rv32.readPTE(ctx, pteAddr, vaddr, access)
+		if err != nil {
+			return tlbEntry{}, err
+		}
+		if pte&PTEValid == 0 || (pte&PTERead == 0 && pte&PTEWrite > 0) {
+			return tlbEntry{}, rv32.pageFault(vaddr, access)
+		}
+
+		ppn := uint64(pte >> ptePPNShift)
+		if pte&(PTERead|PTEExecute) == 0 { // Pointer to the next level
+			table = ppn << pageShift
+			continue
+		}
+
+		if level == 1 {
+			if ppn&sv32VPNMask != 0 { // Misaligned superpage
+				return tlbEntry{}, rv32.pageFault(vaddr, access)
+			}
+			ppn |= vpn[0]
+		}
+
+		return tlbEntry{
+			valid:    true,
+			megapage: level == 1,
+			vpn:      vaddr >> pageShift,
+			asid:     (rv32.satp & satpASIDMask) >> satpASIDShift,
+			ppn:      ppn,
+			pte:      pte,
+			pteAddr:  pteAddr,
+		}, nil
+	}
+
+	// The last level must be a leaf
+	return tlbEntry{}, rv32.pageFault(vaddr, access)
+}
+
+// pagePermitted checks the permissions of a leaf page table entry for the access
+func (rv32 *RISCV) pagePermitted(pte uint32, priv PrivilegeLevel, access AccessType) bool {
+	switch priv {
+	case PrivilegeUser:
+		if pte&PTEUser == 0 {
+			return false
+		}
+	case PrivilegeSupervisor: // User pages can only be read / written with mstatus.SUM, and never executed
+		if pte&PTEUser > 0 && (access == AccessFetch || rv32.mstatus&MStatusSUM == 0) {
+			return false
+		}
+	}
+
+	switch access {
+	case AccessFetch:
+		return pte&PTEExecute > 0
+	case AccessLoad:
+		return pte&PTERead > 0 || (rv32.mstatus&MStatusMXR > 0 && pte&PTEExecute > 0)
+	}
+	return pte&PTEWrite > 0
+}
+
+// pageFault creates the page fault exception for the access type
+func (rv32 *RISCV) pageFault(vaddr uint64, access AccessType) error {
+	cause := uint32(ExceptionStorePageFault)
+	switch access {
+	case AccessFetch:
+		cause = ExceptionInstructionPageFault
+	case AccessLoad:
+		cause = ExceptionLoadPageFault
+	}
+	return rv32.exception(cause, vaddr, "page fault at %08x accessing %08x", rv32.insPC, vaddr)
+}
+
+// accessFault creates the access fault exception for the access type
+func (rv32 *RISCV) accessFault(vaddr uint64, access AccessType, err error) error {
+	cause := uint32(ExceptionStoreAccessFault)
+	switch access {
+	case AccessFetch:
+		cause = ExceptionInstructionAccessFault
+	case AccessLoad:
+		cause = ExceptionLoadAccessFault
+	}
+	return rv32.exception(cause, vaddr, "access fault at %08x accessing %08x: %s", rv32.insPC, vaddr, err)
+}
+
+// sfenceVMA runs sfence.vma, flushing the TLB entries that matches the virtual address in rs1 and the ASID in rs2
+// x0 in rs1 / rs2 matches any address / ASID. Global mappings are only flushed when rs2 is x0
+// An address drops every cached 4 KiB page of the megapage that contains it
+func (rv32 *RISCV) sfenceVMA(ins uint32, rs1Val, rs2Val uint64) error {
+	// 0001001 rs2 rs1 000 00000 1110011 R sfence.vma
+	if !rv32.isa.Has(MISAExtS) || rv32.priv < PrivilegeSupervisor {
+		return rv32.illegalInstruction(ins)
+	}
+
+	rs1 := (ins & insRs1Mask) >> 15
+	rs2 := (ins & insRs2Mask) >> 20
+	vpn := (rs1Val & rv32.xlenMask) >> pageShift
+	asid := rs2Val & (satpASIDMask >> satpASIDShift)
+
+	for i := range rv32.tlb {
+		entry := &rv32.tlb[i]
+		if rs1 != 0 && entry.vpn != vpn && !(entry.megapage && entry.vpn>>sv32VPNBits == vpn>>sv32VPNBits) {
+			continue
+		}
+		if rs2 != 0 && (entry.asid != asid || entry.pte&PTEGlobal > 0) {
+			continue
+		}
+		entry.valid = false
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package core
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// createMMUTestCPU creates a RV32 core with supervisor mode with createTestCPU
+// The Sv32 page table root is at 0x1000 with a second level table at 0x2000
+func createMMUTestCPU(t *testing.T) (*RISCV, []byte) {
+	cpu, memory := createTestCPU(t, []Option{WithISA("rv32imasu")})
+
+	pte := func(table, index uint64, ppn, flags uint32) {
+		binary.LittleEndian.PutUint32(memory[table+index*4:], ppn<<ptePPNShift|flags)
+	}
+
+	pte(0x1000, 0, 0x2, PTEValid)                            // 0x00000000 - 0x003FFFFF: second level table
+	pte(0x1000, 1, 0x0, PTEValid|PTERead|PTEWrite)           // 0x00400000 - 0x007FFFFF: megapage at 0
+	pte(0x1000, 2, 0x1, PTEValid|PTERead|PTEWrite)           // 0x00800000 - 0x00BFFFFF: misaligned megapage
+	pte(0x2000, 3, 0x5, PTEValid|PTERead|PTEExecute)         // 0x3000: code
+	pte(0x2000, 4, 0x6, PTEValid|PTERead|PTEWrite)           // 0x4000: data
+	pte(0x2000, 5, 0x7, PTEValid|PTERead|PTEWrite|PTEUser)   // 0x5000: user data
+	pte(0x2000, 6, 0x8, PTEValid|PTEExecute)                 // 0x6000: execute only
+	pte(0x2000, 8, 0x9, PTEValid|PTEWrite)                   // 0x8000: reserved
+	pte(0x2000, 9, 0xA, PTEValid|PTERead|PTEExecute|PTEUser) // 0x9000: user code
+
+	cpu.CSR.Set(CSRSATP, SATPModeSv32|1)
+	return cpu, memory
+}
+
+func TestMMU_Translate(t *testing.T) {
+	tests := []struct {
+		name    string
+		priv    PrivilegeLevel
+		mstatus uint64
+		vaddr   uint64
+		access  AccessType
+		paddr   uint64
+		cause   uint32 // Expected exception, 0 for none
+	}{
+		{"load code", PrivilegeSupervisor, 0, 0x3010, AccessLoad, 0x5010, 0},
+		{"fetch code", PrivilegeSupervisor, 0, 0x3010, AccessFetch, 0x5010, 0},
+		{"store code", PrivilegeSupervisor, 0, 0x3010, AccessStore, 0, ExceptionStorePageFault},
+		{"store data", PrivilegeSupervisor, 0, 0x4008, AccessStore, 0x6008, 0},
+		{"fetch data", PrivilegeSupervisor, 0, 0x4008, AccessFetch, 0, ExceptionInstructionPageFault},
+		{"supervisor load user page", PrivilegeSupervisor, 0, 0x5000, AccessLoad, 0, ExceptionLoadPageFault},
+		{"supervisor load user page with SUM", PrivilegeSupervisor, MStatusSUM, 0x5000, AccessLoad, 0x7000, 0},
+		{"supervisor fetch user page with SUM", PrivilegeSupervisor, MStatusSUM, 0x9000, AccessFetch, 0, ExceptionInstructionPageFault},
+		{"user load user page", PrivilegeUser, 0, 0x5004, AccessLoad, 0x7004, 0},
+		{"user fetch user page", PrivilegeUser, 0, 0x9004, AccessFetch, 0xA004, 0},
+		{"user load supervisor page", PrivilegeUser, 0, 0x4000, AccessLoad, 0, ExceptionLoadPageFault},
+		{"load execute only", PrivilegeSupervisor, 0, 0x6000, AccessLoad, 0, ExceptionLoadPageFault},
+		{"load execute only with MXR", PrivilegeSupervisor, MStatusMXR, 0x6000, AccessLoad, 0x8000, 0},
+		{"invalid entry", PrivilegeSupervisor, 0, 0x7000, AccessLoad, 0, ExceptionLoadPageFault},
+		{"reserved entry", PrivilegeSupervisor, 0, 0x8000, AccessStore, 0, ExceptionStorePageFault},
+		{"megapage", PrivilegeSupervisor, 0, 0x00401234, AccessLoad, 0x1234, 0},
+		{"misaligned megapage", PrivilegeSupervisor, 0, 0x00801000, AccessLoad, 0, ExceptionLoadPageFault},
+		{"machine mode", PrivilegeMachine, 0, 0x3010, AccessLoad, 0x3010, 0},
+		{"machine mode with MPRV", PrivilegeMachine, MStatusMPRV | uint64(PrivilegeSupervisor)<<mstatusMPPShift, 0x3010, AccessLoad, 0x5010, 0},
+		{"machine mode fetch with MPRV", PrivilegeMachine, MStatusMPRV | uint64(PrivilegeSupervisor)<<mstatusMPPShift, 0x3010, AccessFetch, 0x3010, 0},
+	}
+
+	ctx := context.Background()
+
+	for _, test := range tests {
+		cpu, _ := createMMUTestCPU(t)
+		cpu.priv = test.priv
+		cpu.mstatus = test.mstatus
+
+		paddr, err := cpu.translate(ctx, test.vaddr, 4, test.access)
+		if test.cause != 0 {
+			var ex Exception
+			if !errors.As(err, &ex) || ex.Cause != test.cause || ex.Value != test.vaddr {
+				t.Errorf("%s: Expected exception %d with value %08x but got %v", test.name, test.cause, test.vaddr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+		if paddr != test.paddr {
+			t.Errorf("%s: Expected physical address %08x but got %08x", test.name, test.paddr, paddr)
+		}
+	}
+}
+
+func TestMMU_AccessedDirty(t *testing.T) {
+	cpu, memory := createMMUTestCPU(t)
+	cpu.priv = PrivilegeSupervisor
+	ctx := context.Background()
+
+	if _, err := cpu.translate(ctx, 0x3000, 4, AccessFetch); err != nil {
+		t.Fatal(err)
+	}
+	if pte := binary.LittleEndian.Uint32(memory[0x2000+3*4:]); pte&(PTEAccessed|PTEDirty) != PTEAccessed {
+		t.Errorf("Expected only A to be set after a fetch but got pte %08x", pte)
+	}
+
+	if _, err := cpu.translate(ctx, 0x4000, 4, AccessLoad); err != nil {
+		t.Fatal(err)
+	}
+	if pte := binary.LittleEndian.Uint32(memory[0x2000+4*4:]); pte&(PTEAccessed|PTEDirty) != PTEAccessed {
+		t.Errorf("Expected only A to be set after a load but got pte %08x", pte)
+	}
+
+	// A cached translation still sets D on the first store
+	if _, err := cpu.translate(ctx, 0x4000, 4, AccessStore); err != nil {
+		t.Fatal(err)
+	}
+	if pte := binary.LittleEndian.Uint32(memory[0x2000+4*4:]); pte&(PTEAccessed|PTEDirty) != PTEAccessed|PTEDirty {
+		t.Errorf("Expected A and D to be set after a store but got pte %08x", pte)
+	}
+}
+
+func TestMMU_SFenceVMA(t *testing.T) {
+	cpu, memory := createMMUTestCPU(t)
+	cpu.priv = PrivilegeSupervisor
+	ctx := context.Background()
+
+	if paddr, _ := cpu.translate(ctx, 0x4000, 4, AccessLoad); paddr != 0x6000 {
+		t.Fatalf("Expected 0x4000 to be translated to 00006000 but got %08x", paddr)
+	}
+
+	// Remapping the page is only visible after sfence.vma
+	binary.LittleEndian.PutUint32(memory[0x2000+4*4:], 0xB<<ptePPNShift|PTEValid|PTERead|PTEWrite)
+	if paddr, _ := cpu.translate(ctx, 0x4000, 4, AccessLoad); paddr != 0x6000 {
+		t.Errorf("Expected 0x4000 to be cached as 00006000 but got %08x", paddr)
+	}
+
+	if err := cpu.sfenceVMA(0x12000073, 0, 0); err != nil { // sfence.vma x0, x0
+		t.Fatal(err)
+	}
+	if paddr, _ := cpu.translate(ctx, 0x4000, 4, AccessLoad); paddr != 0xB000 {
+		t.Errorf("Expected 0x4000 to be translated to 0000b000 after sfence.vma but got %08x", paddr)
+	}
+
+	cpu.priv = PrivilegeUser
+	err := cpu.sfenceVMA(0x12000073, 0, 0)
+	var ex Exception
+	if !errors.As(err, &ex) || ex.Cause != ExceptionIllegalInstruction {
+		t.Errorf("Expected sfence.vma to be illegal in user mode but got %v", err)
+	}
+}
+
+func TestMMU_SFenceVMAMegapage(t *testing.T) {
+	cpu, memory := createMMUTestCPU(t)
+	cpu.priv = PrivilegeSupervisor
+	ctx := context.Background()
+
+	for _, vaddr := range []uint64{0x00401000, 0x00402000} {
+		if paddr, _ := cpu.translate(ctx, vaddr, 4, AccessLoad); paddr != vaddr-0x00400000 {
+			t.Fatalf("Expected %08x to be translated to %08x but got %08x", vaddr, vaddr-0x00400000, paddr)
+		}
+	}
+
+	// Fencing an address of the megapage drops all its cached pages
+	binary.LittleEndian.PutUint32(memory[0x1000+1*4:], 0x400<<ptePPNShift|PTEValid|PTERead|PTEWrite|PTEAccessed|PTEDirty)
+	if err := cpu.sfenceVMA(0x12008073, 0x00401000, 0); err != nil { // sfence.vma x1, x0
+		t.Fatal(err)
+	}
+	for _, vaddr := range []uint64{0x00401000, 0x00402000} {
+		if paddr, _ := cpu.translate(ctx, vaddr, 4, AccessLoad); paddr != vaddr {
+			t.Errorf("Expected %08x to be translated to %08x after sfence.vma but got %08x", vaddr, vaddr, paddr)
+		}
+	}
+}
+
+func TestMMU_AccessedDirtyChangedEntry(t *testing.T) {
+	cpu, memory := createMMUTestCPU(t)
+	cpu.priv = PrivilegeSupervisor
+	ctx := context.Background()
+
+	if _, err := cpu.translate(ctx, 0x4000, 4, AccessLoad); err != nil {
+		t.Fatal(err)
+	}
+
+	// The entry is invalidated without sfence.vma, setting D must not write the cached copy back
+	binary.LittleEndian.PutUint32(memory[0x2000+4*4:], 0xB<<ptePPNShift|PTERead|PTEWrite)
+	_, err := cpu.translate(ctx, 0x4000, 4, AccessStore)
+	var ex Exception
+	if !errors.As(err, &ex) || ex.Cause != ExceptionStorePageFault {
+		t.Errorf("Expected a store page fault but got %v", err)
+	}
+	if pte := binary.LittleEndian.Uint32(memory[0x2000+4*4:]); pte != 0xB<<ptePPNShift|PTERead|PTEWrite {
+		t.Errorf("Expected the pte to be unchanged but got %08x", pte)
+	}
+
+	// A remapped entry is walked again
+	binary.LittleEndian.PutUint32(memory[0x2000+4*4:], 0xB<<ptePPNShift|PTEValid|PTERead|PTEWrite|PTEAccessed)
+	if _, err := cpu.translate(ctx, 0x4000, 4, AccessLoad); err != nil {
+		t.Fatal(err)
+	}
+	binary.LittleEndian.PutUint32(memory[0x2000+4*4:], 0xC<<ptePPNShift|PTEValid|PTERead|PTEWrite|PTEAccessed)
+	if paddr, _ := cpu.translate(ctx, 0x4000, 4, AccessStore); paddr != 0xC000 {
+		t.Errorf("Expected 0x4000 to be translated to 0000c000 but got %08x", paddr)
+	}
+	if pte := binary.LittleEndian.Uint32(memory[0x2000+4*4:]); pte != 0xC<<ptePPNShift|PTEValid|PTERead|PTEWrite|PTEAccessed|PTEDirty {
+		t.Errorf("Expected D to be set in the new pte but got %08x", pte)
+	}
+}
+
+func TestMMU_Execute(t *testing.T) {
+	cpu, memory := createMMUTestCPU(t)
+	cpu.priv = PrivilegeSupervisor
+	ctx := context.Background()
+
+	binary.LittleEndian.PutUint32(memory[0x5000:], 0x00012083) // lw x1, 0(x2)
+	binary.LittleEndian.PutUint32(memory[0x5004:], 0x00112223) // sw x1, 4(x2)
+	binary.LittleEndian.PutUint32(memory[0x5008:], 0x00112023) // sw x1, 0(x2)
+	binary.LittleEndian.PutUint32(memory[0x6000:], 0xCAFEBABE)
+	cpu.Registers.SetInteger(2, 0x4000)
+	cpu.SetPC(0x3000)
+
+	if err := cpu.RunStep(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if cpu.Registers.GetInteger(1) != 0xCAFEBABE {
+		t.Errorf("Expected X01 to be cafebabe but got %08x", cpu.Registers.GetInteger(1))
+	}
+
+	if err := cpu.RunStep(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if v := binary.LittleEndian.Uint32(memory[0x6004:]); v != 0xCAFEBABE {
+		t.Errorf("Expected physical 00006004 to be cafebabe but got %08x", v)
+	}
+
+	// Store to a read only page
+	cpu.Registers.SetInteger(2, 0x3000)
+	err := cpu.RunStep(ctx)
+	var ex Exception
+	if !errors.As(err, &ex) || ex.Cause != ExceptionStorePageFault || ex.Value != 0x3000 {
+		t.Errorf("Expected a store page fault at 00003000 but got %v", err)
+	}
+	if cpu.GetPC() != 0x3008 {
+		t.Errorf("Expected PC to be 00003008 but got %08x", cpu.GetPC())
+	}
+}
@@ -0,0 +1,96 @@
+package core
+
+// Option configures the emulator on CreateEmulator
+type Option func(rv32 *RISCV)
+
+// WithISA sets the base ISA and the extensions implemented by the core from a ISA string (like "rv32imac_zicsr")
+// Instructions from extensions that are not implemented raises an illegal instruction exception
+// An invalid ISA string is logged and ignored
+func WithISA(isa string) Option {
+	return func(rv32 *RISCV) {
+		parsed, err := ParseISA(isa)
+		if err != nil {
+			rv32.log.Errorf("%s, using %s", err, rv32.isa)
+			return
+		}
+		rv32.isa = parsed
+	}
+}
+
+// WithXLEN sets the integer register width of the core
+// Only 32 (RV32) and 64 (RV64) are supported, any other value is ignored
+func WithXLEN(xlen int) Option {
+	return func(rv32 *RISCV) {
+		switch xlen {
+		case 32, 64:
+			rv32.isa.XLEN = xlen
+		default:
+			rv32.log.Errorf("unsupported XLEN %d, using %d", xlen, rv32.isa.XLEN)
+		}
+	}
+}
+
+// WithRV32E makes the core run as RV32E, with only the registers x0-x15
+// Any instruction that references x16-x31 raises an illegal instruction exception
+// This is the same as the E base in WithISA, keeping the current extensions
+func WithRV32E() Option {
+	return func(rv32 *RISCV) {
+		rv32.isa.XLEN = 32
+		rv32.isa.Embedded = true
+	}
+}
+
+// WithPMP enables the physical memory protection unit with the specified number of entries
+// Only 0 (no PMP, the default), 16 and 64 entries are supported, any other value is ignored
+// With PMP enabled, supervisor and user mode accesses fail until machine mode configures an entry that allows them
+func WithPMP(entries int) Option {
+	return func(rv32 *RISCV) {
+		switch entries {
+		case 0, 16, 64:
+			rv32.pmpcfg = make([]uint8, entries)
+			rv32.pmpaddr = make([]uint64, entries)
+		default:
+			rv32.log.Errorf("unsupported number of PMP entries %d, using %d", entries, len(rv32.pmpcfg))
+		}
+	}
+}
+
+// WithMisalignedPolicy sets what the core does on misaligned loads and stores
+// The default is MisalignedAllow, which leaves it to the device being accessed
+func WithMisalignedPolicy(policy MisalignedPolicy) Option {
+	return func(rv32 *RISCV) {
+		switch policy {
+		case MisalignedAllow, MisalignedTrap, MisalignedEmulate:
+			rv32.misalignedPolicy = policy
+		default:
+			rv32.log.Errorf("unsupported misaligned policy %d, using %s", policy, rv32.misalignedPolicy)
+		}
+	}
+}
+
+// WithIdleFastForward enables or disables skipping the idle cycles while waiting for an interrupt (WFI)
+// It is enabled by default, see SetIdleFastForward
+func WithIdleFastForward(enabled bool) Option {
+	return func(rv32 *RISCV) {
+		rv32.idleFastForward = enabled
+	}
+}
+
+// WithDecodeCache enables or disables the decode cache, which keeps the decoded instructions of the executed pages
+// It is enabled by default. Disabling it fetches and decodes every instruction again, which is slower but makes
+// every fetch visible to the bus devices
+func WithDecodeCache(enabled bool) Option {
+	return func(rv32 *RISCV) {
+		rv32.decodeCache = nil
+		if enabled {
+			rv32.decodeCache = newDecodeCache()
+		}
+	}
+}
+
+// WithEngine selects the execution engine used by RunUntil and Start, the default is EngineInterpreter
+func WithEngine(engine Engine) Option {
+	return func(rv32 *RISCV) {
+		rv32.SetEngine(engine)
+	}
+}
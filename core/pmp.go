@@ -0,0 +1,174 @@
+package core
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// PMP CSR addresses
+const (
+	CSRPMPCfg0  = 0x3A0 // pmpcfg0 - pmpcfg15, only the even ones exists in RV64
+	CSRPMPAddr0 = 0x3B0 // pmpaddr0 - pmpaddr63
+)
+
+// pmpcfg fields (one byte per entry)
+const (
+	PMPRead    = 1 << 0
+	PMPWrite   = 1 << 1
+	PMPExecute = 1 << 2
+	PMPA       = 3 << 3 // Address matching mode
+	PMPLock    = 1 << 7
+
+	pmpAShift = 3
+)
+
+// PMP address matching modes (pmpcfg.A)
+const (
+	PMPAOff   = 0
+	PMPATOR   = 1 // Top of range, from the previous pmpaddr to this one
+	PMPANA4   = 2 // Naturally aligned four byte region
+	PMPANAPOT = 3 // Naturally aligned power of two region, 8 bytes or more
+)
+
+// registerPMPCSRs registers the pmpcfg / pmpaddr CSRs of the implemented PMP entries
+// Each pmpcfg holds the configuration of 4 entries in RV32 and 8 in RV64
+func (rv32 *RISCV) registerPMPCSRs() {
+	entries := len(rv32.pmpcfg)
+	if entries == 0 {
+		return
+	}
+
+	entriesPerCfg := rv32.isa.XLEN / 8
+	addrMask := uint64(0xFFFFFFFF) // RV32 uses 34 bit physical addresses
+	if rv32.isa.XLEN == 64 {
+		addrMask = 1<<54 - 1 // RV64 uses 56 bit physical addresses
+	}
+
+	csrs := map[uint32]CSR{}
+	for n := 0; n*4 < entries; n += entriesPerCfg / 4 {
+		first := n * 4
+		csrs[CSRPMPCfg0+uint32(n)] = CSR{
+			Name:      fmt.Sprintf("pmpcfg%d", n),
+			WriteMask: ^uint64(0),
+			RHandler:  func() uint64 { return rv32.pmpCfgRead(first, entriesPerCfg) },
+			WHandler:  func(value uint64) { rv32.pmpCfgWrite(first, entriesPerCfg, value) },
+		}
+	}
+	for i := 0; i < entries; i++ {
+		i := i
+		csrs[CSRPMPAddr0+uint32(i)] = CSR{
+			Name:      fmt.Sprintf("pmpaddr%d", i),
+			WriteMask: addrMask,
+			RHandler:  func() uint64 { return rv32.pmpaddr[i] },
+			WHandler: func(value uint64) {
+				if rv32.pmpAddrLocked(i) {
+					return
+				}
+				rv32.pmpaddr[i] = value
+			},
+		}
+	}
+
+	for address, csr := range csrs {
+		if err := rv32.CSR.Register(address, csr); err != nil {
+			rv32.log.Errorf("cannot register csr %s: %s", csr.Name, err)
+		}
+	}
+}
+
+// resetPMP disables and unlocks all PMP entries
+func (rv32 *RISCV) resetPMP() {
+	for i := range rv32.pmpcfg {
+		rv32.pmpcfg[i] = 0
+		rv32.pmpaddr[i] = 0
+	}
+}
+
+// pmpCfgRead packs count entries starting at first in a pmpcfg value
+func (rv32 *RISCV) pmpCfgRead(first, count int) uint64 {
+	value := uint64(0)
+	for i := 0; i < count && first+i < len(rv32.pmpcfg); i++ {
+		value |= uint64(rv32.pmpcfg[first+i]) << (8 * i)
+	}
+	return value
+}
+
+// pmpCfgWrite unpacks a pmpcfg value in count entries starting at first
+// Locked entries are not changed, and the reserved W without R permission is not accepted
+func (rv32 *RISCV) pmpCfgWrite(first, count int, value uint64) {
+	for i := 0; i < count && first+i < len(rv32.pmpcfg); i++ {
+		if rv32.pmpcfg[first+i]&PMPLock > 0 {
+			continue
+		}
+		cfg := uint8(value>>(8*i)) & (PMPRead | PMPWrite | PMPExecute | PMPA | PMPLock)
+		if cfg&(PMPRead|PMPWrite) == PMPWrite {
+			cfg &^= PMPWrite
+		}
+		rv32.pmpcfg[first+i] = cfg
+	}
+}
+
+// pmpAddrLocked returns true if pmpaddr of the entry cannot be written
+// It is locked by its own lock bit or by the lock of the next entry when it is the bottom of a TOR range
+func (rv32 *RISCV) pmpAddrLocked(i int) bool {
+	if rv32.pmpcfg[i]&PMPLock > 0 {
+		return true
+	}
+	if i+1 < len(rv32.pmpcfg) {
+		next := rv32.pmpcfg[i+1]
+		return next&PMPLock > 0 && (next&PMPA)>>pmpAShift == PMPATOR
+	}
+	return false
+}
+
+// pmpRange returns the physical address range [start, end) matched by the entry
+// Returns false if the entry is disabled
+func (rv32 *RISCV) pmpRange(i int) (start, end uint64, ok bool) {
+	addr := rv32.pmpaddr[i]
+	switch (rv32.pmpcfg[i] & PMPA) >> pmpAShift {
+	case PMPATOR:
+		if i > 0 {
+			start = rv32.pmpaddr[i-1] << 2
+		}
+		return start, addr << 2, true
+	case PMPANA4:
+		return addr << 2, addr<<2 + 4, true
+	case PMPANAPOT: // The number of trailing ones gives the size of the region
+		ones := bits.TrailingZeros64(^addr)
+		start = (addr &^ (1<<ones - 1)) << 2
+		return start, start + 8<<ones, true
+	}
+	return 0, 0, false
+}
+
+// pmpPermitted checks the access of size bytes at the physical address against the PMP
+// The lowest numbered entry that matches any byte of the access decides, and it must match all bytes
+// Machine mode accesses only use locked entries, and are allowed when no entry matches
+func (rv32 *RISCV) pmpPermitted(addr, size uint64, priv PrivilegeLevel, access AccessType) bool {
+	if len(rv32.pmpcfg) == 0 {
+		return true
+	}
+
+	last := addr + size - 1
+	for i, cfg := range rv32.pmpcfg {
+		start, end, ok := rv32.pmpRange(i)
+		if !ok || addr >= end || last < start {
+			continue
+		}
+		if addr < start || last >= end { // Partial match
+			return false
+		}
+		if priv == PrivilegeMachine && cfg&PMPLock == 0 {
+			return true
+		}
+		switch access {
+		case AccessFetch:
+			return cfg&PMPExecute > 0
+		case AccessLoad:
+			return cfg&PMPRead > 0
+		}
+		return cfg&PMPWrite > 0
+	}
+
+	return priv == PrivilegeMachine
+}
@@ -0,0 +1,156 @@
+package core
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// createPMPTestCPU creates a RV32 core with 16 PMP entries configured as:
+// 0: NAPOT 0x1000 - 0x1FFF R X
+// 1: NA4   0x2000 - 0x2003 R W
+// 3: TOR   0x3000 - 0x3FFF R W locked (pmpaddr2 is the bottom of the range)
+// The RAM is created by createTestCPU
+func createPMPTestCPU(t *testing.T) (*RISCV, []byte) {
+	cpu, memory := createTestCPU(t, []Option{WithISA("rv32imasu"), WithPMP(16)})
+
+	writes := []struct {
+		csr   uint32
+		value uint64
+	}{
+		{CSRPMPAddr0, 0x1000>>2 | (0x1000>>3 - 1)},
+		{CSRPMPAddr0 + 1, 0x2000 >> 2},
+		{CSRPMPAddr0 + 2, 0x3000 >> 2},
+		{CSRPMPAddr0 + 3, 0x4000 >> 2},
+		{CSRPMPCfg0, 0x8B_00_13_1D},
+	}
+	for _, w := range writes {
+		if err := cpu.CSR.Write(w.csr, w.value, PrivilegeMachine); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return cpu, memory
+}
+
+func TestPMP_Permitted(t *testing.T) {
+	cpu, _ := createPMPTestCPU(t)
+
+	tests := []struct {
+		name      string
+		priv      PrivilegeLevel
+		addr      uint64
+		size      uint64
+		access    AccessType
+		permitted bool
+	}{
+		{"user fetch napot", PrivilegeUser, 0x1004, 4, AccessFetch, true},
+		{"user load napot", PrivilegeUser, 0x1FFC, 4, AccessLoad, true},
+		{"user store napot", PrivilegeUser, 0x1004, 4, AccessStore, false},
+		{"user load na4", PrivilegeUser, 0x2000, 4, AccessLoad, true},
+		{"user store na4", PrivilegeUser, 0x2002, 2, AccessStore, true},
+		{"user load partial na4", PrivilegeUser, 0x2002, 4, AccessLoad, false},
+		{"user fetch na4", PrivilegeUser, 0x2000, 4, AccessFetch, false},
+		{"user load tor", PrivilegeUser, 0x3FFC, 4, AccessLoad, true},
+		{"supervisor store tor", PrivilegeSupervisor, 0x3000, 8, AccessStore, true},
+		{"user load no match", PrivilegeUser, 0x5000, 4, AccessLoad, false},
+		{"machine load no match", PrivilegeMachine, 0x5000, 4, AccessLoad, true},
+		{"machine store unlocked", PrivilegeMachine, 0x1000, 4, AccessStore, true},
+		{"machine fetch locked", PrivilegeMachine, 0x3000, 4, AccessFetch, false},
+		{"machine store locked", PrivilegeMachine, 0x3000, 4, AccessStore, true},
+	}
+
+	for _, test := range tests {
+		permitted := cpu.pmpPermitted(test.addr, test.size, test.priv, test.access)
+		if permitted != test.permitted {
+			t.Errorf("%s: Expected permitted to be %t but got %t", test.name, test.permitted, permitted)
+		}
+	}
+}
+
+func TestPMP_Lock(t *testing.T) {
+	cpu, _ := createPMPTestCPU(t)
+
+	// Locked entries ignores writes to pmpcfg and pmpaddr, including the bottom of a locked TOR range
+	if err := cpu.CSR.Write(CSRPMPCfg0, 0, PrivilegeMachine); err != nil {
+		t.Fatal(err)
+	}
+	if v := cpu.CSR.Get(CSRPMPCfg0); v != 0x8B_00_00_00 {
+		t.Errorf("Expected pmpcfg0 to be 8b000000 but got %08x", v)
+	}
+	for i, expected := range []uint64{0x1234, 0x1234, 0x3000 >> 2, 0x4000 >> 2} {
+		if err := cpu.CSR.Write(CSRPMPAddr0+uint32(i), 0x1234, PrivilegeMachine); err != nil {
+			t.Fatal(err)
+		}
+		if v := cpu.CSR.Get(CSRPMPAddr0 + uint32(i)); v != expected {
+			t.Errorf("Expected pmpaddr%d to be %08x but got %08x", i, expected, v)
+		}
+	}
+
+	// W without R is reserved
+	if err := cpu.CSR.Write(CSRPMPCfg0, 0x02, PrivilegeMachine); err != nil {
+		t.Fatal(err)
+	}
+	if v := cpu.CSR.Get(CSRPMPCfg0) & 0xFF; v != 0 {
+		t.Errorf("Expected pmp0cfg to be 00 but got %02x", v)
+	}
+
+	// Reset unlocks all entries
+	cpu.Reset()
+	if v := cpu.CSR.Get(CSRPMPCfg0); v != 0 {
+		t.Errorf("Expected pmpcfg0 to be 0 after reset but got %08x", v)
+	}
+}
+
+func TestPMP_CSRs(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		address uint32
+		exists  bool
+	}{
+		{"no pmp", nil, CSRPMPCfg0, false},
+		{"rv32 pmpcfg1", []Option{WithPMP(16)}, CSRPMPCfg0 + 1, true},
+		{"rv32 pmpcfg4", []Option{WithPMP(16)}, CSRPMPCfg0 + 4, false},
+		{"rv32 pmpaddr15", []Option{WithPMP(16)}, CSRPMPAddr0 + 15, true},
+		{"rv32 pmpaddr16", []Option{WithPMP(16)}, CSRPMPAddr0 + 16, false},
+		{"rv32 pmpcfg15", []Option{WithPMP(64)}, CSRPMPCfg0 + 15, true},
+		{"rv64 pmpcfg1", []Option{WithXLEN(64), WithPMP(16)}, CSRPMPCfg0 + 1, false},
+		{"rv64 pmpcfg2", []Option{WithXLEN(64), WithPMP(16)}, CSRPMPCfg0 + 2, true},
+	}
+
+	for _, test := range tests {
+		cpu := CreateEmulator(nil, test.opts...)
+		if cpu.CSR.Has(test.address) != test.exists {
+			t.Errorf("%s: Expected csr %03x to exist to be %t", test.name, test.address, test.exists)
+		}
+	}
+}
+
+func TestPMP_Execute(t *testing.T) {
+	cpu, memory := createPMPTestCPU(t)
+	ctx := context.Background()
+
+	binary.LittleEndian.PutUint32(memory[0x1000:], 0x00012083) // lw x1, 0(x2)
+	cpu.Registers.SetInteger(2, 0x5000)
+	cpu.priv = PrivilegeUser
+
+	tests := []struct {
+		name  string
+		pc    uint64
+		cause uint32
+		value uint64
+	}{
+		{"load outside", 0x1000, ExceptionLoadAccessFault, 0x5000},
+		{"fetch without X", 0x2000, ExceptionInstructionAccessFault, 0x2000},
+	}
+
+	for _, test := range tests {
+		cpu.SetPC(test.pc)
+		err := cpu.RunStep(ctx)
+		var ex Exception
+		if !errors.As(err, &ex) || ex.Cause != test.cause || ex.Value != test.value {
+			t.Errorf("%s: Expected exception %d with value %08x but got %v", test.name, test.cause, test.value, err)
+		}
+	}
+}
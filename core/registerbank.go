@@ -1,10 +1,16 @@
 package core
 
-import "github.com/sirupsen/logrus"
+import (
+	"github.com/sirupsen/logrus"
+	"math"
+)
+
+// nanBoxMask is the upper half of a float register holding a single precision value
+const nanBoxMask = 0xFFFFFFFF_00000000
 
 type RegisterBank struct {
-	integers [32]uint32
-	float    [32]float32
+	integers [32]uint64 // In RV32 mode only the lower 32 bits are used
+	float    [32]uint64 // Raw bits. Single precision values are NaN-boxed
 
 	log *logrus.Logger
 }
@@ -26,7 +32,7 @@ func (rb *RegisterBank) Reset() {
 }
 
 // SetInteger sets a integer register to the specified value
-func (rb *RegisterBank) SetInteger(registerNum, value uint32) {
+func (rb *RegisterBank) SetInteger(registerNum uint32, value uint64) {
 	if registerNum > 31 {
 		rb.log.Errorf("registerNum == %d and it is > 31", registerNum)
 		return
@@ -36,8 +42,18 @@ func (rb *RegisterBank) SetInteger(registerNum, value uint32) {
 	}
 }
 
-// SetFloat sets a float register to the specified value
+// SetFloat sets a float register to the specified single precision value
 func (rb *RegisterBank) SetFloat(registerNum uint32, value float32) {
+	rb.SetFloatBits(registerNum, nanBoxMask|uint64(math.Float32bits(value)))
+}
+
+// SetDouble sets a float register to the specified double precision value
+func (rb *RegisterBank) SetDouble(registerNum uint32, value float64) {
+	rb.SetFloatBits(registerNum, math.Float64bits(value))
+}
+
+// SetFloatBits sets the raw 64 bit content of a float register
+func (rb *RegisterBank) SetFloatBits(registerNum uint32, value uint64) {
 	if registerNum > 31 {
 		rb.log.Errorf("registerNum == %d and it is > 31", registerNum)
 		return
@@ -46,7 +62,7 @@ func (rb *RegisterBank) SetFloat(registerNum uint32, value float32) {
 }
 
 // GetInteger gets the value of a integer register
-func (rb *RegisterBank) GetInteger(registerNum uint32) uint32 {
+func (rb *RegisterBank) GetInteger(registerNum uint32) uint64 {
 	if registerNum > 31 {
 		rb.log.Errorf("registerNum == %d and it is > 31", registerNum)
 		return 0
@@ -57,11 +73,32 @@ func (rb *RegisterBank) GetInteger(registerNum uint32) uint32 {
 	return rb.integers[registerNum]
 }
 
-// GetFloat gets the value of a float register
+// GetFloat gets the single precision value of a float register
+// Values that are not properly NaN-boxed are read as the canonical NaN
 func (rb *RegisterBank) GetFloat(registerNum uint32) float32 {
+	return math.Float32frombits(uint32(rb.GetFloatSingleBits(registerNum)))
+}
+
+// GetDouble gets the double precision value of a float register
+func (rb *RegisterBank) GetDouble(registerNum uint32) float64 {
+	return math.Float64frombits(rb.GetFloatBits(registerNum))
+}
+
+// GetFloatBits gets the raw 64 bit content of a float register
+func (rb *RegisterBank) GetFloatBits(registerNum uint32) uint64 {
 	if registerNum > 31 {
 		rb.log.Errorf("registerNum == %d and it is > 31", registerNum)
 		return 0
 	}
 	return rb.float[registerNum]
 }
+
+// GetFloatSingleBits gets the single precision bits of a float register
+// Values that are not properly NaN-boxed are read as the canonical NaN
+func (rb *RegisterBank) GetFloatSingleBits(registerNum uint32) uint64 {
+	v := rb.GetFloatBits(registerNum)
+	if v&nanBoxMask != nanBoxMask {
+		return float32Format.canonicalNaN()
+	}
+	return v &^ nanBoxMask
+}
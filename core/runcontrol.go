@@ -0,0 +1,356 @@
+package core
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// RunState is the state of the emulation goroutine started by Start
+type RunState int
+
+const (
+	// StateStopped is the state when there is no emulation goroutine (before Start and after Stop)
+	StateStopped RunState = iota
+	// StateRunning is the state while the core runs instructions
+	StateRunning
+	// StatePaused is the state when the core was paused by a request, a breakpoint, a watchpoint or a step
+	StatePaused
+	// StateHalted is the state when the core stopped on a fault, it can be resumed like when paused
+	StateHalted
+)
+
+// String returns the name of the state
+func (s RunState) String() string {
+	switch s {
+	case StateStopped:
+		return "stopped"
+	case StateRunning:
+		return "running"
+	case StatePaused:
+		return "paused"
+	case StateHalted:
+		return "halted"
+	}
+	return "unknown"
+}
+
+// StopReason is the reason why the core stopped running
+type StopReason int
+
+const (
+	StopBreakpoint  StopReason = iota // The pc reached a breakpoint
+	StopStep                          // A single step was completed
+	StopFault                         // The core returned an error, like an exception with TrapPolicyStop
+	StopWatchpoint                    // An instruction accessed a watched address
+	StopHaltRequest                   // Pause was called
+)
+
+// String returns the description of the reason
+func (r StopReason) String() string {
+	switch r {
+	case StopBreakpoint:
+		return "breakpoint"
+	case StopStep:
+		return "step complete"
+	case StopFault:
+		return "fault"
+	case StopWatchpoint:
+		return "watchpoint"
+	case StopHaltRequest:
+		return "halt request"
+	}
+	return "unknown"
+}
+
+// StopEvent is sent to the subscribers each time the core stops running
+type StopEvent struct {
+	Reason  StopReason
+	PC      uint64 // Address of the next instruction
+	Address uint64 // Address of the access, for StopWatchpoint
+	Err     error  // The error, for StopFault
+}
+
+// stopEventBuffer is the number of events a subscriber can hold before new events are dropped
+const stopEventBuffer = 16
+
+// Start starts a goroutine with the RISC-V emulation
+// This resets the core and starts it as paused, so either Step or Continue should be run afterwards
+// RunStep and RunUntil must not be used while the goroutine is running
+func (rv32 *RISCV) Start() {
+	rv32.runLock.Lock()
+	defer rv32.runLock.Unlock()
+
+	if rv32.state == StateStopped {
+		rv32.reset()
+		rv32.state = StatePaused
+		go rv32.loop()
+	}
+}
+
+// Loop runs the emulation started by Start in the calling goroutine, until Stop is called
+// Use manually with care
+func (rv32 *RISCV) Loop() {
+	rv32.runLock.Lock()
+	if rv32.state != StateStopped {
+		rv32.runLock.Unlock()
+		return
+	}
+	rv32.reset()
+	rv32.state = StatePaused
+	rv32.runLock.Unlock()
+
+	rv32.loop()
+}
+
+// Stop stops the goroutine with the RISC-V emulation, and returns once it has finished
+func (rv32 *RISCV) Stop() {
+	rv32.runLock.Lock()
+	defer rv32.runLock.Unlock()
+
+	if rv32.state == StateStopped {
+		return
+	}
+	rv32.stopRequested = true
+	atomic.StoreInt32(&rv32.requests, 1)
+	rv32.runCond.Broadcast()
+	for rv32.state != StateStopped {
+		rv32.runCond.Wait()
+	}
+}
+
+// Step makes the RISC-V goroutine run a single instruction and pause
+// If the core is running, it pauses after the next instruction
+// This does nothing in standalone, and RunStep should be used when no goroutine has been started
+func (rv32 *RISCV) Step() {
+	rv32.runLock.Lock()
+	defer rv32.runLock.Unlock()
+
+	switch rv32.state {
+	case StatePaused, StateHalted:
+		rv32.state = StateRunning
+		rv32.stepping = true
+		rv32.runCond.Broadcast()
+	case StateRunning:
+		rv32.stepping = true
+		atomic.StoreInt32(&rv32.requests, 1)
+	}
+}
+
+// Pause pauses the RISC-V emulation goroutine
+// It returns once the core is paused, after the instruction it was running
+func (rv32 *RISCV) Pause() {
+	rv32.runLock.Lock()
+	defer rv32.runLock.Unlock()
+
+	if rv32.state != StateRunning {
+		return
+	}
+	rv32.pauseRequested = true
+	atomic.StoreInt32(&rv32.requests, 1)
+	for rv32.state == StateRunning {
+		rv32.runCond.Wait()
+	}
+}
+
+// Continue resumes the RISC-V emulation goroutine
+func (rv32 *RISCV) Continue() {
+	rv32.runLock.Lock()
+	defer rv32.runLock.Unlock()
+
+	if rv32.state == StatePaused || rv32.state == StateHalted {
+		rv32.state = StateRunning
+		rv32.stepping = false
+		rv32.runCond.Broadcast()
+	}
+}
+
+// Paused returns if the core is currently not running instructions
+func (rv32 *RISCV) Paused() bool {
+	return rv32.State() != StateRunning
+}
+
+// State returns the state of the RISC-V emulation goroutine
+func (rv32 *RISCV) State() RunState {
+	rv32.runLock.Lock()
+	defer rv32.runLock.Unlock()
+	return rv32.state
+}
+
+// Subscribe returns a channel that receives an event each time the RISC-V emulation goroutine stops running
+// Events are dropped when the channel is full, so a slow subscriber never blocks the core
+func (rv32 *RISCV) Subscribe() <-chan StopEvent {
+	rv32.runLock.Lock()
+	defer rv32.runLock.Unlock()
+
+	ch := make(chan StopEvent, stopEventBuffer)
+	rv32.subscribers = append(rv32.subscribers, ch)
+	return ch
+}
+
+// Unsubscribe stops sending events to a channel returned by Subscribe, and closes it
+func (rv32 *RISCV) Unsubscribe(ch <-chan StopEvent) {
+	rv32.runLock.Lock()
+	defer rv32.runLock.Unlock()
+
+	for i, subscriber := range rv32.subscribers {
+		if subscriber == ch {
+			rv32.subscribers = append(rv32.subscribers[:i], rv32.subscribers[i+1:]...)
+			close(subscriber)
+			return
+		}
+	}
+}
+
+// AddBreak adds a breakpoint in the specified address
+// A breakpoint will pause the CPU when is running by Start
+func (rv32 *RISCV) AddBreak(addr uint64) {
+	rv32.control(func() {
+		rv32.breakpoints[addr] = struct{}{}
+	})
+}
+
+// DelBreak deletes a breakpoint in the specified address
+func (rv32 *RISCV) DelBreak(addr uint64) {
+	rv32.control(func() {
+		delete(rv32.breakpoints, addr)
+	})
+}
+
+// AddWatch adds a watchpoint in the specified (virtual) address
+// A load or store that touches the address will pause the CPU after the instruction when is running by Start
+func (rv32 *RISCV) AddWatch(addr uint64) {
+	rv32.control(func() {
+		rv32.watchpoints[addr] = struct{}{}
+	})
+}
+
+// DelWatch deletes a watchpoint in the specified address
+func (rv32 *RISCV) DelWatch(addr uint64) {
+	rv32.control(func() {
+		delete(rv32.watchpoints, addr)
+	})
+}
+
+// checkWatchpoints records a hit if the size bytes accessed at the virtual address touch a watchpoint
+func (rv32 *RISCV) checkWatchpoints(addr, size uint64) {
+	for i := uint64(0); i < size; i++ {
+		if _, ok := rv32.watchpoints[(addr+i)&rv32.xlenMask]; ok {
+			rv32.watchHit = true
+			rv32.watchAddress = addr
+			return
+		}
+	}
+}
+
+// control runs f while the core is not running instructions, so f can change the state of the core
+// When the emulation goroutine is running, f is run by it between two instructions
+// It must not be called from the emulation goroutine (like from a tick handler)
+func (rv32 *RISCV) control(f func()) {
+	rv32.runLock.Lock()
+	defer rv32.runLock.Unlock()
+
+	if rv32.state != StateRunning {
+		f()
+		return
+	}
+
+	done := false
+	rv32.pending = append(rv32.pending, func() {
+		f()
+		done = true
+	})
+	atomic.StoreInt32(&rv32.requests, 1)
+	for !done {
+		rv32.runCond.Wait()
+	}
+}
+
+// loop runs the emulation goroutine until Stop
+// runLock is released while instructions run, and the requests of other goroutines are handled between them
+func (rv32 *RISCV) loop() {
+	ctx := context.Background()
+	rv32.runLock.Lock()
+	defer rv32.runLock.Unlock()
+
+	for !rv32.stopRequested {
+		if rv32.state != StateRunning {
+			rv32.runCond.Wait()
+			continue
+		}
+
+		step := rv32.stepping
+		rv32.runLock.Unlock()
+		event := rv32.runUntilStop(ctx, step)
+		rv32.runLock.Lock()
+
+		atomic.StoreInt32(&rv32.requests, 0)
+		for _, f := range rv32.pending {
+			f()
+		}
+		rv32.pending = nil
+		if event == nil && rv32.pauseRequested {
+			event = &StopEvent{Reason: StopHaltRequest, PC: rv32.pc}
+		}
+		rv32.pauseRequested = false
+		if event != nil {
+			rv32.stopped(*event)
+		}
+		rv32.runCond.Broadcast()
+	}
+
+	rv32.state = StateStopped
+	rv32.stopRequested = false
+	rv32.stepping = false
+	rv32.runCond.Broadcast()
+}
+
+// runUntilStop runs instructions until there is a reason to stop or another goroutine makes a request
+// Returns nil when it returned for a request
+func (rv32 *RISCV) runUntilStop(ctx context.Context, step bool) *StopEvent {
+	rv32.watchHit = false
+	for atomic.LoadInt32(&rv32.requests) == 0 {
+		var err error
+		if step {
+			err = rv32.RunStep(ctx)
+		} else {
+			err = rv32.run(ctx, noStop)
+		}
+
+		switch {
+		case err != nil:
+			rv32.log.Debugf("(RISCV) Error: %s", err)
+			return &StopEvent{Reason: StopFault, PC: rv32.pc, Err: err}
+		case rv32.watchHit:
+			rv32.watchHit = false
+			return &StopEvent{Reason: StopWatchpoint, PC: rv32.pc, Address: rv32.watchAddress}
+		case step:
+			return &StopEvent{Reason: StopStep, PC: rv32.pc}
+		}
+		if _, ok := rv32.breakpoints[rv32.pc]; ok {
+			return &StopEvent{Reason: StopBreakpoint, PC: rv32.pc}
+		}
+
+		if rv32.waiting && rv32.idleFastForward { // No event to fast forward to, wait for an interrupt from other goroutines
+			time.Sleep(time.Millisecond)
+		}
+	}
+	return nil
+}
+
+// stopped moves the core to the paused (or halted) state and sends the event to the subscribers
+func (rv32 *RISCV) stopped(event StopEvent) {
+	rv32.state = StatePaused
+	if event.Reason == StopFault {
+		rv32.state = StateHalted
+	}
+	rv32.stepping = false
+	rv32.log.Infof("Paused at %08x: %s", event.PC, event.Reason)
+
+	for _, ch := range rv32.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
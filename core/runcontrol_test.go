@@ -0,0 +1,132 @@
+package core
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// runControlProgram increments x1 and stores it at 0x100 forever
+var runControlProgram = []uint32{
+	0x00108093, // addi x1, x1, 1
+	0x10102023, // sw x1, 256(x0)
+	0xff9ff06f, // j -8
+}
+
+// waitStop waits for the next stop event
+func waitStop(t *testing.T, events <-chan StopEvent) StopEvent {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(time.Second):
+		t.Fatalf("Expected a stop event")
+	}
+	return StopEvent{}
+}
+
+func TestRunControl_States(t *testing.T) {
+	cpu, _ := createTestCPU(t, nil, runControlProgram...)
+	events := cpu.Subscribe()
+	if s := cpu.State(); s != StateStopped {
+		t.Errorf("Expected state %s but got %s", StateStopped, s)
+	}
+
+	cpu.Start()
+	defer cpu.Stop()
+	if s := cpu.State(); s != StatePaused {
+		t.Errorf("Expected state %s after start but got %s", StatePaused, s)
+	}
+
+	cpu.Step()
+	if event := waitStop(t, events); event.Reason != StopStep || event.PC != 0x04 {
+		t.Errorf("Expected %s at 00000004 but got %s at %08x", StopStep, event.Reason, event.PC)
+	}
+
+	cpu.Continue()
+	if s := cpu.State(); s != StateRunning && s != StatePaused {
+		t.Errorf("Expected state %s after continue but got %s", StateRunning, s)
+	}
+	time.Sleep(time.Millisecond)
+	cpu.Pause()
+	if s := cpu.State(); s != StatePaused {
+		t.Errorf("Expected state %s after pause but got %s", StatePaused, s)
+	}
+	if event := waitStop(t, events); event.Reason != StopHaltRequest {
+		t.Errorf("Expected %s but got %s", StopHaltRequest, event.Reason)
+	}
+	if v := cpu.Registers.GetInteger(1); v < 2 {
+		t.Errorf("Expected X01 to be incremented while running but got %d", v)
+	}
+
+	// Reset and breakpoints are safe while running, from any goroutine
+	cpu.Continue()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		cpu.Reset()
+	}()
+	go func() {
+		defer wg.Done()
+		cpu.AddBreak(0x08)
+	}()
+	wg.Wait()
+	if event := waitStop(t, events); event.Reason != StopBreakpoint || event.PC != 0x08 {
+		t.Errorf("Expected %s at 00000008 but got %s at %08x", StopBreakpoint, event.Reason, event.PC)
+	}
+	cpu.DelBreak(0x08)
+
+	cpu.Stop()
+	if s := cpu.State(); s != StateStopped {
+		t.Errorf("Expected state %s after stop but got %s", StateStopped, s)
+	}
+	cpu.Unsubscribe(events)
+	if _, ok := <-events; ok {
+		t.Errorf("Expected the channel to be closed after unsubscribe")
+	}
+}
+
+func TestRunControl_Watchpoint(t *testing.T) {
+	cpu, _ := createTestCPU(t, []Option{WithEngine(EngineBlocks)}, runControlProgram...)
+	events := cpu.Subscribe()
+	cpu.Start()
+	defer cpu.Stop()
+
+	cpu.AddWatch(0x102)
+	cpu.Continue()
+	event := waitStop(t, events)
+	if event.Reason != StopWatchpoint || event.PC != 0x08 || event.Address != 0x100 {
+		t.Errorf("Expected %s of 00000100 at 00000008 but got %s of %08x at %08x", StopWatchpoint, event.Reason, event.Address, event.PC)
+	}
+	if v := cpu.Registers.GetInteger(1); v != 1 {
+		t.Errorf("Expected X01 to be 1 but got %d", v)
+	}
+}
+
+func TestRunControl_Fault(t *testing.T) {
+	cpu, _ := createTestCPU(t, nil,
+		0x00108093, // addi x1, x1, 1
+		0x00000000, // illegal
+	)
+	events := cpu.Subscribe()
+	cpu.Start()
+	defer cpu.Stop()
+
+	cpu.Continue()
+	event := waitStop(t, events)
+	if event.Reason != StopFault || event.PC != 0x04 || event.Err == nil {
+		t.Errorf("Expected %s at 00000004 but got %s at %08x (%v)", StopFault, event.Reason, event.PC, event.Err)
+	}
+	if s := cpu.State(); s != StateHalted {
+		t.Errorf("Expected state %s but got %s", StateHalted, s)
+	}
+
+	cpu.Reset()
+	if s := cpu.State(); s != StatePaused {
+		t.Errorf("Expected state %s after reset but got %s", StatePaused, s)
+	}
+	if pc := cpu.GetPC(); pc != 0 {
+		t.Errorf("Expected pc to be 0 after reset but got %08x", pc)
+	}
+}
@@ -0,0 +1,564 @@
+package core
+
+import (
+	"math/big"
+)
+
+// Floating point exception flags (fflags)
+const (
+	FlagInexact   = 1 << 0 // NX
+	FlagUnderflow = 1 << 1 // UF
+	FlagOverflow  = 1 << 2 // OF
+	FlagDivByZero = 1 << 3 // DZ
+	FlagInvalid   = 1 << 4 // NV
+)
+
+// Floating point rounding modes (frm)
+const (
+	RoundNearestEven = 0 // RNE
+	RoundTowardZero  = 1 // RTZ
+	RoundDown        = 2 // RDN
+	RoundUp          = 3 // RUP
+	RoundNearestMax  = 4 // RMM
+	RoundDynamic     = 7 // DYN, uses frm
+)
+
+// softFloatPrecision is the precision used for intermediate results
+// Any value >= 2 * 53 + 2 keeps the results of all operations exact or rounded to odd
+const softFloatPrecision = 256
+
+// floatFormat describes an IEEE 754 binary floating point format
+// All operations work over the raw bits and follow the RISC-V rules (canonical NaNs, tininess after rounding)
+type floatFormat struct {
+	expBits  uint
+	mantBits uint
+}
+
+var (
+	float32Format = floatFormat{expBits: 8, mantBits: 23}
+	float64Format = floatFormat{expBits: 11, mantBits: 52}
+)
+
+var bigHalf = big.NewFloat(0.5)
+
+func (f floatFormat) bias() int {
+	return 1<<(f.expBits-1) - 1
+}
+
+func (f floatFormat) expMax() uint64 {
+	return 1<<f.expBits - 1
+}
+
+func (f floatFormat) mantMask() uint64 {
+	return 1<<f.mantBits - 1
+}
+
+func (f floatFormat) signBit() uint64 {
+	return 1 << (f.expBits + f.mantBits)
+}
+
+func (f floatFormat) exp(bits uint64) uint64 {
+	return (bits >> f.mantBits) & f.expMax()
+}
+
+func (f floatFormat) sign(bits uint64) bool {
+	return bits&f.signBit() > 0
+}
+
+func (f floatFormat) zero(sign bool) uint64 {
+	if sign {
+		return f.signBit()
+	}
+	return 0
+}
+
+func (f floatFormat) inf(sign bool) uint64 {
+	return f.zero(sign) | f.expMax()<<f.mantBits
+}
+
+func (f floatFormat) maxFinite(sign bool) uint64 {
+	return f.zero(sign) | (f.expMax()-1)<<f.mantBits | f.mantMask()
+}
+
+func (f floatFormat) canonicalNaN() uint64 {
+	return f.expMax()<<f.mantBits | 1<<(f.mantBits-1)
+}
+
+func (f floatFormat) isNaN(bits uint64) bool {
+	return f.exp(bits) == f.expMax() && bits&f.mantMask() != 0
+}
+
+func (f floatFormat) isSignalingNaN(bits uint64) bool {
+	return f.isNaN(bits) && bits&(1<<(f.mantBits-1)) == 0
+}
+
+func (f floatFormat) isInf(bits uint64) bool {
+	return f.exp(bits) == f.expMax() && bits&f.mantMask() == 0
+}
+
+func (f floatFormat) isZero(bits uint64) bool {
+	return bits&^f.signBit() == 0
+}
+
+// toBig returns the exact value of a non-NaN floating point number
+func (f floatFormat) toBig(bits uint64) *big.Float {
+	v := new(big.Float).SetPrec(softFloatPrecision)
+	if f.isInf(bits) {
+		return v.SetInf(f.sign(bits))
+	}
+
+	exp := int(f.exp(bits))
+	mant := bits & f.mantMask()
+	if exp == 0 { // Subnormal
+		exp = 1
+	} else {
+		mant |= 1 << f.mantBits
+	}
+
+	v.SetMantExp(new(big.Float).SetUint64(mant), exp-f.bias()-int(f.mantBits))
+	if f.sign(bits) {
+		v.Neg(v)
+	}
+	return v
+}
+
+// roundToInteger rounds |v| * 2^-q to an integer using the rounding mode
+// sticky means the real value is slightly bigger in magnitude than v
+func roundToInteger(v *big.Float, q int, sticky bool, rm uint32) (*big.Int, bool) {
+	scaled := new(big.Float).SetMantExp(v, -q)
+	scaled.Abs(scaled)
+
+	intPart, _ := scaled.Int(nil)
+	frac := new(big.Float).SetPrec(softFloatPrecision)
+	frac.Sub(scaled, new(big.Float).SetInt(intPart))
+
+	inexact := frac.Sign() != 0 || sticky
+	up := false
+	switch rm {
+	case RoundNearestEven:
+		c := frac.Cmp(bigHalf)
+		up = c > 0 || (c == 0 && (sticky || intPart.Bit(0) == 1))
+	case RoundDown:
+		up = inexact && v.Signbit()
+	case RoundUp:
+		up = inexact && !v.Signbit()
+	case RoundNearestMax:
+		up = frac.Cmp(bigHalf) >= 0
+	}
+
+	if up {
+		intPart.Add(intPart, big.NewInt(1))
+	}
+
+	return intPart, inexact
+}
+
+// overflow returns the result of a overflow in the specified rounding mode
+func (f floatFormat) overflow(sign bool, rm uint32) uint64 {
+	switch rm {
+	case RoundTowardZero:
+		return f.maxFinite(sign)
+	case RoundDown:
+		if !sign {
+			return f.maxFinite(sign)
+		}
+	case RoundUp:
+		if sign {
+			return f.maxFinite(sign)
+		}
+	}
+	return f.inf(sign)
+}
+
+// round rounds v to the floating point format using the rounding mode
+// sticky means the real value is slightly bigger in magnitude than v (v was truncated)
+func (f floatFormat) round(v *big.Float, sticky bool, rm uint32) (uint64, uint32) {
+	sign := v.Signbit()
+	if v.IsInf() {
+		return f.inf(sign), 0
+	}
+	if v.Sign() == 0 {
+		return f.zero(sign), 0
+	}
+
+	m := int(f.mantBits)
+	emin := 1 - f.bias()
+	e := v.MantExp(nil) - 1 // v in [2^e, 2^(e+1))
+	q := e - m
+	if q < emin-m { // Subnormal range has fixed quantum
+		q = emin - m
+	}
+
+	i, inexact := roundToInteger(v, q, sticky, rm)
+
+	// Tininess is detected after rounding, so check if rounding with unbounded exponent would reach 2^emin
+	tiny := e < emin
+	if e == emin-1 {
+		i2, _ := roundToInteger(v, e-m, sticky, rm)
+		if i2.BitLen() > m+1 {
+			tiny = false
+		}
+	}
+
+	flags := uint32(0)
+	if inexact {
+		flags |= FlagInexact
+		if tiny {
+			flags |= FlagUnderflow
+		}
+	}
+
+	if i.BitLen() > m+1 { // Rounding carried to the next binade
+		i.Rsh(i, 1)
+		q++
+	}
+
+	mant := i.Uint64()
+	if mant == 0 {
+		return f.zero(sign), flags
+	}
+	if mant < 1<<f.mantBits { // Subnormal
+		return f.zero(sign) | mant, flags
+	}
+
+	exp := uint64(q + m + f.bias())
+	if exp >= f.expMax() {
+		return f.overflow(sign, rm), flags | FlagOverflow | FlagInexact
+	}
+
+	return f.zero(sign) | exp<<f.mantBits | (mant & f.mantMask()), flags
+}
+
+// nanResult returns canonical NaN and the invalid flag if any operand is a signaling NaN
+func (f floatFormat) nanResult(operands ...uint64) (uint64, uint32) {
+	flags := uint32(0)
+	for _, o := range operands {
+		if f.isSignalingNaN(o) {
+			flags |= FlagInvalid
+		}
+	}
+	return f.canonicalNaN(), flags
+}
+
+// exactZeroSign returns the sign of a exact zero result of a sum with operands of signs a and b
+func exactZeroSign(a, b bool, rm uint32) bool {
+	if a == b {
+		return a
+	}
+	return rm == RoundDown
+}
+
+// add returns a + b
+func (f floatFormat) add(a, b uint64, rm uint32) (uint64, uint32) {
+	if f.isNaN(a) || f.isNaN(b) {
+		return f.nanResult(a, b)
+	}
+	if f.isInf(a) && f.isInf(b) && f.sign(a) != f.sign(b) {
+		return f.canonicalNaN(), FlagInvalid
+	}
+	if f.isInf(a) {
+		return a, 0
+	}
+	if f.isInf(b) {
+		return b, 0
+	}
+
+	z := new(big.Float).SetPrec(softFloatPrecision).SetMode(big.ToZero)
+	z.Add(f.toBig(a), f.toBig(b))
+	sticky := z.Acc() != big.Exact
+
+	if z.Sign() == 0 && !sticky {
+		return f.zero(exactZeroSign(f.sign(a), f.sign(b), rm)), 0
+	}
+
+	return f.round(z, sticky, rm)
+}
+
+// sub returns a - b
+func (f floatFormat) sub(a, b uint64, rm uint32) (uint64, uint32) {
+	if f.isNaN(b) {
+		return f.nanResult(a, b)
+	}
+	return f.add(a, b^f.signBit(), rm)
+}
+
+// mul returns a * b
+func (f floatFormat) mul(a, b uint64, rm uint32) (uint64, uint32) {
+	if f.isNaN(a) || f.isNaN(b) {
+		return f.nanResult(a, b)
+	}
+	sign := f.sign(a) != f.sign(b)
+	if (f.isInf(a) && f.isZero(b)) || (f.isZero(a) && f.isInf(b)) {
+		return f.canonicalNaN(), FlagInvalid
+	}
+	if f.isInf(a) || f.isInf(b) {
+		return f.inf(sign), 0
+	}
+	if f.isZero(a) || f.isZero(b) {
+		return f.zero(sign), 0
+	}
+
+	z := new(big.Float).SetPrec(softFloatPrecision).SetMode(big.ToZero)
+	z.Mul(f.toBig(a), f.toBig(b))
+	return f.round(z, z.Acc() != big.Exact, rm)
+}
+
+// div returns a / b
+func (f floatFormat) div(a, b uint64, rm uint32) (uint64, uint32) {
+	if f.isNaN(a) || f.isNaN(b) {
+		return f.nanResult(a, b)
+	}
+	sign := f.sign(a) != f.sign(b)
+	if (f.isInf(a) && f.isInf(b)) || (f.isZero(a) && f.isZero(b)) {
+		return f.canonicalNaN(), FlagInvalid
+	}
+	if f.isInf(a) {
+		return f.inf(sign), 0
+	}
+	if f.isZero(b) {
+		return f.inf(sign), FlagDivByZero
+	}
+	if f.isInf(b) || f.isZero(a) {
+		return f.zero(sign), 0
+	}
+
+	z := new(big.Float).SetPrec(softFloatPrecision).SetMode(big.ToZero)
+	z.Quo(f.toBig(a), f.toBig(b))
+	return f.round(z, z.Acc() != big.Exact, rm)
+}
+
+// sqrt returns the square root of a
+func (f floatFormat) sqrt(a uint64, rm uint32) (uint64, uint32) {
+	if f.isNaN(a) {
+		return f.nanResult(a)
+	}
+	if f.isZero(a) {
+		return a, 0
+	}
+	if f.sign(a) {
+		return f.canonicalNaN(), FlagInvalid
+	}
+	if f.isInf(a) {
+		return a, 0
+	}
+
+	x := f.toBig(a)
+	z := new(big.Float).SetPrec(softFloatPrecision).SetMode(big.ToZero)
+	z.Sqrt(x)
+
+	// big.Float.Sqrt does not report accuracy, so check the result squaring it
+	sq := new(big.Float).SetPrec(2*softFloatPrecision).Mul(z, z)
+	sticky := true
+	switch sq.Cmp(x) {
+	case 0:
+		sticky = false
+	case 1: // Result is above the real root, truncate it
+		ulp := new(big.Float).SetMantExp(big.NewFloat(1), z.MantExp(nil)-softFloatPrecision)
+		z.Sub(z, ulp)
+	}
+
+	return f.round(z, sticky, rm)
+}
+
+// fma returns (a * b) + c. negProduct negates the product, negAddend negates c
+func (f floatFormat) fma(a, b, c uint64, negProduct, negAddend bool, rm uint32) (uint64, uint32) {
+	productInvalid := (f.isInf(a) && f.isZero(b)) || (f.isZero(a) && f.isInf(b))
+	if f.isNaN(a) || f.isNaN(b) || f.isNaN(c) {
+		result, flags := f.nanResult(a, b, c)
+		if productInvalid {
+			flags |= FlagInvalid
+		}
+		return result, flags
+	}
+	if productInvalid {
+		return f.canonicalNaN(), FlagInvalid
+	}
+
+	if negAddend {
+		c ^= f.signBit()
+	}
+	productSign := (f.sign(a) != f.sign(b)) != negProduct
+
+	if f.isInf(a) || f.isInf(b) {
+		if f.isInf(c) && f.sign(c) != productSign {
+			return f.canonicalNaN(), FlagInvalid
+		}
+		return f.inf(productSign), 0
+	}
+	if f.isInf(c) {
+		return c, 0
+	}
+
+	product := new(big.Float).SetPrec(softFloatPrecision).Mul(f.toBig(a), f.toBig(b))
+	if negProduct {
+		product.Neg(product)
+	}
+
+	z := new(big.Float).SetPrec(softFloatPrecision).SetMode(big.ToZero)
+	z.Add(product, f.toBig(c))
+	sticky := z.Acc() != big.Exact
+
+	if z.Sign() == 0 && !sticky {
+		return f.zero(exactZeroSign(productSign, f.sign(c), rm)), 0
+	}
+
+	return f.round(z, sticky, rm)
+}
+
+// minMax returns the minimum or maximum number following IEEE 754-2019 minimumNumber / maximumNumber
+func (f floatFormat) minMax(a, b uint64, max bool) (uint64, uint32) {
+	flags := uint32(0)
+	if f.isSignalingNaN(a) || f.isSignalingNaN(b) {
+		flags = FlagInvalid
+	}
+	if f.isNaN(a) && f.isNaN(b) {
+		return f.canonicalNaN(), flags
+	}
+	if f.isNaN(a) {
+		return b, flags
+	}
+	if f.isNaN(b) {
+		return a, flags
+	}
+
+	c := f.toBig(a).Cmp(f.toBig(b))
+	if c == 0 { // -0 is lesser than +0
+		if f.sign(a) != max {
+			return a, flags
+		}
+		return b, flags
+	}
+
+	if (c < 0) != max {
+		return a, flags
+	}
+	return b, flags
+}
+
+// compare compares a and b. Returns a == b, a < b, a <= b
+// signaling is true for FLT / FLE, which raises invalid on quiet NaNs as well
+func (f floatFormat) compare(a, b uint64, signaling bool) (bool, bool, bool, uint32) {
+	if f.isNaN(a) || f.isNaN(b) {
+		if signaling || f.isSignalingNaN(a) || f.isSignalingNaN(b) {
+			return false, false, false, FlagInvalid
+		}
+		return false, false, false, 0
+	}
+
+	c := f.toBig(a).Cmp(f.toBig(b))
+	return c == 0, c < 0, c <= 0, 0
+}
+
+// class returns the fclass mask of the number
+func (f floatFormat) class(a uint64) uint32 {
+	sign := f.sign(a)
+	exp := f.exp(a)
+	switch {
+	case f.isInf(a) && sign:
+		return 1 << 0
+	case f.isInf(a):
+		return 1 << 7
+	case f.isSignalingNaN(a):
+		return 1 << 8
+	case f.isNaN(a):
+		return 1 << 9
+	case f.isZero(a) && sign:
+		return 1 << 3
+	case f.isZero(a):
+		return 1 << 4
+	case exp == 0 && sign:
+		return 1 << 2
+	case exp == 0:
+		return 1 << 5
+	case sign:
+		return 1 << 1
+	}
+	return 1 << 6
+}
+
+// toInt converts the number to a signed or unsigned integer of width bits
+// The result is returned sign extended to 64 bits
+func (f floatFormat) toInt(a uint64, signed bool, width uint, rm uint32) (uint64, uint32) {
+	maxValue := new(big.Int).Lsh(big.NewInt(1), width) // Unsigned max + 1
+	minValue := new(big.Int)
+	if signed {
+		maxValue.Rsh(maxValue, 1)
+		minValue.Neg(maxValue)
+	}
+	maxValue.Sub(maxValue, big.NewInt(1))
+
+	saturate := func(negative bool) (uint64, uint32) {
+		if negative {
+			return uint64(minValue.Int64()), FlagInvalid
+		}
+		if signed {
+			return uint64(maxValue.Int64()), FlagInvalid
+		}
+		return signExtend64(maxValue.Uint64(), width), FlagInvalid
+	}
+
+	if f.isNaN(a) {
+		return saturate(false)
+	}
+	if f.isInf(a) {
+		return saturate(f.sign(a))
+	}
+
+	v := f.toBig(a)
+	i, inexact := roundToInteger(v, 0, false, rm)
+	if v.Signbit() {
+		i.Neg(i)
+	}
+
+	if i.Cmp(minValue) < 0 {
+		return saturate(true)
+	}
+	if i.Cmp(maxValue) > 0 {
+		return saturate(false)
+	}
+
+	flags := uint32(0)
+	if inexact {
+		flags = FlagInexact
+	}
+
+	if signed {
+		return uint64(i.Int64()), flags
+	}
+	return signExtend64(i.Uint64(), width), flags
+}
+
+// fromInt converts a integer to the floating point format
+func (f floatFormat) fromInt(value uint64, signed bool, rm uint32) (uint64, uint32) {
+	v := new(big.Float).SetPrec(softFloatPrecision)
+	if signed {
+		v.SetInt64(int64(value))
+	} else {
+		v.SetUint64(value)
+	}
+	return f.round(v, false, rm)
+}
+
+// convert converts the number a from format from to format f
+func (f floatFormat) convert(a uint64, from floatFormat, rm uint32) (uint64, uint32) {
+	if from.isNaN(a) {
+		_, flags := from.nanResult(a)
+		return f.canonicalNaN(), flags
+	}
+	if from.isInf(a) {
+		return f.inf(from.sign(a)), 0
+	}
+	if from.isZero(a) {
+		return f.zero(from.sign(a)), 0
+	}
+	return f.round(from.toBig(a), false, rm)
+}
+
+// signExtend64 sign extends a value of the specified bits to 64 bits
+func signExtend64(value uint64, bits uint) uint64 {
+	if bits >= 64 {
+		return value
+	}
+	shift := 64 - bits
+	return uint64(int64(value<<shift) >> shift)
+}
@@ -0,0 +1,166 @@
+package core
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// randomFloatBits returns random floats biased to interesting exponents (subnormals, overflow, special values)
+func randomFloatBits(f floatFormat) uint64 {
+	sign := uint64(rand.Intn(2)) << (f.expBits + f.mantBits)
+	mant := rand.Uint64() & f.mantMask()
+	exp := uint64(0)
+	switch rand.Intn(6) {
+	case 0:
+		exp = 0
+	case 1:
+		exp = f.expMax() - 1 - uint64(rand.Intn(2))
+	case 2:
+		exp = f.expMax()
+	default:
+		exp = uint64(f.bias()) + uint64(rand.Intn(64)) - 32
+	}
+	return sign | exp<<f.mantBits | mant
+}
+
+func checkSoftFloat(t *testing.T, name string, f floatFormat, expected, got uint64, args ...uint64) {
+	if f.isNaN(expected) {
+		if got != f.canonicalNaN() {
+			t.Errorf("failed %s for %x: expected canonical NaN got %x", name, args, got)
+		}
+		return
+	}
+	if expected != got {
+		t.Errorf("failed %s for %x: expected %x got %x", name, args, expected, got)
+	}
+}
+
+func TestSoftFloatNearestEven(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+
+	for i := 0; i < numRounds*8; i++ {
+		a := randomFloatBits(float32Format)
+		b := randomFloatBits(float32Format)
+		x := math.Float32frombits(uint32(a))
+		y := math.Float32frombits(uint32(b))
+
+		r, _ := float32Format.add(a, b, RoundNearestEven)
+		checkSoftFloat(t, "fadd.s", float32Format, uint64(math.Float32bits(x+y)), r, a, b)
+		r, _ = float32Format.sub(a, b, RoundNearestEven)
+		checkSoftFloat(t, "fsub.s", float32Format, uint64(math.Float32bits(x-y)), r, a, b)
+		r, _ = float32Format.mul(a, b, RoundNearestEven)
+		checkSoftFloat(t, "fmul.s", float32Format, uint64(math.Float32bits(x*y)), r, a, b)
+		r, _ = float32Format.div(a, b, RoundNearestEven)
+		checkSoftFloat(t, "fdiv.s", float32Format, uint64(math.Float32bits(x/y)), r, a, b)
+		r, _ = float32Format.sqrt(a, RoundNearestEven)
+		checkSoftFloat(t, "fsqrt.s", float32Format, uint64(math.Float32bits(float32(math.Sqrt(float64(x))))), r, a)
+		r, _ = float64Format.convert(a, float32Format, RoundNearestEven)
+		r, _ = float32Format.convert(r, float64Format, RoundNearestEven)
+		checkSoftFloat(t, "fcvt.d.s", float32Format, a, r, a)
+	}
+
+	for i := 0; i < numRounds*8; i++ {
+		a := randomFloatBits(float64Format)
+		b := randomFloatBits(float64Format)
+		c := randomFloatBits(float64Format)
+		x := math.Float64frombits(a)
+		y := math.Float64frombits(b)
+		z := math.Float64frombits(c)
+
+		r, _ := float64Format.add(a, b, RoundNearestEven)
+		checkSoftFloat(t, "fadd.d", float64Format, math.Float64bits(x+y), r, a, b)
+		r, _ = float64Format.mul(a, b, RoundNearestEven)
+		checkSoftFloat(t, "fmul.d", float64Format, math.Float64bits(x*y), r, a, b)
+		r, _ = float64Format.div(a, b, RoundNearestEven)
+		checkSoftFloat(t, "fdiv.d", float64Format, math.Float64bits(x/y), r, a, b)
+		r, _ = float64Format.sqrt(a, RoundNearestEven)
+		checkSoftFloat(t, "fsqrt.d", float64Format, math.Float64bits(math.Sqrt(x)), r, a)
+		r, _ = float64Format.fma(a, b, c, false, false, RoundNearestEven)
+		checkSoftFloat(t, "fmadd.d", float64Format, math.Float64bits(math.FMA(x, y, z)), r, a, b, c)
+		r, _ = float32Format.convert(a, float64Format, RoundNearestEven)
+		checkSoftFloat(t, "fcvt.s.d", float32Format, uint64(math.Float32bits(float32(x))), r, a)
+	}
+}
+
+func TestSoftFloatCornerCases(t *testing.T) {
+	one := uint64(0x3f800000)
+	three := uint64(0x40400000)
+	minusOne := uint64(0xbf800000)
+	maxFloat := float32Format.maxFinite(false)
+	minNormal := uint64(0x00800000)
+	snan := uint64(0x7f800001)
+	inf := float32Format.inf(false)
+
+	tests := []struct {
+		name     string
+		op       func(rm uint32) (uint64, uint32)
+		rm       uint32
+		expected uint64
+		flags    uint32
+	}{
+		{"1/3 rne", func(rm uint32) (uint64, uint32) { return float32Format.div(one, three, rm) }, RoundNearestEven, 0x3eaaaaab, FlagInexact},
+		{"1/3 rtz", func(rm uint32) (uint64, uint32) { return float32Format.div(one, three, rm) }, RoundTowardZero, 0x3eaaaaaa, FlagInexact},
+		{"1/3 rdn", func(rm uint32) (uint64, uint32) { return float32Format.div(one, three, rm) }, RoundDown, 0x3eaaaaaa, FlagInexact},
+		{"1/3 rup", func(rm uint32) (uint64, uint32) { return float32Format.div(one, three, rm) }, RoundUp, 0x3eaaaaab, FlagInexact},
+		{"-1/3 rdn", func(rm uint32) (uint64, uint32) { return float32Format.div(minusOne, three, rm) }, RoundDown, 0xbeaaaaab, FlagInexact},
+		{"-1/3 rup", func(rm uint32) (uint64, uint32) { return float32Format.div(minusOne, three, rm) }, RoundUp, 0xbeaaaaaa, FlagInexact},
+		{"1+2^-24 rne tie", func(rm uint32) (uint64, uint32) { return float32Format.add(one, 0x33800000, rm) }, RoundNearestEven, one, FlagInexact},
+		{"1+2^-24 rmm tie", func(rm uint32) (uint64, uint32) { return float32Format.add(one, 0x33800000, rm) }, RoundNearestMax, 0x3f800001, FlagInexact},
+		{"1-1 rne", func(rm uint32) (uint64, uint32) { return float32Format.sub(one, one, rm) }, RoundNearestEven, 0, 0},
+		{"1-1 rdn", func(rm uint32) (uint64, uint32) { return float32Format.sub(one, one, rm) }, RoundDown, 0x80000000, 0},
+		{"max*2 rne", func(rm uint32) (uint64, uint32) { return float32Format.mul(maxFloat, 0x40000000, rm) }, RoundNearestEven, inf, FlagOverflow | FlagInexact},
+		{"max*2 rtz", func(rm uint32) (uint64, uint32) { return float32Format.mul(maxFloat, 0x40000000, rm) }, RoundTowardZero, maxFloat, FlagOverflow | FlagInexact},
+		{"min/3 underflow", func(rm uint32) (uint64, uint32) { return float32Format.div(minNormal, three, rm) }, RoundNearestEven, 0x002aaaab, FlagUnderflow | FlagInexact},
+		{"min/2 exact", func(rm uint32) (uint64, uint32) { return float32Format.div(minNormal, 0x40000000, rm) }, RoundNearestEven, 0x00400000, 0},
+		{"1/0", func(rm uint32) (uint64, uint32) { return float32Format.div(one, 0, rm) }, RoundNearestEven, inf, FlagDivByZero},
+		{"0/0", func(rm uint32) (uint64, uint32) { return float32Format.div(0, 0, rm) }, RoundNearestEven, float32Format.canonicalNaN(), FlagInvalid},
+		{"inf-inf", func(rm uint32) (uint64, uint32) { return float32Format.sub(inf, inf, rm) }, RoundNearestEven, float32Format.canonicalNaN(), FlagInvalid},
+		{"sqrt(-1)", func(rm uint32) (uint64, uint32) { return float32Format.sqrt(minusOne, rm) }, RoundNearestEven, float32Format.canonicalNaN(), FlagInvalid},
+		{"snan+1", func(rm uint32) (uint64, uint32) { return float32Format.add(snan, one, rm) }, RoundNearestEven, float32Format.canonicalNaN(), FlagInvalid},
+		{"min(snan, 1)", func(rm uint32) (uint64, uint32) { return float32Format.minMax(snan, one, false) }, RoundNearestEven, one, FlagInvalid},
+		{"min(-0, 0)", func(rm uint32) (uint64, uint32) { return float32Format.minMax(0, 0x80000000, false) }, RoundNearestEven, 0x80000000, 0},
+		{"max(-0, 0)", func(rm uint32) (uint64, uint32) { return float32Format.minMax(0x80000000, 0, true) }, RoundNearestEven, 0, 0},
+		{"fma(inf, 0, qnan)", func(rm uint32) (uint64, uint32) { return float32Format.fma(inf, 0, 0x7fc00000, false, false, rm) }, RoundNearestEven, float32Format.canonicalNaN(), FlagInvalid},
+		{"fcvt.w.s(2.5) rne", func(rm uint32) (uint64, uint32) { return float32Format.toInt(0x40200000, true, 32, rm) }, RoundNearestEven, 2, FlagInexact},
+		{"fcvt.w.s(2.5) rmm", func(rm uint32) (uint64, uint32) { return float32Format.toInt(0x40200000, true, 32, rm) }, RoundNearestMax, 3, FlagInexact},
+		{"fcvt.w.s(-2.5) rdn", func(rm uint32) (uint64, uint32) { return float32Format.toInt(0xc0200000, true, 32, rm) }, RoundDown, 0xFFFFFFFF_FFFFFFFD, FlagInexact},
+		{"fcvt.w.s(inf)", func(rm uint32) (uint64, uint32) { return float32Format.toInt(inf, true, 32, rm) }, RoundNearestEven, 0x7FFFFFFF, FlagInvalid},
+		{"fcvt.w.s(nan)", func(rm uint32) (uint64, uint32) { return float32Format.toInt(snan, true, 32, rm) }, RoundNearestEven, 0x7FFFFFFF, FlagInvalid},
+		{"fcvt.w.s(-2^32)", func(rm uint32) (uint64, uint32) { return float32Format.toInt(0xcf800000, true, 32, rm) }, RoundNearestEven, 0xFFFFFFFF_80000000, FlagInvalid},
+		{"fcvt.wu.s(-1)", func(rm uint32) (uint64, uint32) { return float32Format.toInt(minusOne, false, 32, rm) }, RoundNearestEven, 0, FlagInvalid},
+		{"fcvt.wu.s(-0.25) rtz", func(rm uint32) (uint64, uint32) { return float32Format.toInt(0xbe800000, false, 32, rm) }, RoundTowardZero, 0, FlagInexact},
+		{"fcvt.wu.s(inf)", func(rm uint32) (uint64, uint32) { return float32Format.toInt(inf, false, 32, rm) }, RoundNearestEven, 0xFFFFFFFF_FFFFFFFF, FlagInvalid},
+		{"fcvt.s.w(2^24+1)", func(rm uint32) (uint64, uint32) { return float32Format.fromInt(0x1000001, true, rm) }, RoundNearestEven, 0x4b800000, FlagInexact},
+		{"fcvt.s.w(2^24+1) rup", func(rm uint32) (uint64, uint32) { return float32Format.fromInt(0x1000001, true, rm) }, RoundUp, 0x4b800001, FlagInexact},
+	}
+
+	for _, test := range tests {
+		result, flags := test.op(test.rm)
+		if result != test.expected || flags != test.flags {
+			t.Errorf("failed %s: expected %x (flags %02x) got %x (flags %02x)", test.name, test.expected, test.flags, result, flags)
+		}
+	}
+}
+
+func TestSoftFloatClass(t *testing.T) {
+	tests := map[uint64]uint32{
+		0xff800000: 1 << 0,
+		0xbf800000: 1 << 1,
+		0x80000001: 1 << 2,
+		0x80000000: 1 << 3,
+		0x00000000: 1 << 4,
+		0x00000001: 1 << 5,
+		0x3f800000: 1 << 6,
+		0x7f800000: 1 << 7,
+		0x7f800001: 1 << 8,
+		0x7fc00000: 1 << 9,
+	}
+
+	for bits, expected := range tests {
+		if c := float32Format.class(bits); c != expected {
+			t.Errorf("failed fclass.s for %08x: expected %03x got %03x", bits, expected, c)
+		}
+	}
+}
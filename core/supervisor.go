@@ -0,0 +1,157 @@
+package core
+
+import "sync/atomic"
+
+// Supervisor CSR addresses
+const (
+	CSRSStatus  = 0x100
+	CSRSIE      = 0x104
+	CSRSTVec    = 0x105
+	CSRSScratch = 0x140
+	CSRSEPC     = 0x141
+	CSRSCause   = 0x142
+	CSRSTVal    = 0x143
+	CSRSIP      = 0x144
+
+	CSRMEDeleg = 0x302
+	CSRMIDeleg = 0x303
+)
+
+// medelegMask are the exceptions that can be delegated to supervisor mode
+// Environment calls from machine mode are always taken in machine mode
+const medelegMask = 1<<ExceptionInstructionAddressMisaligned |
+	1<<ExceptionInstructionAccessFault |
+	1<<ExceptionIllegalInstruction |
+	1<<ExceptionBreakpoint |
+	1<<ExceptionLoadAddressMisaligned |
+	1<<ExceptionLoadAccessFault |
+	1<<ExceptionStoreAddressMisaligned |
+	1<<ExceptionStoreAccessFault |
+	1<<ExceptionEnvironmentCallFromUMode |
+	1<<ExceptionEnvironmentCallFromSMode |
+	1<<ExceptionInstructionPageFault |
+	1<<ExceptionLoadPageFault |
+	1<<ExceptionStorePageFault
+
+// registerSupervisorCSRs registers the supervisor mode CSRs and the trap delegation registers
+// sstatus, sie and sip are restricted views of mstatus, mie and mip
+func (rv32 *RISCV) registerSupervisorCSRs() {
+	if !rv32.isa.Has(MISAExtS) {
+		return
+	}
+
+	sstatusWriteMask := uint64(MStatusSIE | MStatusSPIE | MStatusSPP | MStatusSUM | MStatusMXR)
+	if rv32.isa.Has(MISAExtF) {
+		sstatusWriteMask |= MStatusFS
+	}
+	sstatusReadMask := sstatusWriteMask | MStatusFS | MStatusUXL | rv32.mstatusSD()
+
+	csrs := map[uint32]CSR{
+		CSRSStatus: {
+			Name:      "sstatus",
+			WriteMask: sstatusWriteMask,
+			RHandler:  func() uint64 { return rv32.mstatus & sstatusReadMask },
+			WHandler: func(value uint64) {
+				rv32.CSR.Set(CSRMStatus, (rv32.mstatus&^sstatusWriteMask)|(value&sstatusWriteMask))
+			},
+		},
+		CSRSIE: {
+			Name:      "sie",
+			WriteMask: mipSupervisorMask,
+			RHandler:  func() uint64 { return uint64(rv32.mie & rv32.mideleg) },
+			WHandler:  func(value uint64) { rv32.mie = (rv32.mie &^ rv32.mideleg) | (uint32(value) & rv32.mideleg) },
+		},
+		CSRSIP: {
+			Name:      "sip",
+			WriteMask: MIPSSIP, // Only the software interrupt can be requested by supervisor software
+			RHandler:  func() uint64 { return uint64(atomic.LoadUint32(&rv32.mip) & rv32.mideleg) },
+			WHandler: func(value uint64) {
+				if rv32.mideleg&MIPSSIP > 0 {
+					rv32.SetInterruptPending(InterruptSupervisorSoftware, value&MIPSSIP > 0)
+				}
+			},
+		},
+		CSRSTVec: {
+			Name:      "stvec",
+			WriteMask: ^uint64(0),
+			WHandler:  func(value uint64) { rv32.stvec = legalizeTVec(value, rv32.stvec) },
+			RHandler:  func() uint64 { return rv32.stvec },
+		},
+		CSRSScratch: {Name: "sscratch", WriteMask: ^uint64(0)},
+		CSRSEPC: {
+			Name:      "sepc",
+			WriteMask: ^uint64(1),
+			WHandler:  func(value uint64) { rv32.sepc = value },
+			RHandler:  func() uint64 { return rv32.sepc &^ rv32.ialignMask }, // sepc[1] reads as zero without C
+		},
+		CSRSCause: {Name: "scause", WriteMask: ^uint64(0)},
+		CSRSTVal:  {Name: "stval", WriteMask: ^uint64(0)},
+
+		CSRMEDeleg: {
+			Name:      "medeleg",
+			WriteMask: medelegMask,
+			RHandler:  func() uint64 { return rv32.medeleg },
+			WHandler:  func(value uint64) { rv32.medeleg = value },
+		},
+		CSRMIDeleg: {
+			Name:      "mideleg",
+			WriteMask: mipSupervisorMask,
+			RHandler:  func() uint64 { return uint64(rv32.mideleg) },
+			WHandler:  func(value uint64) { rv32.mideleg = uint32(value) },
+		},
+	}
+
+	for address, csr := range csrs {
+		if err := rv32.CSR.Register(address, csr); err != nil {
+			rv32.log.Errorf("cannot register csr %s: %s", csr.Name, err)
+		}
+	}
+}
+
+// delegated returns true if the trap cause is delegated to supervisor mode
+func (rv32 *RISCV) delegated(cause uint32) bool {
+	if cause&mcauseInterrupt > 0 {
+		return rv32.mideleg&(1<<(cause&^mcauseInterrupt)) > 0
+	}
+	return rv32.medeleg&(1<<cause) > 0
+}
+
+// takeSupervisorTrap enters the supervisor mode trap handler
+// xcause is the cause already encoded as a scause value
+func (rv32 *RISCV) takeSupervisorTrap(epc uint64, cause uint32, xcause, value uint64) {
+	mstatus := rv32.CSR.Get(CSRMStatus)
+	mstatus &^= MStatusSPIE | MStatusSPP
+	if mstatus&MStatusSIE > 0 {
+		mstatus |= MStatusSPIE
+	}
+	mstatus &^= MStatusSIE
+	mstatus |= uint64(rv32.priv) << mstatusSPPShift
+
+	rv32.CSR.Set(CSRMStatus, mstatus)
+	rv32.CSR.Set(CSRSEPC, epc)
+	rv32.CSR.Set(CSRSCause, xcause)
+	rv32.CSR.Set(CSRSTVal, value)
+	rv32.priv = PrivilegeSupervisor
+	rv32.pc = trapVector(rv32.stvec, cause) & rv32.xlenMask
+}
+
+// sret returns from a supervisor mode trap
+func (rv32 *RISCV) sret(ins uint32) error {
+	if !rv32.isa.Has(MISAExtS) || rv32.priv < PrivilegeSupervisor {
+		return rv32.illegalInstruction(ins)
+	}
+
+	mstatus := rv32.CSR.Get(CSRMStatus)
+	rv32.priv = PrivilegeLevel((mstatus & MStatusSPP) >> mstatusSPPShift)
+
+	mstatus &^= MStatusSIE
+	if mstatus&MStatusSPIE > 0 {
+		mstatus |= MStatusSIE
+	}
+	mstatus |= MStatusSPIE
+	mstatus &^= MStatusSPP | MStatusMPRV // SPP goes to user mode and sret never returns to machine mode
+
+	rv32.CSR.Set(CSRMStatus, mstatus)
+	rv32.pc = rv32.sepc &^ rv32.ialignMask
+	return nil
+}
@@ -0,0 +1,298 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Exception codes (mcause values when interrupt bit is not set)
+const (
+	ExceptionInstructionAddressMisaligned = 0
+	ExceptionInstructionAccessFault       = 1
+	ExceptionIllegalInstruction           = 2
+	ExceptionBreakpoint                   = 3
+	ExceptionLoadAddressMisaligned        = 4
+	ExceptionLoadAccessFault              = 5
+	ExceptionStoreAddressMisaligned       = 6
+	ExceptionStoreAccessFault             = 7
+	ExceptionEnvironmentCallFromUMode     = 8
+	ExceptionEnvironmentCallFromSMode     = 9
+	ExceptionEnvironmentCallFromMMode     = 11
+	ExceptionInstructionPageFault         = 12
+	ExceptionLoadPageFault                = 13
+	ExceptionStorePageFault               = 15
+)
+
+// Trap CSR addresses
+const (
+	CSRMStatus = 0x300
+	CSRMTVec   = 0x305
+	CSRMEPC    = 0x341
+	CSRMCause  = 0x342
+	CSRMTVal   = 0x343
+)
+
+// mstatus fields
+const (
+	MStatusSIE  = 1 << 1
+	MStatusMIE  = 1 << 3
+	MStatusSPIE = 1 << 5
+	MStatusMPIE = 1 << 7
+	MStatusSPP  = 1 << 8
+	MStatusMPP  = 3 << 11
+	MStatusFS   = 3 << 13
+	MStatusMPRV = 1 << 17
+	MStatusSUM  = 1 << 18
+	MStatusMXR  = 1 << 19
+	MStatusUXL  = 3 << 32 // RV64 only, read-only
+	MStatusSXL  = 3 << 34 // RV64 only, read-only
+	MStatusSD   = 1 << 31 // Bit XLEN-1, so bit 63 in RV64
+
+	mstatusSPPShift = 8
+	mstatusMPPShift = 11
+	mstatusFSShift  = 13
+	mstatusUXLShift = 32
+	mstatusSXLShift = 34
+)
+
+// mstatus.FS values (floating point unit state)
+const (
+	FSOff     = 0
+	FSInitial = 1
+	FSClean   = 2
+	FSDirty   = 3
+)
+
+// mtvec modes
+const (
+	MTVecModeDirect   = 0
+	MTVecModeVectored = 1
+
+	mtvecModeMask = 3
+)
+
+// mcauseInterrupt marks interrupt causes. It is moved to bit XLEN-1 when written to mcause
+const mcauseInterrupt = 1 << 31
+
+// TrapPolicy specifies what the core does when an instruction raises an exception
+type TrapPolicy int
+
+const (
+	// TrapPolicyVector takes the trap as specified by the privileged spec, jumping to mtvec
+	TrapPolicyVector TrapPolicy = iota
+	// TrapPolicyStop does not take the trap, leaves the PC at the faulting instruction and returns the exception as an error
+	// Useful for debugging code that is not supposed to trap
+	TrapPolicyStop
+)
+
+// SetTrapPolicy sets what the core does when an exception is raised
+func (rv32 *RISCV) SetTrapPolicy(policy TrapPolicy) {
+	rv32.trapPolicy = policy
+}
+
+// registerTrapCSRs registers the CSRs used by the machine-mode trap flow
+func (rv32 *RISCV) registerTrapCSRs() {
+	mstatusWriteMask := uint64(MStatusMIE | MStatusMPIE)
+	if rv32.isa.Has(MISAExtF) { // FS is read-only zero without a floating point unit
+		mstatusWriteMask |= MStatusFS
+	}
+	if rv32.isa.Has(MISAExtU) { // MPP and MPRV are read-only in machine mode only cores
+		mstatusWriteMask |= MStatusMPP | MStatusMPRV
+	}
+	if rv32.isa.Has(MISAExtS) {
+		mstatusWriteMask |= MStatusSIE | MStatusSPIE | MStatusSPP | MStatusSUM | MStatusMXR
+	}
+
+	csrs := map[uint32]CSR{
+		CSRMStatus: {
+			Name:      "mstatus",
+			WriteMask: mstatusWriteMask,
+			RHandler:  func() uint64 { return rv32.mstatus },
+			WHandler: func(value uint64) {
+				if !rv32.privilegeSupported(PrivilegeLevel((value & MStatusMPP) >> mstatusMPPShift)) { // MPP is WARL
+					value = (value &^ MStatusMPP) | (rv32.mstatus & MStatusMPP)
+				}
+				value &^= rv32.mstatusSD()
+				if value&MStatusFS == MStatusFS { // SD summarizes the dirty state
+					value |= rv32.mstatusSD()
+				}
+				rv32.mstatus = value
+			},
+		},
+		CSRMTVec: {
+			Name:      "mtvec",
+			WriteMask: ^uint64(0),
+			WHandler:  func(value uint64) { rv32.mtvec = legalizeTVec(value, rv32.mtvec) },
+			RHandler:  func() uint64 { return rv32.mtvec },
+		},
+		CSRMEPC: {
+			Name:      "mepc",
+			WriteMask: ^uint64(1),
+			WHandler:  func(value uint64) { rv32.mepc = value },
+			RHandler:  func() uint64 { return rv32.mepc &^ rv32.ialignMask }, // mepc[1] reads as zero without C
+		},
+		CSRMCause: {Name: "mcause", WriteMask: ^uint64(0)},
+		CSRMTVal:  {Name: "mtval", WriteMask: ^uint64(0)},
+	}
+
+	for address, csr := range csrs {
+		if err := rv32.CSR.Register(address, csr); err != nil {
+			rv32.log.Errorf("cannot register csr %s: %s", csr.Name, err)
+		}
+	}
+}
+
+// mstatusResetValue returns the mstatus value after reset
+// The floating point unit starts enabled (FS = Initial) when F is implemented
+func (rv32 *RISCV) mstatusResetValue() uint64 {
+	value := uint64(PrivilegeMachine) << mstatusMPPShift
+	if rv32.isa.Has(MISAExtF) {
+		value |= FSInitial << mstatusFSShift
+	}
+	if rv32.isa.XLEN == 64 { // Lower privilege modes always run with XLEN = 64
+		if rv32.isa.Has(MISAExtU) {
+			value |= 2 << mstatusUXLShift
+		}
+		if rv32.isa.Has(MISAExtS) {
+			value |= 2 << mstatusSXLShift
+		}
+	}
+	return value
+}
+
+// legalizeTVec returns the new value of a trap vector CSR (mtvec / stvec)
+// Reserved modes are not accepted, keeping the old mode
+func legalizeTVec(value, old uint64) uint64 {
+	if value&mtvecModeMask > MTVecModeVectored {
+		value = (value &^ mtvecModeMask) | (old & mtvecModeMask)
+	}
+	return value
+}
+
+// privilegeSupported returns true if the core implements the privilege level
+func (rv32 *RISCV) privilegeSupported(priv PrivilegeLevel) bool {
+	switch priv {
+	case PrivilegeMachine:
+		return true
+	case PrivilegeSupervisor:
+		return rv32.isa.Has(MISAExtS)
+	case PrivilegeUser:
+		return rv32.isa.Has(MISAExtU)
+	}
+	return false
+}
+
+// lowestPrivilege returns the least privileged mode implemented by the core
+func (rv32 *RISCV) lowestPrivilege() PrivilegeLevel {
+	if rv32.isa.Has(MISAExtU) {
+		return PrivilegeUser
+	}
+	return PrivilegeMachine
+}
+
+// mstatusSD returns the mstatus.SD bit, which is always the most significant bit
+func (rv32 *RISCV) mstatusSD() uint64 {
+	return 1 << (rv32.isa.XLEN - 1)
+}
+
+// exception creates a new exception with the specified cause and mtval value
+func (rv32 *RISCV) exception(cause uint32, value uint64, format string, args ...interface{}) error {
+	return Exception{
+		Message: fmt.Sprintf(format, args...),
+		Cause:   cause,
+		Value:   value,
+	}
+}
+
+// illegalInstruction creates a new illegal instruction exception for the instruction at the current pc
+func (rv32 *RISCV) illegalInstruction(ins uint32) error {
+	return rv32.exception(ExceptionIllegalInstruction, uint64(ins), "invalid instruction %08x at pc = %08x", ins, rv32.insPC)
+}
+
+// busError creates the access fault exception for a bus error at addr and counts it in the hpm counters
+func (rv32 *RISCV) busError(cause uint32, addr uint64, err error) error {
+	rv32.countEvent(HPMEventBusError)
+	return rv32.exception(cause, addr, "bus error at %08x accessing %08x: %s", rv32.insPC, addr, err)
+}
+
+// handleException handles an error returned by an instruction executed at pc
+// according to the trap policy. Errors that are not exceptions are returned as is.
+func (rv32 *RISCV) handleException(pc uint64, err error) error {
+	var ex Exception
+	if !errors.As(err, &ex) {
+		return err
+	}
+
+	if rv32.trapPolicy == TrapPolicyStop {
+		rv32.pc = pc
+		return err
+	}
+
+	rv32.log.Debugf("(RISCV) Exception: %s", ex)
+	rv32.takeTrap(pc, ex.Cause, ex.Value)
+	return nil
+}
+
+// takeTrap enters the trap handler for the specified cause
+// Traps from S or U mode are taken in supervisor mode when delegated by medeleg / mideleg
+// epc is the address of the instruction that was interrupted or raised the exception
+func (rv32 *RISCV) takeTrap(epc uint64, cause uint32, value uint64) {
+	code := cause &^ mcauseInterrupt
+	xcause := uint64(code)
+	if cause&mcauseInterrupt > 0 {
+		xcause |= 1 << (rv32.isa.XLEN - 1)
+	}
+
+	if rv32.priv <= PrivilegeSupervisor && rv32.delegated(cause) {
+		rv32.takeSupervisorTrap(epc, cause, xcause, value)
+		return
+	}
+
+	mstatus := rv32.CSR.Get(CSRMStatus)
+	mstatus &^= MStatusMPIE | MStatusMPP
+	if mstatus&MStatusMIE > 0 {
+		mstatus |= MStatusMPIE
+	}
+	mstatus &^= MStatusMIE
+	mstatus |= uint64(rv32.priv) << mstatusMPPShift
+
+	rv32.CSR.Set(CSRMStatus, mstatus)
+	rv32.CSR.Set(CSRMEPC, epc)
+	rv32.CSR.Set(CSRMCause, xcause)
+	rv32.CSR.Set(CSRMTVal, value)
+	rv32.priv = PrivilegeMachine
+	rv32.pc = trapVector(rv32.mtvec, cause) & rv32.xlenMask
+}
+
+// trapVector returns the address of the trap handler for the cause from a trap vector CSR value
+func trapVector(tvec uint64, cause uint32) uint64 {
+	base := tvec &^ mtvecModeMask
+	if tvec&mtvecModeMask == MTVecModeVectored && cause&mcauseInterrupt > 0 {
+		base += 4 * uint64(cause&^mcauseInterrupt)
+	}
+	return base
+}
+
+// mret returns from a machine mode trap
+func (rv32 *RISCV) mret(ins uint32) error {
+	if rv32.priv < PrivilegeMachine {
+		return rv32.illegalInstruction(ins)
+	}
+
+	mstatus := rv32.CSR.Get(CSRMStatus)
+	rv32.priv = PrivilegeLevel((mstatus & MStatusMPP) >> mstatusMPPShift)
+
+	mstatus &^= MStatusMIE
+	if mstatus&MStatusMPIE > 0 {
+		mstatus |= MStatusMIE
+	}
+	mstatus |= MStatusMPIE
+	mstatus = (mstatus &^ MStatusMPP) | uint64(rv32.lowestPrivilege())<<mstatusMPPShift
+	if rv32.priv != PrivilegeMachine { // MPRV only applies while returning to machine mode
+		mstatus &^= MStatusMPRV
+	}
+
+	rv32.CSR.Set(CSRMStatus, mstatus)
+	rv32.pc = rv32.mepc &^ rv32.ialignMask
+	return nil
+}
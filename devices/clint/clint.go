@@ -0,0 +1,239 @@
+package clint
+
+import (
+	"context"
+	"fmt"
+	"github.com/racerxdl/riscv-emulator/core"
+	"sync"
+	"time"
+)
+
+// SiFive CLINT register layout
+const (
+	MSIPOffset     = 0x0000 // 4 bytes per hart
+	MTimeCmpOffset = 0x4000 // 8 bytes per hart
+	MTimeOffset    = 0xBFF8
+	Size           = 0x10000
+)
+
+// ClockSource selects what drives the mtime counter
+type ClockSource int
+
+const (
+	// ClockCycles increments mtime every N cycles of the first hart
+	ClockCycles ClockSource = iota
+	// ClockWall increments mtime at a fixed frequency using the host clock
+	ClockWall
+)
+
+// CLINT is a Core Local Interruptor with machine timer and software interrupts
+type CLINT struct {
+	sync.RWMutex
+	harts    []*core.RISCV
+	msip     []uint32
+	mtimecmp []uint64
+
+	source      ClockSource
+	divider     uint64
+	frequency   uint64
+	start       time.Time
+	mtimeOffset uint64
+}
+
+// NewCLINT creates a new CLINT attached to the specified harts
+// By default mtime is incremented on every cycle of the first hart
+func NewCLINT(harts ...*core.RISCV) *CLINT {
+	c := &CLINT{
+		harts:    harts,
+		msip:     make([]uint32, len(harts)),
+		mtimecmp: make([]uint64, len(harts)),
+		source:   ClockCycles,
+		divider:  1,
+		start:    time.Now(),
+	}
+
+	for i := range c.mtimecmp {
+		c.mtimecmp[i] = 0xFFFFFFFF_FFFFFFFF
+	}
+
+	if len(harts) > 0 {
+		harts[0].AddTickHandler(c.Tick)
+		harts[0].AddWakeupSource(c.NextEvent)
+	}
+	for _, hart := range harts { // The time CSR reads mtime
+		hart.SetTimeSource(c.MTime)
+	}
+
+	return c
+}
+
+// UseCycleClock makes mtime increment once every divider cycles of the first hart
+func (c *CLINT) UseCycleClock(divider uint64) {
+	c.Lock()
+	defer c.Unlock()
+	if divider == 0 {
+		divider = 1
+	}
+	mtime := c.mtime()
+	c.source = ClockCycles
+	c.divider = divider
+	c.mtimeOffset = 0
+	c.mtimeOffset = mtime - c.mtime()
+}
+
+// UseWallClock makes mtime increment frequency times per second of host time
+func (c *CLINT) UseWallClock(frequency uint64) {
+	c.Lock()
+	defer c.Unlock()
+	mtime := c.mtime()
+	c.source = ClockWall
+	c.frequency = frequency
+	c.start = time.Now()
+	c.mtimeOffset = 0
+	c.mtimeOffset = mtime - c.mtime()
+}
+
+// mtime returns the current mtime value. Lock should be held by caller
+func (c *CLINT) mtime() uint64 {
+	raw := uint64(0)
+	switch c.source {
+	case ClockCycles:
+		if len(c.harts) > 0 {
+			raw = c.harts[0].Cycles() / c.divider
+		}
+	case ClockWall:
+		elapsed := time.Since(c.start)
+		raw = uint64(elapsed/time.Second)*c.frequency + uint64(elapsed%time.Second)*c.frequency/uint64(time.Second)
+	}
+	return raw + c.mtimeOffset
+}
+
+// MTime returns the current value of mtime
+func (c *CLINT) MTime() uint64 {
+	c.RLock()
+	defer c.RUnlock()
+	return c.mtime()
+}
+
+// NextEvent returns the cycle of the first hart at which the next interrupt of any hart will be requested
+// Events can only be predicted with the cycle clock
+func (c *CLINT) NextEvent() (uint64, bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	if c.source != ClockCycles || len(c.harts) == 0 {
+		return 0, false
+	}
+
+	now := c.harts[0].Cycles()
+	mtime := c.mtime()
+	next := uint64(0)
+	found := false
+	for i, cmp := range c.mtimecmp {
+		if c.msip[i]&1 > 0 || mtime >= cmp {
+			return now, true
+		}
+		raw := cmp - c.mtimeOffset // mtime = cycles / divider + mtimeOffset
+		if raw > ^uint64(0)/c.divider {
+			continue
+		}
+		if cycle := raw * c.divider; !found || cycle < next {
+			next = cycle
+			found = true
+		}
+	}
+	return next, found
+}
+
+// Tick updates the interrupt lines of the harts
+// It is called by the first hart on every cycle
+func (c *CLINT) Tick(cycle uint64) {
+	c.RLock()
+	defer c.RUnlock()
+	c.update()
+}
+
+// update updates the interrupt lines of all harts. Lock should be held by caller
+func (c *CLINT) update() {
+	mtime := c.mtime()
+	for i, hart := range c.harts {
+		hart.SetInterruptPending(core.InterruptMachineSoftware, c.msip[i]&1 > 0)
+		hart.SetInterruptPending(core.InterruptMachineTimer, mtime >= c.mtimecmp[i])
+	}
+}
+
+// setMTime sets the lower or upper half of mtime. Lock should be held by caller
+func (c *CLINT) setMTime(value uint32, upper bool) {
+	mtime := c.mtime()
+	if upper {
+		mtime = (mtime & 0xFFFFFFFF) | uint64(value)<<32
+	} else {
+		mtime = (mtime &^ 0xFFFFFFFF) | uint64(value)
+	}
+	c.mtimeOffset = 0
+	c.mtimeOffset = mtime - c.mtime()
+}
+
+// Read reads the CLINT registers
+func (c *CLINT) Read(address uint32) (uint32, error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	switch {
+	case address >= MTimeOffset && address < MTimeOffset+8:
+		return uint32(c.mtime() >> (8 * (address - MTimeOffset))), nil
+	case address >= MTimeCmpOffset && address < MTimeCmpOffset+uint32(len(c.harts)*8):
+		hart := (address - MTimeCmpOffset) / 8
+		return uint32(c.mtimecmp[hart] >> (8 * (address % 8))), nil
+	case address >= MSIPOffset && address < MSIPOffset+uint32(len(c.harts)*4):
+		return c.msip[(address-MSIPOffset)/4], nil
+	}
+
+	return 0, nil
+}
+
+// Write writes the CLINT registers
+func (c *CLINT) Write(address, value uint32, writeMask uint8) error {
+	c.Lock()
+	defer c.Unlock()
+
+	// Byte and halfword stores only replace their lanes of the register
+	switch {
+	case address == MTimeOffset || address == MTimeOffset+4:
+		current := uint32(c.mtime() >> (8 * (address - MTimeOffset)))
+		c.setMTime(core.MergeLanes(current, value, writeMask), address != MTimeOffset)
+	case address >= MTimeCmpOffset && address < MTimeCmpOffset+uint32(len(c.harts)*8):
+		hart := (address - MTimeCmpOffset) / 8
+		value = core.MergeLanes(uint32(c.mtimecmp[hart]>>(8*(address%8))), value, writeMask)
+		if address%8 == 0 {
+			c.mtimecmp[hart] = (c.mtimecmp[hart] &^ 0xFFFFFFFF) | uint64(value)
+		} else {
+			c.mtimecmp[hart] = (c.mtimecmp[hart] & 0xFFFFFFFF) | uint64(value)<<32
+		}
+	case address >= MSIPOffset && address < MSIPOffset+uint32(len(c.harts)*4):
+		hart := (address - MSIPOffset) / 4
+		c.msip[hart] = core.MergeLanes(c.msip[hart], value, writeMask) & 1
+	default:
+		return nil
+	}
+
+	c.update()
+	return nil
+}
+
+// Map maps the CLINT into the specified bus with specified base address
+func (c *CLINT) Map(baseAddress uint64, bus *core.Bus) error {
+	rhandle := func(ctx context.Context, address uint64, readMask byte) (uint32, error) {
+		return c.Read(uint32(address - baseAddress))
+	}
+	whandle := func(ctx context.Context, address uint64, value uint32, writeMask byte) error {
+		return c.Write(uint32(address-baseAddress), value, writeMask)
+	}
+
+	err := bus.MapIO("clint", baseAddress, baseAddress+Size, rhandle, whandle)
+	if err != nil {
+		return fmt.Errorf("(CLINT) cannot map clint: %s", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,219 @@
+package clint
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/racerxdl/riscv-emulator/core"
+	"testing"
+	"time"
+)
+
+// createHart creates a core with 256 bytes of RAM at address 0 holding the specified program followed by j .
+func createHart(t *testing.T, program ...uint32) *core.RISCV {
+	cpu := core.CreateEmulator(nil)
+	memory := make([]byte, 0x100)
+	for i := 0; i < len(memory); i += 4 {
+		binary.LittleEndian.PutUint32(memory[i:], 0x0000006F) // j .
+	}
+	for i, ins := range program {
+		binary.LittleEndian.PutUint32(memory[i*4:], ins)
+	}
+	if err := cpu.Bus.MapMemory("program", 0, memory, true); err != nil {
+		t.Fatal(err)
+	}
+	return cpu
+}
+
+func TestCLINT_Timer(t *testing.T) {
+	ctx := context.Background()
+	cpu := createHart(t)
+	c := NewCLINT(cpu)
+
+	if err := c.Map(0x0200_0000, cpu.Bus); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cpu.Bus.WriteWord(ctx, 0x0200_0000+MTimeCmpOffset, 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := cpu.Bus.WriteWord(ctx, 0x0200_0000+MTimeCmpOffset+4, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 99; i++ {
+		_ = cpu.RunStep(ctx)
+	}
+	if cpu.InterruptPending(core.InterruptMachineTimer) {
+		t.Errorf("expected timer interrupt not to be pending at mtime = %d", c.MTime())
+	}
+
+	_ = cpu.RunStep(ctx)
+	if !cpu.InterruptPending(core.InterruptMachineTimer) {
+		t.Errorf("expected timer interrupt to be pending at mtime = %d", c.MTime())
+	}
+
+	mtime, err := cpu.Bus.ReadWord(ctx, 0x0200_0000+MTimeOffset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mtime != 100 {
+		t.Errorf("expected mtime to be %d got %d", 100, mtime)
+	}
+
+	// Moving mtimecmp forward clears the interrupt
+	if err := cpu.Bus.WriteWord(ctx, 0x0200_0000+MTimeCmpOffset, 200); err != nil {
+		t.Fatal(err)
+	}
+	if cpu.InterruptPending(core.InterruptMachineTimer) {
+		t.Errorf("expected timer interrupt to be cleared")
+	}
+
+	// Cycle divider
+	c.UseCycleClock(10)
+	for i := 0; i < 100; i++ {
+		_ = cpu.RunStep(ctx)
+	}
+	if c.MTime() != 110 {
+		t.Errorf("expected mtime to be %d got %d", 110, c.MTime())
+	}
+
+	// Writing mtime
+	if err := cpu.Bus.WriteWord(ctx, 0x0200_0000+MTimeOffset, 5000); err != nil {
+		t.Fatal(err)
+	}
+	if c.MTime() != 5000 {
+		t.Errorf("expected mtime to be %d got %d", 5000, c.MTime())
+	}
+	if !cpu.InterruptPending(core.InterruptMachineTimer) {
+		t.Errorf("expected timer interrupt to be pending at mtime = %d", c.MTime())
+	}
+}
+
+func TestCLINT_WallClock(t *testing.T) {
+	cpu := createHart(t)
+	c := NewCLINT(cpu)
+	c.UseWallClock(1_000_000)
+
+	start := c.MTime()
+	time.Sleep(time.Millisecond * 10)
+	elapsed := c.MTime() - start
+
+	if elapsed < 10_000 {
+		t.Errorf("expected at least %d ticks in 10ms got %d", 10_000, elapsed)
+	}
+}
+
+func TestCLINT_SoftwareInterrupt(t *testing.T) {
+	ctx := context.Background()
+	cpu := createHart(t)
+	c := NewCLINT(cpu)
+
+	if err := c.Map(0x0200_0000, cpu.Bus); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cpu.Bus.WriteWord(ctx, 0x0200_0000+MSIPOffset, 1); err != nil {
+		t.Fatal(err)
+	}
+	if !cpu.InterruptPending(core.InterruptMachineSoftware) {
+		t.Errorf("expected software interrupt to be pending")
+	}
+
+	msip, _ := cpu.Bus.ReadWord(ctx, 0x0200_0000+MSIPOffset)
+	if msip != 1 {
+		t.Errorf("expected msip to be 1 got %d", msip)
+	}
+
+	if err := cpu.Bus.WriteWord(ctx, 0x0200_0000+MSIPOffset, 0); err != nil {
+		t.Fatal(err)
+	}
+	if cpu.InterruptPending(core.InterruptMachineSoftware) {
+		t.Errorf("expected software interrupt to be cleared")
+	}
+}
+
+func TestCLINT_ByteStores(t *testing.T) {
+	ctx := context.Background()
+	cpu := createHart(t)
+	c := NewCLINT(cpu)
+
+	if err := c.Map(0x0200_0000, cpu.Bus); err != nil {
+		t.Fatal(err)
+	}
+
+	// Byte and halfword stores only replace their bytes of the register
+	if err := cpu.Bus.WriteDoubleWord(ctx, 0x0200_0000+MTimeCmpOffset, 0x5566778811223344); err != nil {
+		t.Fatal(err)
+	}
+	if err := cpu.Bus.WriteByte(ctx, 0x0200_0000+MTimeCmpOffset+1, 0xAA); err != nil {
+		t.Fatal(err)
+	}
+	if err := cpu.Bus.WriteShort(ctx, 0x0200_0000+MTimeCmpOffset+6, 0xBBCC); err != nil {
+		t.Fatal(err)
+	}
+	mtimecmp, _ := cpu.Bus.ReadDoubleWord(ctx, 0x0200_0000+MTimeCmpOffset)
+	if mtimecmp != 0xBBCC77881122AA44 {
+		t.Errorf("expected mtimecmp to be %016x got %016x", uint64(0xBBCC77881122AA44), mtimecmp)
+	}
+
+	if err := cpu.Bus.WriteDoubleWord(ctx, 0x0200_0000+MTimeOffset, 0x1000_2000); err != nil {
+		t.Fatal(err)
+	}
+	if err := cpu.Bus.WriteByte(ctx, 0x0200_0000+MTimeOffset+3, 0x30); err != nil {
+		t.Fatal(err)
+	}
+	if c.MTime() != 0x3000_2000 {
+		t.Errorf("expected mtime to be %08x got %08x", 0x3000_2000, c.MTime())
+	}
+
+	if err := cpu.Bus.WriteWord(ctx, 0x0200_0000+MSIPOffset, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := cpu.Bus.WriteByte(ctx, 0x0200_0000+MSIPOffset+1, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !cpu.InterruptPending(core.InterruptMachineSoftware) {
+		t.Errorf("expected software interrupt to be kept by a store to another byte")
+	}
+}
+
+func TestCLINT_TimeCSR(t *testing.T) {
+	ctx := context.Background()
+	cpu := createHart(t)
+	c := NewCLINT(cpu)
+	c.UseCycleClock(4)
+
+	for i := 0; i < 40; i++ {
+		_ = cpu.RunStep(ctx)
+	}
+	if v := cpu.CSR.Get(core.CSRTime); v != c.MTime() || v != 10 {
+		t.Errorf("expected time to be mtime (10) got %d", v)
+	}
+}
+
+func TestCLINT_WFI(t *testing.T) {
+	ctx := context.Background()
+	cpu := createHart(t, 0x10500073) // wfi
+	c := NewCLINT(cpu)
+	c.UseCycleClock(10)
+	if err := c.Map(0x0200_0000, cpu.Bus); err != nil {
+		t.Fatal(err)
+	}
+	cpu.CSR.Set(core.CSRMIE, 1<<core.InterruptMachineTimer)
+
+	// A long sleep finishes in a couple of steps
+	if err := cpu.Bus.WriteDoubleWord(ctx, 0x0200_0000+MTimeCmpOffset, 50_000_000); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := cpu.RunStep(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cpu.Waiting() || !cpu.InterruptPending(core.InterruptMachineTimer) {
+		t.Errorf("expected timer interrupt to wake up the hart")
+	}
+	if c.MTime() != 50_000_000 {
+		t.Errorf("expected mtime to be %d got %d", 50_000_000, c.MTime())
+	}
+}
@@ -0,0 +1,242 @@
+package plic
+
+import (
+	"context"
+	"fmt"
+	"github.com/racerxdl/riscv-emulator/core"
+	"sync"
+)
+
+// SiFive PLIC register layout
+const (
+	PriorityOffset      = 0x000000 // 4 bytes per source
+	PendingOffset       = 0x001000 // 1 bit per source
+	EnableOffset        = 0x002000 // 0x80 bytes per context, 1 bit per source
+	EnableContextStride = 0x80
+	ContextOffset       = 0x200000 // 0x1000 bytes per context
+	ContextStride       = 0x1000
+	ThresholdOffset     = 0x0 // Inside context
+	ClaimOffset         = 0x4 // Inside context
+	Size                = 0x4000000
+
+	MaxSources  = 1024
+	MaxPriority = 7
+)
+
+type plicContext struct {
+	line      core.IRQLine
+	enable    []uint32
+	threshold uint32
+}
+
+// PLIC is a Platform-Level Interrupt Controller
+// Source 0 is reserved and means "no interrupt"
+type PLIC struct {
+	sync.Mutex
+	priority []uint32
+	level    []bool
+	pending  []bool
+	claimed  []bool
+	contexts []*plicContext
+}
+
+// NewPLIC creates a new PLIC with numSources interrupt sources (ids from 1 to numSources)
+// Each context is an interrupt target, usually core.RISCV.InterruptLine(core.InterruptMachineExternal)
+// for the machine mode and core.RISCV.InterruptLine(core.InterruptSupervisorExternal) for supervisor mode
+func NewPLIC(numSources int, contexts ...core.IRQLine) *PLIC {
+	if numSources >= MaxSources {
+		numSources = MaxSources - 1
+	}
+	numSources++ // Source 0 is reserved
+
+	p := &PLIC{
+		priority: make([]uint32, numSources),
+		level:    make([]bool, numSources),
+		pending:  make([]bool, numSources),
+		claimed:  make([]bool, numSources),
+	}
+
+	for _, line := range contexts {
+		p.contexts = append(p.contexts, &plicContext{
+			line:   line,
+			enable: make([]uint32, (numSources+31)/32),
+		})
+	}
+
+	return p
+}
+
+// Source returns the interrupt line for the specified source id
+// Devices should use it to request interrupts
+func (p *PLIC) Source(id int) core.IRQLine {
+	return core.IRQLineFunc(func(level bool) {
+		p.SetLevel(id, level)
+	})
+}
+
+// SetLevel sets the level of the specified source interrupt line
+func (p *PLIC) SetLevel(id int, level bool) {
+	p.Lock()
+	defer p.Unlock()
+
+	if id <= 0 || id >= len(p.level) {
+		return
+	}
+
+	p.level[id] = level
+	if level && !p.claimed[id] { // Gateway only forwards a new request when the previous one was completed
+		p.pending[id] = true
+	}
+	p.update()
+}
+
+// best returns the highest priority pending source enabled for the context. Lock should be held by caller
+// Sources with the same priority are ordered by id
+func (p *PLIC) best(ctx *plicContext) int {
+	best := 0
+	bestPriority := ctx.threshold
+	for id := 1; id < len(p.pending); id++ {
+		if !p.pending[id] || ctx.enable[id/32]&(1<<(id%32)) == 0 {
+			continue
+		}
+		if p.priority[id] > bestPriority {
+			best = id
+			bestPriority = p.priority[id]
+		}
+	}
+	return best
+}
+
+// update updates the interrupt lines of all contexts. Lock should be held by caller
+func (p *PLIC) update() {
+	for _, ctx := range p.contexts {
+		if ctx.line != nil {
+			ctx.line.Set(p.best(ctx) != 0)
+		}
+	}
+}
+
+// claim claims the highest priority interrupt for the context. Lock should be held by caller
+func (p *PLIC) claim(ctx *plicContext) uint32 {
+	id := p.best(ctx)
+	if id != 0 {
+		p.pending[id] = false
+		p.claimed[id] = true
+		p.update()
+	}
+	return uint32(id)
+}
+
+// complete signals the interrupt handling is completed for the source. Lock should be held by caller
+// Completions for sources that are not enabled for the context are ignored
+func (p *PLIC) complete(ctx *plicContext, id uint32) {
+	if id == 0 || id >= uint32(len(p.claimed)) || ctx.enable[id/32]&(1<<(id%32)) == 0 {
+		return
+	}
+	p.claimed[id] = false
+	if p.level[id] { // Line still asserted, request again
+		p.pending[id] = true
+	}
+	p.update()
+}
+
+// Read reads the PLIC registers
+func (p *PLIC) Read(address uint32) (uint32, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	switch {
+	case address < PendingOffset:
+		id := (address - PriorityOffset) / 4
+		if id < uint32(len(p.priority)) {
+			return p.priority[id], nil
+		}
+	case address < EnableOffset:
+		word := (address - PendingOffset) / 4
+		v := uint32(0)
+		for i := uint32(0); i < 32; i++ {
+			id := word*32 + i
+			if id < uint32(len(p.pending)) && p.pending[id] {
+				v |= 1 << i
+			}
+		}
+		return v, nil
+	case address < ContextOffset:
+		ctx := (address - EnableOffset) / EnableContextStride
+		word := (address - EnableOffset) % EnableContextStride / 4
+		if ctx < uint32(len(p.contexts)) && word < uint32(len(p.contexts[ctx].enable)) {
+			return p.contexts[ctx].enable[word], nil
+		}
+	default:
+		ctx := (address - ContextOffset) / ContextStride
+		if ctx >= uint32(len(p.contexts)) {
+			break
+		}
+		switch (address - ContextOffset) % ContextStride {
+		case ThresholdOffset:
+			return p.contexts[ctx].threshold, nil
+		case ClaimOffset:
+			return p.claim(p.contexts[ctx]), nil
+		}
+	}
+
+	return 0, nil
+}
+
+// Write writes the PLIC registers
+// Byte and halfword stores only replace their lanes of the priority, enable and threshold registers
+func (p *PLIC) Write(address, value uint32, writeMask uint8) error {
+	p.Lock()
+	defer p.Unlock()
+
+	switch {
+	case address < PendingOffset:
+		id := (address - PriorityOffset) / 4
+		if id > 0 && id < uint32(len(p.priority)) {
+			p.priority[id] = core.MergeLanes(p.priority[id], value, writeMask) & MaxPriority
+		}
+	case address < EnableOffset:
+		// Pending bits are read-only
+	case address < ContextOffset:
+		ctx := (address - EnableOffset) / EnableContextStride
+		word := (address - EnableOffset) % EnableContextStride / 4
+		if ctx < uint32(len(p.contexts)) && word < uint32(len(p.contexts[ctx].enable)) {
+			value = core.MergeLanes(p.contexts[ctx].enable[word], value, writeMask)
+			if word == 0 {
+				value &^= 1 // Source 0 does not exist
+			}
+			p.contexts[ctx].enable[word] = value
+		}
+	default:
+		ctx := (address - ContextOffset) / ContextStride
+		if ctx >= uint32(len(p.contexts)) {
+			break
+		}
+		switch (address - ContextOffset) % ContextStride {
+		case ThresholdOffset:
+			p.contexts[ctx].threshold = core.MergeLanes(p.contexts[ctx].threshold, value, writeMask) & MaxPriority
+		case ClaimOffset:
+			p.complete(p.contexts[ctx], value)
+		}
+	}
+
+	p.update()
+	return nil
+}
+
+// Map maps the PLIC into the specified bus with specified base address
+func (p *PLIC) Map(baseAddress uint64, bus *core.Bus) error {
+	rhandle := func(ctx context.Context, address uint64, readMask byte) (uint32, error) {
+		return p.Read(uint32(address - baseAddress))
+	}
+	whandle := func(ctx context.Context, address uint64, value uint32, writeMask byte) error {
+		return p.Write(uint32(address-baseAddress), value, writeMask)
+	}
+
+	err := bus.MapIO("plic", baseAddress, baseAddress+Size, rhandle, whandle)
+	if err != nil {
+		return fmt.Errorf("(PLIC) cannot map plic: %s", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,151 @@
+package plic
+
+import (
+	"github.com/racerxdl/riscv-emulator/core"
+	"testing"
+)
+
+func TestPLIC_ClaimComplete(t *testing.T) {
+	external := false
+	p := NewPLIC(32, core.IRQLineFunc(func(level bool) { external = level }))
+
+	claim := uint32(ContextOffset + ClaimOffset)
+
+	_ = p.Write(PriorityOffset+4*3, 1, 15)
+	_ = p.Write(PriorityOffset+4*5, 2, 15)
+	_ = p.Write(EnableOffset, (1<<3)|(1<<5), 15)
+
+	p.SetLevel(3, true)
+	if !external {
+		t.Fatalf("expected external interrupt to be requested")
+	}
+	p.SetLevel(5, true)
+
+	pending, _ := p.Read(PendingOffset)
+	if pending != (1<<3)|(1<<5) {
+		t.Errorf("expected pending to be %08x got %08x", (1<<3)|(1<<5), pending)
+	}
+
+	// Highest priority first
+	if id, _ := p.Read(claim); id != 5 {
+		t.Errorf("expected claim to return %d got %d", 5, id)
+	}
+	if id, _ := p.Read(claim); id != 3 {
+		t.Errorf("expected claim to return %d got %d", 3, id)
+	}
+	if external {
+		t.Errorf("expected external interrupt to be cleared after all claims")
+	}
+	if id, _ := p.Read(claim); id != 0 {
+		t.Errorf("expected claim to return %d got %d", 0, id)
+	}
+
+	// Line is still asserted, complete should request again
+	_ = p.Write(claim, 5, 15)
+	if !external {
+		t.Errorf("expected external interrupt to be requested again after complete")
+	}
+
+	// Line deasserted, complete should not request again
+	p.SetLevel(3, false)
+	_ = p.Write(claim, 3, 15)
+	if id, _ := p.Read(claim); id != 5 {
+		t.Errorf("expected claim to return %d got %d", 5, id)
+	}
+	if external {
+		t.Errorf("expected external interrupt to be cleared")
+	}
+
+	// Complete is ignored for a source that is not enabled for the context
+	_ = p.Write(EnableOffset, 1<<3, 15)
+	_ = p.Write(claim, 5, 15)
+	if pending, _ := p.Read(PendingOffset); pending != 0 {
+		t.Errorf("expected complete of a disabled source to be ignored, pending is %08x", pending)
+	}
+	_ = p.Write(EnableOffset, (1<<3)|(1<<5), 15)
+	if external {
+		t.Errorf("expected source to be still claimed")
+	}
+	_ = p.Write(claim, 5, 15)
+	if !external {
+		t.Errorf("expected external interrupt to be requested again after complete")
+	}
+}
+
+func TestPLIC_ThresholdAndEnable(t *testing.T) {
+	machine := false
+	supervisor := false
+	p := NewPLIC(8,
+		core.IRQLineFunc(func(level bool) { machine = level }),
+		core.IRQLineFunc(func(level bool) { supervisor = level }),
+	)
+
+	_ = p.Write(PriorityOffset+4*1, 3, 15)
+	_ = p.Write(EnableOffset+EnableContextStride, 1<<1, 15) // Only supervisor context
+	_ = p.Write(ContextOffset+ContextStride+ThresholdOffset, 3, 15)
+
+	p.SetLevel(1, true)
+	if machine || supervisor {
+		t.Errorf("expected no interrupt requested (machine = %t, supervisor = %t)", machine, supervisor)
+	}
+
+	_ = p.Write(ContextOffset+ContextStride+ThresholdOffset, 2, 15)
+	if machine || !supervisor {
+		t.Errorf("expected only supervisor interrupt requested (machine = %t, supervisor = %t)", machine, supervisor)
+	}
+
+	if threshold, _ := p.Read(ContextOffset + ContextStride + ThresholdOffset); threshold != 2 {
+		t.Errorf("expected threshold to be %d got %d", 2, threshold)
+	}
+
+	// Priority 0 means never interrupt
+	_ = p.Write(PriorityOffset+4*1, 0, 15)
+	if supervisor {
+		t.Errorf("expected no interrupt with priority 0")
+	}
+}
+
+func TestPLIC_ByteStores(t *testing.T) {
+	p := NewPLIC(32, core.IRQLineFunc(func(level bool) {}))
+
+	// Byte and halfword stores only replace their bytes of the register
+	_ = p.Write(EnableOffset, (1<<3)|(1<<12), 15)
+	_ = p.Write(EnableOffset, 1<<9, 2)
+	if enable, _ := p.Read(EnableOffset); enable != (1<<3)|(1<<9) {
+		t.Errorf("expected enable to be %08x got %08x", (1<<3)|(1<<9), enable)
+	}
+	_ = p.Write(EnableOffset, 1<<17, 12)
+	if enable, _ := p.Read(EnableOffset); enable != (1<<3)|(1<<9)|(1<<17) {
+		t.Errorf("expected enable to be %08x got %08x", (1<<3)|(1<<9)|(1<<17), enable)
+	}
+
+	_ = p.Write(PriorityOffset+4*3, 5, 15)
+	_ = p.Write(PriorityOffset+4*3, 0, 2)
+	if priority, _ := p.Read(PriorityOffset + 4*3); priority != 5 {
+		t.Errorf("expected priority to be %d got %d", 5, priority)
+	}
+
+	_ = p.Write(ContextOffset+ThresholdOffset, 4, 15)
+	_ = p.Write(ContextOffset+ThresholdOffset, 0, 8)
+	if threshold, _ := p.Read(ContextOffset + ThresholdOffset); threshold != 4 {
+		t.Errorf("expected threshold to be %d got %d", 4, threshold)
+	}
+}
+
+func TestPLIC_HartExternalInterrupt(t *testing.T) {
+	cpu := core.CreateEmulator(nil)
+	p := NewPLIC(4, cpu.InterruptLine(core.InterruptMachineExternal))
+
+	_ = p.Write(PriorityOffset+4*2, 1, 15)
+	_ = p.Write(EnableOffset, 1<<2, 15)
+
+	p.Source(2).Set(true)
+	if !cpu.InterruptPending(core.InterruptMachineExternal) {
+		t.Errorf("expected machine external interrupt to be pending")
+	}
+
+	_, _ = p.Read(ContextOffset + ClaimOffset)
+	if cpu.InterruptPending(core.InterruptMachineExternal) {
+		t.Errorf("expected machine external interrupt to be cleared after claim")
+	}
+}
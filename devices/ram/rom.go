@@ -1,7 +1,6 @@
 package ram
 
 import (
-	"context"
 	"encoding/binary"
 	"fmt"
 	"github.com/racerxdl/riscv-emulator/core"
@@ -23,9 +22,10 @@ func NewROM(name string, size int) *ROM {
 	}
 }
 
-// Read reads data from ram
+// Read reads the word at address
+// Reads have no side effects, so all byte lanes are always returned
 func (rom *ROM) Read(address uint32) (uint32, error) {
-	if address > uint32(len(rom.Data)) {
+	if uint64(address)+4 > uint64(len(rom.Data)) {
 		return 0, fmt.Errorf("(%s) invalid read at %08x", rom.name, address)
 	}
 
@@ -33,12 +33,9 @@ func (rom *ROM) Read(address uint32) (uint32, error) {
 }
 
 // Map maps the memory into the specified bus with specified base address
-func (rom *ROM) Map(baseAddress uint32, bus *core.Bus) error {
-	rhandle := func(ctx context.Context, address uint32) (uint32, error) {
-		return rom.Read(address - baseAddress)
-	}
-
-	err := bus.Map(rom.name, baseAddress, baseAddress+uint32(len(rom.Data)), rhandle, nil)
+// The bus reads Data directly
+func (rom *ROM) Map(baseAddress uint64, bus *core.Bus) error {
+	err := bus.MapMemory(rom.name, baseAddress, rom.Data, false)
 	if err != nil {
 		return fmt.Errorf("(%s) cannot map rom: %s", rom.name, err)
 	}
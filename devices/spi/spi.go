@@ -21,7 +21,7 @@ func NewDummySPI(log *logrus.Logger) *SPI {
 }
 
 // Read reads data from SPI Registers
-func (spi *SPI) Read(address uint32) (uint32, error) {
+func (spi *SPI) Read(address uint32, readMask uint8) (uint32, error) {
 	switch address {
 	case 0: // SPI_CSR
 		spi.log.Info("Read SPI_CSR")
@@ -32,13 +32,15 @@ func (spi *SPI) Read(address uint32) (uint32, error) {
 	case 0x74:
 		spi.log.Info("Read QSPI Parameters")
 	default:
-		spi.log.Infof("Read %08x", address)
+		spi.log.Infof("Read %08x %02x", address, readMask)
 	}
 	return 0, nil
 }
 
 // Write writes data to SPI registers
+// Only the byte lanes in writeMask are shown
 func (spi *SPI) Write(address uint32, value uint32, writeMask uint8) error {
+	value &= core.LaneMask(writeMask)
 	switch address {
 	case 0: // SPI_CSR
 		spi.log.Infof("Write SPI_CSR = %08x", value)
@@ -55,15 +57,15 @@ func (spi *SPI) Write(address uint32, value uint32, writeMask uint8) error {
 }
 
 // Map maps the SPI Controller into the specified bus with specified base address
-func (spi *SPI) Map(baseAddress uint32, bus *core.Bus) error {
-	rhandle := func(ctx context.Context, address uint32) (uint32, error) {
-		return spi.Read(address - baseAddress)
+func (spi *SPI) Map(baseAddress uint64, bus *core.Bus) error {
+	rhandle := func(ctx context.Context, address uint64, readMask byte) (uint32, error) {
+		return spi.Read(uint32(address-baseAddress), readMask)
 	}
-	whandle := func(ctx context.Context, address, value uint32, writeMask byte) error {
-		return spi.Write(address-baseAddress, value, writeMask)
+	whandle := func(ctx context.Context, address uint64, value uint32, writeMask byte) error {
+		return spi.Write(uint32(address-baseAddress), value, writeMask)
 	}
 
-	err := bus.Map("dummySPI", baseAddress, baseAddress+256, rhandle, whandle)
+	err := bus.MapIO("dummySPI", baseAddress, baseAddress+256, rhandle, whandle)
 	if err != nil {
 		return fmt.Errorf("(DummySPI) cannot map dummy spi: %s", err)
 	}
@@ -11,18 +11,37 @@ type UART struct {
 	sync.RWMutex
 	inputBuffer  []byte
 	outputBuffer []byte
+	irq          core.IRQLine
 }
 
 func NewUART() *UART {
 	return &UART{}
 }
 
+// SetIRQ sets the interrupt line used to signal received data
+// The line is asserted while the input buffer is not empty
+func (uart *UART) SetIRQ(irq core.IRQLine) {
+	uart.Lock()
+	defer uart.Unlock()
+
+	uart.irq = irq
+	uart.updateIRQ()
+}
+
+// updateIRQ updates the RX interrupt line. Lock should be held by caller
+func (uart *UART) updateIRQ() {
+	if uart.irq != nil {
+		uart.irq.Set(len(uart.inputBuffer) > 0)
+	}
+}
+
 // PutC puts a character in UART input buffer
 func (uart *UART) PutC(c byte) {
 	uart.Lock()
 	defer uart.Unlock()
 
 	uart.inputBuffer = append(uart.inputBuffer, c)
+	uart.updateIRQ()
 }
 
 func (uart *UART) ReadOutputBuffer() []byte {
@@ -34,37 +53,40 @@ func (uart *UART) ReadOutputBuffer() []byte {
 }
 
 // Write writes data to UART output buffer
+// Only writes that include the first byte lane of the data register output a character
 func (uart *UART) Write(address uint32, value uint32, writeMask uint8) error {
 	uart.Lock()
 	defer uart.Unlock()
-	if address == 0 {
+	if address == 0 && writeMask&1 > 0 {
 		uart.outputBuffer = append(uart.outputBuffer, byte(value&0xFF))
 	}
 	return nil
 }
 
 // Read data from UART input buffer
-func (uart *UART) Read(address uint32) (uint32, error) {
+// A character is only consumed by reads that include the first byte lane of the data register
+func (uart *UART) Read(address uint32, readMask uint8) (uint32, error) {
 	uart.Lock()
 	defer uart.Unlock()
-	if len(uart.inputBuffer) > 0 && address == 0 {
+	if len(uart.inputBuffer) > 0 && address == 0 && readMask&1 > 0 {
 		v := uart.inputBuffer[0]
 		uart.inputBuffer = uart.inputBuffer[1:]
+		uart.updateIRQ()
 		return uint32(v), nil
 	}
 	return 0xFFFFFFFF, nil
 }
 
 // Map maps the memory into the specified bus with specified base address
-func (uart *UART) Map(baseAddress uint32, bus *core.Bus) error {
-	rhandle := func(ctx context.Context, address uint32) (uint32, error) {
-		return uart.Read(address - baseAddress)
+func (uart *UART) Map(baseAddress uint64, bus *core.Bus) error {
+	rhandle := func(ctx context.Context, address uint64, readMask byte) (uint32, error) {
+		return uart.Read(uint32(address-baseAddress), readMask)
 	}
-	whandle := func(ctx context.Context, address, value uint32, writeMask byte) error {
-		return uart.Write(address-baseAddress, value, writeMask)
+	whandle := func(ctx context.Context, address uint64, value uint32, writeMask byte) error {
+		return uart.Write(uint32(address-baseAddress), value, writeMask)
 	}
 
-	err := bus.Map("uart", baseAddress, baseAddress+8, rhandle, whandle)
+	err := bus.MapIO("uart", baseAddress, baseAddress+8, rhandle, whandle)
 	if err != nil {
 		return fmt.Errorf("(UART) cannot map uart: %s", err)
 	}
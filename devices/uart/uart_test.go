@@ -0,0 +1,36 @@
+package uart
+
+import (
+	"github.com/racerxdl/riscv-emulator/core"
+	"testing"
+)
+
+func TestUART_RXIRQ(t *testing.T) {
+	cpu := core.CreateEmulator(nil)
+	u := NewUART()
+	u.PutC('a')
+
+	// Data received before the line is set asserts it
+	u.SetIRQ(cpu.InterruptLine(core.InterruptMachineExternal))
+	if !cpu.InterruptPending(core.InterruptMachineExternal) {
+		t.Errorf("expected the rx interrupt to be pending")
+	}
+	u.PutC('b')
+
+	// Reads without the data lane do not consume a character
+	if v, _ := u.Read(0, 0b0010); v != 0xFFFFFFFF {
+		t.Errorf("expected no data got %08x", v)
+	}
+
+	for _, expected := range []uint32{'a', 'b'} {
+		if !cpu.InterruptPending(core.InterruptMachineExternal) {
+			t.Errorf("expected the rx interrupt to be pending before reading %c", expected)
+		}
+		if v, _ := u.Read(0, 0b0001); v != expected {
+			t.Errorf("expected %02x got %08x", expected, v)
+		}
+	}
+	if cpu.InterruptPending(core.InterruptMachineExternal) {
+		t.Errorf("expected the rx interrupt to be cleared with an empty buffer")
+	}
+}
@@ -1,14 +1,39 @@
 package vga
 
+import "github.com/racerxdl/riscv-emulator/core"
+
 // IncFrame increments frame counter in VGA
 func (vga *VGA) IncFrame() {
+	vga.Lock()
+	defer vga.Unlock()
 	vga.frameCount++
 }
 
 // VBlank sets the vblank status flag
+// If a vblank interrupt line is set, it is asserted during the vblank (the line is level sensitive)
 func (vga *VGA) VBlank(on bool) {
+	vga.Lock()
+	defer vga.Unlock()
+
 	vga.vblank = 0
 	if on {
 		vga.vblank = 1
 	}
+	vga.updateIRQ()
+}
+
+// SetVBlankIRQ sets the interrupt line used to signal the vblank
+func (vga *VGA) SetVBlankIRQ(irq core.IRQLine) {
+	vga.Lock()
+	defer vga.Unlock()
+
+	vga.vblankIRQ = irq
+	vga.updateIRQ()
+}
+
+// updateIRQ updates the vblank interrupt line. Lock should be held by caller
+func (vga *VGA) updateIRQ() {
+	if vga.vblankIRQ != nil {
+		vga.vblankIRQ.Set(vga.vblank > 0)
+	}
 }
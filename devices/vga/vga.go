@@ -2,6 +2,7 @@ package vga
 
 import (
 	"context"
+	"encoding/binary"
 	"fmt"
 	"github.com/racerxdl/riscv-emulator/core"
 	"image/color"
@@ -26,6 +27,7 @@ type VGA struct {
 	height     int
 	frameCount uint32
 	vblank     uint32
+	vblankIRQ  core.IRQLine
 }
 
 // NewVGA creates and initialize a new VGA Device
@@ -33,7 +35,7 @@ func NewVGA(width, height int) *VGA {
 	v := &VGA{
 		width:  width,
 		height: height,
-		screen: make([]uint8, width*height),
+		screen: make([]uint8, (width*height+3)&^3),
 	}
 
 	for i := 0; i < 256; i++ {
@@ -45,49 +47,43 @@ func NewVGA(width, height int) *VGA {
 
 // ReadStatus return the status register
 func (vga *VGA) ReadStatus(address uint32) (uint32, error) {
+	vga.RLock()
+	defer vga.RUnlock()
 	v := (vga.frameCount & 0xFFFF) | (vga.vblank << 16)
 	//fmt.Printf("STATUS: %032b - Frame Count: %d - VBLANK %d\n", v, vga.frameCount, vga.vblank)
 	return v, nil
 }
 
-// WriteScreen writes to the screen buffer
-// This call ignores mask, and always use the LSB byte of the uint32
-// The address maps to each point in the screen, even though they're only one byte wide
-// So for acessing point X of the screen, you should give address = X * 4
+// WriteScreen writes the byte lanes in mask to the screen buffer
+// Each pixel is one byte wide, so a word write changes four pixels and a byte write a single one
 func (vga *VGA) WriteScreen(address uint32, value uint32, mask uint8) error {
 	vga.Lock()
 	defer vga.Unlock()
 
-	//bval := uint8(value & 0xFF)
-	if uint32(len(vga.screen)) < address {
+	if uint64(address)+4 > uint64(len(vga.screen)) {
 		return fmt.Errorf("invalid write at screen address %08x", address)
 	}
 
-	vga.screen[address] = uint8(value & 0xFF)
-	vga.screen[address+1] = uint8((value & 0xFF00) >> 8)
-	vga.screen[address+2] = uint8((value & 0xFF0000) >> 16)
-	vga.screen[address+3] = uint8((value & 0xFF000000) >> 24)
-	//fmt.Printf("Wrote Screen at %d with %d (mask %d)\n", address, bval, mask)
+	for i := uint32(0); i < 4; i++ {
+		if mask&(1<<i) > 0 {
+			vga.screen[address+i] = uint8(value >> (8 * i))
+		}
+	}
 	return nil
 }
 
-// WriteScreen writes to the palette buffer
-// This call only accepts full word write and doesn't accept unaligned access
+// WritePAL writes the byte lanes in mask to the palette buffer
+// Each palette entry is a word with the blue, green and red components in the lower three bytes
 func (vga *VGA) WritePAL(address uint32, value uint32, mask uint8) error {
 	vga.Lock()
 	defer vga.Unlock()
-	if address%4 != 0 {
-		return fmt.Errorf("unaligned access at palette write address %08x", address)
-	}
-	if mask != 15 {
-		return fmt.Errorf("unsupported mask %04b at palette write address %08x", mask, address)
-	}
 	address /= 4
 
 	if address >= 256 {
 		return fmt.Errorf("invalid write at palette address %08x", address*4)
 	}
 
+	value = core.MergeLanes(vga.palValue(address), value, mask)
 	vga.palette[address] = color.RGBA{
 		B: uint8((value >> 0) & 0xFF),
 		G: uint8((value >> 8) & 0xFF),
@@ -98,82 +94,75 @@ func (vga *VGA) WritePAL(address uint32, value uint32, mask uint8) error {
 	return nil
 }
 
-// ReadScreen reads from screen buffer
-// It does not support unaligned access, and each screen pixel is mapped as a word
-// So for acessing pixel X you should access X * 4
+// ReadScreen reads four pixels from the screen buffer
 func (vga *VGA) ReadScreen(address uint32) (uint32, error) {
 	//fmt.Printf("ReadScreen at %08x\n", address)
 	vga.RLock()
 	defer vga.RUnlock()
 
-	if address%4 != 0 {
-		return 0, fmt.Errorf("unaligned access at screen read address %08x", address)
-	}
-	address /= 4
-
-	if uint32(len(vga.screen)) < address {
-		return 0, fmt.Errorf("invalid write at screen address %08x", address)
+	if uint64(address)+4 > uint64(len(vga.screen)) {
+		return 0, fmt.Errorf("invalid read at screen address %08x", address)
 	}
 
-	return uint32(vga.screen[address]), nil
+	return binary.LittleEndian.Uint32(vga.screen[address:]), nil
 }
 
 // ReadPAL reads from palette buffer
-// It does not support unaligned access
 func (vga *VGA) ReadPAL(address uint32) (uint32, error) {
 	//fmt.Printf("ReadPAL at %08x\n", address)
 	vga.RLock()
 	defer vga.RUnlock()
-	if address%4 != 0 {
-		return 0, fmt.Errorf("unaligned access at palette read address %08x", address)
-	}
 	address /= 4
 
 	if address >= 256 {
 		return 0, fmt.Errorf("invalid read at palette address %08x", address*4)
 	}
 
-	v := uint32(vga.palette[address].B)
-	v |= uint32(vga.palette[address].G) << 8
-	v |= uint32(vga.palette[address].R) << 16
-	v |= uint32(vga.palette[address].A) << 24
+	return vga.palValue(address), nil
+}
 
-	return v, nil
+// palValue returns the palette entry packed in a word. Lock should be held by caller
+func (vga *VGA) palValue(index uint32) uint32 {
+	v := uint32(vga.palette[index].B)
+	v |= uint32(vga.palette[index].G) << 8
+	v |= uint32(vga.palette[index].R) << 16
+	v |= uint32(vga.palette[index].A) << 24
+	return v
 }
 
 // Map maps the palette and screen into the specified bus with specified base address
-func (vga *VGA) Map(baseAddress uint32, bus *core.Bus) error {
-	palRHandle := func(ctx context.Context, address uint32) (uint32, error) {
-		return vga.ReadPAL(address - baseAddress - PaletteAddressOffset)
+func (vga *VGA) Map(baseAddress uint64, bus *core.Bus) error {
+	palRHandle := func(ctx context.Context, address uint64, readMask byte) (uint32, error) {
+		return vga.ReadPAL(uint32(address - baseAddress - PaletteAddressOffset))
 	}
-	palWHandle := func(ctx context.Context, address, value uint32, writeMask byte) error {
-		return vga.WritePAL(address-baseAddress-PaletteAddressOffset, value, writeMask)
+	palWHandle := func(ctx context.Context, address uint64, value uint32, writeMask byte) error {
+		return vga.WritePAL(uint32(address-baseAddress-PaletteAddressOffset), value, writeMask)
 	}
 
-	err := bus.Map(PaletteMapName, baseAddress+PaletteAddressOffset, baseAddress+ScreenAddressOffset, palRHandle, palWHandle)
+	err := bus.MapIO(PaletteMapName, baseAddress+PaletteAddressOffset, baseAddress+ScreenAddressOffset, palRHandle, palWHandle)
 	if err != nil {
 		return fmt.Errorf("cannot map vga palette: %s", err)
 	}
 
-	screenRHandle := func(ctx context.Context, address uint32) (uint32, error) {
-		return vga.ReadScreen(address - baseAddress - ScreenAddressOffset)
+	screenRHandle := func(ctx context.Context, address uint64, readMask byte) (uint32, error) {
+		return vga.ReadScreen(uint32(address - baseAddress - ScreenAddressOffset))
 	}
-	screenWHandle := func(ctx context.Context, address, value uint32, writeMask byte) error {
-		return vga.WriteScreen(address-baseAddress-ScreenAddressOffset, value, writeMask)
+	screenWHandle := func(ctx context.Context, address uint64, value uint32, writeMask byte) error {
+		return vga.WriteScreen(uint32(address-baseAddress-ScreenAddressOffset), value, writeMask)
 	}
 
-	screenSize := uint32(len(vga.screen) * 4) // We align each byte inside a word
+	screenSize := uint64(len(vga.screen)+3) &^ 3 // One byte per pixel
 
-	err = bus.Map(ScreenMapName, baseAddress+ScreenAddressOffset, baseAddress+ScreenAddressOffset+screenSize, screenRHandle, screenWHandle)
+	err = bus.MapIO(ScreenMapName, baseAddress+ScreenAddressOffset, baseAddress+ScreenAddressOffset+screenSize, screenRHandle, screenWHandle)
 	if err != nil {
 		return fmt.Errorf("cannot map vga screen: %s", err)
 	}
 
-	controlRHandle := func(ctx context.Context, address uint32) (uint32, error) {
-		return vga.ReadStatus(address)
+	controlRHandle := func(ctx context.Context, address uint64, readMask byte) (uint32, error) {
+		return vga.ReadStatus(uint32(address - baseAddress - ControlAddressOffset))
 	}
 
-	err = bus.Map(ControlMapName, baseAddress+ControlAddressOffset, baseAddress+ControlAddressOffset+4, controlRHandle, nil)
+	err = bus.MapIO(ControlMapName, baseAddress+ControlAddressOffset, baseAddress+ControlAddressOffset+4, controlRHandle, nil)
 	if err != nil {
 		return fmt.Errorf("cannot map vga screen: %s", err)
 	}
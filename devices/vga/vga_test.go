@@ -0,0 +1,109 @@
+package vga
+
+import (
+	"context"
+	"errors"
+	"github.com/racerxdl/riscv-emulator/core"
+	"testing"
+)
+
+func TestVGA_Fetch(t *testing.T) {
+	ctx := context.Background()
+	cpu := core.CreateEmulator(nil)
+	cpu.SetTrapPolicy(core.TrapPolicyStop)
+	v := NewVGA(320, 200)
+
+	if err := v.Map(0x8100_0000, cpu.Bus); err != nil {
+		t.Fatal(err)
+	}
+
+	// The screen can be read and written, but not executed
+	if err := cpu.Bus.WriteWord(ctx, 0x8100_0000+ScreenAddressOffset, 0x00000013); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := cpu.Bus.ReadWord(ctx, 0x8100_0000+ScreenAddressOffset); err != nil || v != 0x00000013 {
+		t.Errorf("expected screen to be 00000013 got %08x (%v)", v, err)
+	}
+
+	cpu.SetPC(0x8100_0000 + ScreenAddressOffset)
+	err := cpu.RunStep(ctx)
+	var ex core.Exception
+	if !errors.As(err, &ex) || ex.Cause != core.ExceptionInstructionAccessFault {
+		t.Errorf("expected instruction access fault got %v", err)
+	}
+}
+
+func TestVGA_Lanes(t *testing.T) {
+	ctx := context.Background()
+	bus := core.CreateBus(nil)
+	v := NewVGA(4, 2)
+
+	if err := v.Map(0, bus); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := bus.WriteWord(ctx, ScreenAddressOffset, 0x04030201); err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.WriteByte(ctx, ScreenAddressOffset+6, 0xFF); err != nil {
+		t.Fatal(err)
+	}
+	expected := []uint8{1, 2, 3, 4, 0, 0, 0xFF, 0}
+	for i, e := range expected {
+		if v.screen[i] != e {
+			t.Errorf("expected pixel %d to be %d got %d", i, e, v.screen[i])
+		}
+	}
+
+	// Writing the green component of a palette entry keeps the others
+	if err := bus.WriteWord(ctx, PaletteAddressOffset+4, 0x00112233); err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.WriteByte(ctx, PaletteAddressOffset+5, 0xAA); err != nil {
+		t.Fatal(err)
+	}
+	if c := v.palette[1]; c.R != 0x11 || c.G != 0xAA || c.B != 0x33 {
+		t.Errorf("expected palette 1 to be 11aa33 got %02x%02x%02x", c.R, c.G, c.B)
+	}
+}
+
+func TestVGA_VBlankIRQ(t *testing.T) {
+	cpu := core.CreateEmulator(nil)
+	v := NewVGA(4, 2)
+	v.SetVBlankIRQ(cpu.InterruptLine(core.InterruptMachineExternal))
+
+	// The line stays asserted for the whole vblank, so the hart can see it
+	v.VBlank(true)
+	if !cpu.InterruptPending(core.InterruptMachineExternal) {
+		t.Errorf("expected the vblank interrupt to be pending")
+	}
+	if status, _ := v.ReadStatus(0); status&(1<<16) == 0 {
+		t.Errorf("expected vblank in status got %08x", status)
+	}
+	v.VBlank(true)
+	if !cpu.InterruptPending(core.InterruptMachineExternal) {
+		t.Errorf("expected the vblank interrupt to be pending during the vblank")
+	}
+
+	v.VBlank(false)
+	if cpu.InterruptPending(core.InterruptMachineExternal) {
+		t.Errorf("expected the vblank interrupt to be cleared after the vblank")
+	}
+
+	// The flag and the line can be driven from other goroutines
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			v.VBlank(i%2 == 0)
+			v.IncFrame()
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		_, _ = v.ReadStatus(0)
+	}
+	<-done
+	if status, _ := v.ReadStatus(0); status != 100 {
+		t.Errorf("expected 100 frames without vblank got %08x", status)
+	}
+}
@@ -12,7 +12,22 @@ func init() {
 	dism = isa
 }
 
+// SetISA configures the disassembler with the XLEN and the misa extension bits of the core
+// (as in core.RISCV.ISA().MISA()), so only instructions implemented by the core are decoded
+func SetISA(xlen int, misa uint64) error {
+	ext := uint(misa & (1<<26 - 1)) // rvda uses the same extension bits as misa
+	if ext&rvda.ExtE != 0 {         // The E base has the same instructions as I
+		ext |= rvda.ExtI
+	}
+	isa, err := rvda.New(uint(xlen), ext)
+	if err != nil {
+		return err
+	}
+	dism = isa
+	return nil
+}
+
 // Disasm disassembles the specified instruction in the specified address
-func Disasm(addr, ins uint32) string {
+func Disasm(addr uint64, ins uint32) string {
 	return dism.Disassemble(uint(addr), uint(ins)).String()
 }
@@ -0,0 +1,40 @@
+.global _boot
+.text
+
+_boot:
+  lui x1, 0x10                /* x1 = 0x10000 */
+  li x2, 100
+  sw x2, 0(x1)                /* mem = 0x00000064 */
+
+  /* Test AMOs */
+  li x3, 5
+  amoadd.w x4, x3, (x1)       /* x4  = 0x00000064 mem = 0x00000069 */
+  li x3, 0xF
+  amoxor.w x5, x3, (x1)       /* x5  = 0x00000069 mem = 0x00000066 */
+  li x3, 0x100
+  amoor.w x6, x3, (x1)        /* x6  = 0x00000066 mem = 0x00000166 */
+  li x3, 0xF0
+  amoand.w x7, x3, (x1)       /* x7  = 0x00000166 mem = 0x00000060 */
+  li x3, -1
+  amoswap.w x8, x3, (x1)      /* x8  = 0x00000060 mem = 0xFFFFFFFF */
+  li x3, 5
+  amomin.w x9, x3, (x1)       /* x9  = 0xFFFFFFFF mem = 0xFFFFFFFF */
+  amomax.w x10, x3, (x1)      /* x10 = 0xFFFFFFFF mem = 0x00000005 */
+  li x3, -1
+  amominu.w x11, x3, (x1)     /* x11 = 0x00000005 mem = 0x00000005 */
+  amomaxu.w x12, x3, (x1)     /* x12 = 0x00000005 mem = 0xFFFFFFFF */
+  lw x13, 0(x1)               /* x13 = 0xFFFFFFFF */
+
+  /* Test LR / SC */
+  lr.w x14, (x1)              /* x14 = 0xFFFFFFFF */
+  li x3, 42
+  sc.w x15, x3, (x1)          /* x15 = 0 mem = 0x0000002A */
+  sc.w x14, x3, (x1)          /* x14 = 1 (no reservation) */
+  lw x13, 0(x1)               /* x13 = 0x0000002A */
+  nop
+
+  /* Test reservation invalidation */
+  lr.w x14, (x1)              /* x14 = 0x0000002A */
+  nop                         /* Another bus master writes here */
+  sc.w x15, x3, (x1)          /* x15 = 1 */
+  nop
@@ -0,0 +1,44 @@
+.global _boot
+.text
+
+_boot:
+  li x1, 0x80000001
+  li x2, 0x00F0FF00
+  li x3, 3
+
+  /* Zba */
+  sh1add x4, x3, x2           /* x4  = 0x00F0FF06 */
+  sh2add x5, x3, x2           /* x5  = 0x00F0FF0C */
+  sh3add x6, x3, x2           /* x6  = 0x00F0FF18 */
+
+  /* Zbb */
+  andn x7, x2, x1             /* x7  = 0x00F0FF00 */
+  orn x8, x3, x2              /* x8  = 0xFF0F00FF */
+  xnor x9, x1, x2             /* x9  = 0x7F0F00FE */
+  clz x10, x2                 /* x10 = 8 */
+  ctz x11, x2                 /* x11 = 8 */
+  cpop x12, x2                /* x12 = 12 */
+  li x13, 0x1280
+  sext.b x14, x13             /* x14 = 0xFFFFFF80 */
+  sext.h x15, x1              /* x15 = 0x00000001 */
+  zext.h x16, x1              /* x16 = 0x00000001 */
+  max x17, x1, x3             /* x17 = 3 */
+  maxu x18, x1, x3            /* x18 = 0x80000001 */
+  min x19, x1, x3             /* x19 = 0x80000001 */
+  minu x20, x1, x3            /* x20 = 3 */
+  rol x21, x1, x3             /* x21 = 0x0000000C */
+  rori x22, x2, 12            /* x22 = 0xF0000F0F */
+  rev8 x23, x2                /* x23 = 0x00FFF000 */
+  orc.b x24, x1               /* x24 = 0xFF0000FF */
+
+  /* Zbs */
+  bset x25, x2, x3            /* x25 = 0x00F0FF08 */
+  bclri x26, x2, 8            /* x26 = 0x00F0FE00 */
+  binvi x27, x1, 31           /* x27 = 0x00000001 */
+  bext x28, x2, x3            /* x28 = 0 */
+  bexti x29, x2, 23           /* x29 = 1 */
+
+  /* Base shift right arithmetic */
+  li x30, -16
+  sra x31, x30, x3            /* x31 = 0xFFFFFFFE */
+  nop
@@ -0,0 +1,39 @@
+.global _boot
+.text
+.option rvc
+
+_boot:
+  c.li x1, 5                  /* x1  = 5 */
+.option norvc
+  addi x2, x1, 10             /* x2  = 15 (crosses a word boundary) */
+.option rvc
+  c.mv x3, x2                 /* x3  = 15 */
+  c.jal func1                 /* x5  = return address of c.jal (pc + 2) */
+.option norvc
+  jal x4, func2               /* x4  = x8 = return address of jal (pc + 4) */
+.option rvc
+  c.slli x3, 4                /* x3  = 0xF0 */
+  c.li x11, 0
+  c.beqz x11, skip
+  c.li x11, 1                 /* Skipped */
+skip:
+  auipc x6, 0
+  c.addi x6, 16               /* x6  = func3 */
+  c.jalr x6                   /* x1  = return address of c.jalr (pc + 2) */
+  c.addi16sp x2, 16           /* x2  = 31 */
+  c.lui x9, 1                 /* x9  = 0x1000 */
+  c.sub x9, x10               /* x9  = 0x1000 - x10 */
+end:
+  c.j end
+
+func3:
+  c.li x10, 7                 /* x10 = 7 */
+  c.jr x1
+
+func1:
+  c.mv x5, x1
+  c.jr x1
+
+func2:
+  c.mv x8, x4
+  jalr x0, 0(x4)
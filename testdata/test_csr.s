@@ -0,0 +1,32 @@
+.global _boot
+.text
+
+_boot:
+  csrr x1, mhartid            /* x1  = 0x00000000 */
+  csrr x2, misa               /* x2  = 0x4000112D (RV32IMAFDC) */
+
+  /* Test CSRRW / CSRRS / CSRRC */
+  li x3, 0x12345678
+  csrw mscratch, x3
+  csrr x4, mscratch           /* x4  = 0x12345678 */
+  li x5, 0xF0
+  csrrs x6, mscratch, x5      /* x6  = 0x12345678 mscratch = 0x123456F8 */
+  csrrc x7, mscratch, x5      /* x7  = 0x123456F8 mscratch = 0x12345608 */
+
+  /* Test CSRRWI / CSRRSI / CSRRCI */
+  csrrwi x8, mscratch, 0x1F   /* x8  = 0x12345608 mscratch = 0x0000001F */
+  csrrsi x9, mscratch, 0      /* x9  = 0x0000001F */
+  csrrci x10, mscratch, 3     /* x10 = 0x0000001F mscratch = 0x0000001C */
+  csrr x11, mscratch          /* x11 = 0x0000001C */
+
+  /* Test WARL */
+  csrw misa, x0               /* misa is not writable */
+  csrr x12, misa              /* x12 = 0x4000112D */
+
+  /* Test Counters */
+  csrr x13, minstret          /* x13 = 15 */
+  nop
+
+  /* Test Read Only */
+  csrw mhartid, x1            /* illegal instruction */
+  nop
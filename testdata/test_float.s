@@ -0,0 +1,50 @@
+.global _boot
+.text
+
+_boot:
+  lui x1, 0x10                /* x1 = 0x10000 */
+  li x2, 0x40400000
+  sw x2, 0(x1)                /* mem = 3.0 */
+  flw f1, 0(x1)               /* f1 = 3.0 */
+  li x2, 1
+  fcvt.s.w f2, x2             /* f2 = 1.0 */
+
+  /* Rounding modes and flags */
+  fdiv.s f3, f2, f1           /* f3 = 1/3 rounded to nearest */
+  fmv.x.w x3, f3              /* x3 = 0x3EAAAAAB */
+  fdiv.s f4, f2, f1, rtz      /* f4 = 1/3 rounded towards zero */
+  fmv.x.w x4, f4              /* x4 = 0x3EAAAAAA */
+  frflags x5                  /* x5 = 0x01 (NX) */
+  fsflags x0                  /* fflags = 0 */
+  fsrmi 3                     /* frm = RUP */
+  fdiv.s f5, f2, f1, dyn      /* f5 = 1/3 rounded up */
+  fmv.x.w x6, f5              /* x6 = 0x3EAAAAAB */
+  frcsr x7                    /* x7 = 0x61 (RUP, NX) */
+  fscsr x0                    /* fcsr = 0 */
+
+  /* Fused multiply add, compares, conversions */
+  fmadd.s f6, f1, f1, f2      /* f6 = 3 * 3 + 1 = 10.0 */
+  fcvt.w.s x8, f6             /* x8 = 10 */
+  feq.s x9, f6, f6            /* x9 = 1 */
+  flt.s x10, f6, f1           /* x10 = 0 */
+  fclass.s x11, f6            /* x11 = 0x40 (positive normal) */
+
+  /* Double precision */
+  fcvt.d.w f7, x8             /* f7 = 10.0 */
+  fsqrt.d f8, f7              /* f8 = sqrt(10) */
+  fsd f8, 8(x1)
+  lw x12, 8(x1)               /* x12 = 0x3ADA5B53 */
+  lw x13, 12(x1)              /* x13 = 0x40094C58 */
+
+  /* NaN-boxing */
+  fmv.x.w x14, f8             /* x14 = 0x3ADA5B53 (raw lower bits) */
+  fclass.s x15, f8            /* x15 = 0x200 (not boxed, canonical NaN) */
+  fsgnjn.s f9, f8, f8         /* f9 = -NaN(canonical) */
+  fmv.x.w x2, f9              /* x2 = 0xFFC00000 */
+  fcvt.s.d f10, f8            /* f10 = sqrt(10) as single */
+  fmv.x.w x3, f10             /* x3 = 0x404A62C2 */
+  fcvt.wu.s x4, f9            /* x4 = 0xFFFFFFFF (NaN) */
+  frflags x5                  /* x5 = 0x11 (NV, NX) */
+
+end:
+  j end
@@ -0,0 +1,39 @@
+.global _boot
+.text
+
+_boot:
+  li x1, 0x81
+  csrw mtvec, x1              /* Vectored trap handler at 0x80 */
+  li x2, 0x88
+  csrw mie, x2                /* mie.MSIE = 1 mie.MTIE = 1 */
+  csrsi mstatus, 0x8          /* mstatus.MIE = 1 */
+
+loop:
+  addi x3, x3, 1
+  j loop                      /* Wait for interrupts */
+
+.org 0x80
+trap_vector:
+  j exception_handler         /* 0x80 Exceptions */
+  nop
+  nop
+  j msi_handler               /* 0x8C Machine Software Interrupt */
+  nop
+  nop
+  nop
+  j mti_handler               /* 0x9C Machine Timer Interrupt */
+
+exception_handler:
+  j exception_handler
+
+msi_handler:
+  csrr x13, mcause            /* x13 = 0x80000003 */
+msi_done:
+  j msi_done
+
+mti_handler:
+  csrr x10, mcause            /* x10 = 0x80000007 */
+  csrr x11, mepc              /* x11 = 0x14 or 0x18 */
+  csrr x12, mstatus           /* x12 = 0x3880 (MPP = M, MPIE, FS = Initial) */
+mti_done:
+  j mti_done
@@ -0,0 +1,43 @@
+.global _boot
+.text
+
+_boot:
+  /* Test MUL */
+  li x1, 1000
+  li x2, -3
+  li x3, 0x80000000
+  li x4, -1
+
+  mul x15, x1, x1       /* x15 = 1000000    0x000F4240 */
+  mul x14, x1, x2       /* x14 = -3000      0xFFFFF448 */
+  mul x13, x3, x4       /* x13 = 0x80000000 (overflow) */
+  mul x12, x2, x2       /* x12 = 9          0x00000009 */
+
+  /* Test MULH / MULHSU / MULHU */
+  mulh   x15, x3, x3    /* x15 = 0x40000000 */
+  mulh   x14, x2, x1    /* x14 = 0xFFFFFFFF */
+  mulhsu x13, x2, x4    /* x13 = 0xFFFFFFFD */
+  mulhsu x12, x1, x4    /* x12 = 0x000003E7 */
+  mulhu  x11, x4, x4    /* x11 = 0xFFFFFFFE */
+  mulhu  x10, x1, x1    /* x10 = 0x00000000 */
+
+  /* Test DIV / DIVU */
+  div  x15, x1, x2      /* x15 = -333       0xFFFFFEB3 */
+  div  x14, x1, x0      /* x14 = -1         0xFFFFFFFF (division by zero) */
+  div  x13, x3, x4      /* x13 = 0x80000000 (overflow) */
+  divu x12, x4, x1      /* x12 = 0x00418937 */
+  divu x11, x1, x0      /* x11 = 0xFFFFFFFF (division by zero) */
+  divu x10, x3, x4      /* x10 = 0x00000000 */
+
+  /* Test REM / REMU */
+  rem  x15, x1, x2      /* x15 = 1          0x00000001 */
+  rem  x14, x2, x1      /* x14 = -3         0xFFFFFFFD */
+  rem  x13, x1, x0      /* x13 = 1000       0x000003E8 (division by zero) */
+  rem  x12, x3, x4      /* x12 = 0          0x00000000 (overflow) */
+  remu x11, x4, x1      /* x11 = 0x00000127 */
+  remu x10, x2, x0      /* x10 = 0xFFFFFFFD (division by zero) */
+
+  nop
+  nop
+  nop
+  nop
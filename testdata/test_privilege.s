@@ -0,0 +1,71 @@
+.global _boot
+.text
+
+_boot:
+  li x1, 0x100
+  csrw mtvec, x1              /* Machine trap handler at 0x100 */
+  li x1, 0x180
+  csrw stvec, x1              /* Supervisor trap handler at 0x180 */
+  li x1, 0x100
+  csrw medeleg, x1            /* Delegate ecall from U mode */
+  li x1, 0x2
+  csrw mideleg, x1            /* Delegate supervisor software interrupt */
+  li x1, 0x1800
+  csrc mstatus, x1
+  li x1, 0x800
+  csrs mstatus, x1            /* mstatus.MPP = S */
+  li x1, 0x60
+  csrw mepc, x1
+  mret                        /* Go to supervisor mode at 0x60 */
+
+.org 0x60
+supervisor:
+  csrr x2, mstatus            /* Illegal in S mode, mcause = 2 */
+  mv x3, x15                  /* x3  = 2 */
+  mv x4, x14                  /* x4  = 0x800 (MPP = S) */
+  ecall                       /* Not delegated, mcause = 9 */
+  mv x5, x15                  /* x5  = 9 */
+  li x1, 0x2
+  csrs sie, x1                /* sie.SSIE = 1 */
+  csrs sip, x1                /* sip.SSIP = 1, not taken while sstatus.SIE = 0 */
+  li x1, 0x20
+  csrs sstatus, x1            /* sstatus.SPIE = 1 */
+  li x1, 0xC0
+  csrw sepc, x1
+  sret                        /* Go to user mode at 0xC0 (SPP = U) */
+
+.org 0xC0
+user:                         /* Supervisor software interrupt is taken here, x10 = 0x80000001 */
+  ecall                       /* Delegated, scause = 8 */
+  mv x11, x6                  /* x11 = 8 */
+  mv x12, x7                  /* x12 = 0x20 (SPIE = 1, SPP = U) */
+  csrr x13, sscratch          /* Illegal in U mode, mcause = 2 */
+  mv x13, x15                 /* x13 = 2 */
+  sret                        /* Illegal in U mode, mcause = 2 */
+  mv x9, x14                  /* x9  = 0x22 (MPP = U, SPIE = 1, SIE = 1) */
+  mv x8, x15                  /* x8  = 2 */
+done:
+  j done
+
+.org 0x100
+machine_handler:
+  csrr x15, mcause
+  csrr x14, mstatus
+  csrr x1, mepc
+  addi x1, x1, 4
+  csrw mepc, x1
+  mret
+
+.org 0x180
+supervisor_handler:
+  csrr x6, scause
+  bltz x6, supervisor_interrupt
+  csrr x7, sstatus
+  csrr x1, sepc
+  addi x1, x1, 4
+  csrw sepc, x1
+  sret
+supervisor_interrupt:
+  mv x10, x6                  /* x10 = 0x80000001 */
+  csrci sip, 0x2
+  sret
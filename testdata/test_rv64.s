@@ -0,0 +1,67 @@
+.global _boot
+.text
+
+_boot:
+  li x1, 1
+  slli x1, x1, 32             /* x1 = 0x00000001_00000000 (memory above 4 GiB) */
+  lui x2, 0x80000             /* x2 = 0xFFFFFFFF_80000000 (lui sign extends) */
+  li x3, -1                   /* x3 = 0xFFFFFFFF_FFFFFFFF */
+
+  /* Test 64 bit loads / stores */
+  sd x3, 0(x1)                /* mem = 0xFFFFFFFF_FFFFFFFF */
+  sw x0, 4(x1)                /* mem = 0x00000000_FFFFFFFF */
+  ld x4, 0(x1)                /* x4  = 0x00000000_FFFFFFFF */
+  lw x5, 0(x1)                /* x5  = 0xFFFFFFFF_FFFFFFFF */
+  lwu x6, 0(x1)               /* x6  = 0x00000000_FFFFFFFF */
+  nop
+
+  /* Test *W and 64 bit shifts */
+  addiw x7, x4, 1             /* x7  = 0x00000000_00000000 */
+  addw x8, x2, x2             /* x8  = 0x00000000_00000000 */
+  subw x9, x0, x2             /* x9  = 0xFFFFFFFF_80000000 */
+  slliw x10, x3, 31           /* x10 = 0xFFFFFFFF_80000000 */
+  srliw x11, x2, 4            /* x11 = 0x00000000_08000000 */
+  sraiw x12, x2, 4            /* x12 = 0xFFFFFFFF_F8000000 */
+  srli x13, x3, 1             /* x13 = 0x7FFFFFFF_FFFFFFFF */
+  slli x14, x3, 40            /* x14 = 0xFFFFFF00_00000000 */
+  sraw x15, x2, x9            /* x15 = 0xFFFFFFFF_80000000 (shift by 0) */
+  nop
+
+  /* Test RV64M */
+  mulh x4, x3, x3             /* x4  = 0x00000000_00000000 */
+  mulhu x5, x3, x3            /* x5  = 0xFFFFFFFF_FFFFFFFE */
+  mulw x6, x13, x13           /* x6  = 0x00000000_00000001 */
+  divw x7, x2, x3             /* x7  = 0xFFFFFFFF_80000000 (overflow) */
+  li x9, 16
+  divuw x8, x3, x9            /* x8  = 0x00000000_0FFFFFFF */
+  div x10, x2, x9             /* x10 = 0xFFFFFFFF_F8000000 */
+  remw x11, x3, x0            /* x11 = 0xFFFFFFFF_FFFFFFFF (division by zero) */
+  nop
+
+  /* Test RV64A */
+  li x3, 5
+  amoadd.d x11, x3, (x1)      /* x11 = 0x00000000_FFFFFFFF mem = 0x00000001_00000004 */
+  ld x12, 0(x1)               /* x12 = 0x00000001_00000004 */
+  amoadd.w x13, x3, (x1)      /* x13 = 0x00000000_00000004 mem = 0x00000001_00000009 */
+  lr.d x14, (x1)              /* x14 = 0x00000001_00000009 */
+  sc.d x15, x2, (x1)          /* x15 = 0 mem = 0xFFFFFFFF_80000000 */
+  ld x14, 0(x1)               /* x14 = 0xFFFFFFFF_80000000 */
+  nop
+
+  /* Test RV64F / RV64D */
+  fcvt.d.l f1, x2             /* f1 = -2147483648.0 */
+  fcvt.l.d x4, f1             /* x4  = 0xFFFFFFFF_80000000 */
+  fmv.x.d x5, f1              /* x5  = 0xC1E00000_00000000 */
+  fcvt.s.lu f2, x3            /* f2 = 5.0 */
+  fmv.x.w x6, f2              /* x6  = 0x00000000_40A00000 */
+  fcvt.lu.s x7, f2            /* x7  = 0x00000000_00000005 */
+  nop
+
+  /* Test RV64C */
+  c.li x8, -1
+  c.addiw x8, 1               /* x8  = 0x00000000_00000000 */
+  mv x10, x1
+  c.sd x14, 8(x10)
+  c.ld x9, 8(x10)             /* x9  = 0xFFFFFFFF_80000000 */
+  c.subw x11, x12             /* x11 = 0xFFFFFFFF_FFFFFFFB */
+  nop
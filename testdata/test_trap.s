@@ -0,0 +1,43 @@
+.global _boot
+.text
+
+_boot:
+  li x1, 0x80
+  csrw mtvec, x1              /* Trap handler at 0x80 */
+  li x2, 0x8
+  csrs mstatus, x2            /* mstatus.MIE = 1 */
+
+  /* Test ECALL */
+  ecall                       /* mcause = 11 mepc = 0x10 */
+  mv x5, x15                  /* x5  = 11 */
+
+  /* Test EBREAK */
+  ebreak                      /* mcause = 3  mepc = 0x18 mtval = 0x18 */
+  mv x6, x15                  /* x6  = 3 */
+
+  /* Test Illegal Instruction */
+  .word 0xFFFFFFFF            /* mcause = 2  mepc = 0x20 mtval = 0xFFFFFFFF */
+  mv x7, x15                  /* x7  = 2 */
+
+  /* Test Load / Store Access Fault */
+  lui x3, 0x10                /* Unmapped address 0x10000 */
+  lw x8, 0(x3)                /* mcause = 5  mepc = 0x2C mtval = 0x10000 */
+  mv x8, x15                  /* x8  = 5 */
+  mv x9, x13                  /* x9  = 0x10000 */
+  sw x0, 4(x3)                /* mcause = 7  mepc = 0x38 mtval = 0x10004 */
+  mv x10, x15                 /* x10 = 7 */
+  mv x11, x13                 /* x11 = 0x10004 */
+
+  /* Test MRET restoring MIE */
+  csrr x12, mstatus           /* x12 = 0x3888 */
+  nop
+
+.org 0x80
+trap_handler:
+  csrr x15, mcause
+  csrr x14, mepc
+  csrr x13, mtval
+  csrr x4, mstatus            /* x4  = 0x3880 */
+  addi x14, x14, 4
+  csrw mepc, x14
+  mret